@@ -0,0 +1,198 @@
+package netbug
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+// modulePath is netbug's module path, used to find its version in the
+// binary's build information.
+const modulePath = "github.com/e-dard/netbug"
+
+// aboutInfo describes a Debugger: which version of netbug it is, what it
+// has been configured to do and what the platform supports. Secrets, such
+// as tokens, are never included.
+type aboutInfo struct {
+	Version       string        `json:"version"`
+	Features      aboutFeatures `json:"features"`
+	Compatibility aboutPlatform `json:"compatibility"`
+	Endpoints     []string      `json:"endpoints"`
+}
+
+// aboutFeatures is the configuration of a Debugger.
+type aboutFeatures struct {
+	Auth                 string   `json:"auth"`
+	RequireTLS           bool     `json:"require_tls"`
+	TrustProxyHeaders    bool     `json:"trust_proxy_headers"`
+	ScopedTokens         int      `json:"scoped_tokens"`
+	PeerCredAuth         bool     `json:"peer_cred_auth"`
+	ReadOnly             bool     `json:"read_only"`
+	PublicEndpoints      []string `json:"public_endpoints,omitempty"`
+	Store                string   `json:"store"`
+	CaptureDir           string   `json:"capture_dir,omitempty"`
+	Retention            bool     `json:"retention"`
+	SigningKey           string   `json:"signing_key,omitempty"` // base64 ed25519 public key
+	EncryptedExports     bool     `json:"encrypted_exports"`
+	BinaryDownload       bool     `json:"binary_download"`
+	Source               bool     `json:"source"`
+	TraceFormats         []string `json:"trace_formats,omitempty"`
+	Schedules            []string `json:"schedules,omitempty"`
+	CPUWatchdog          bool     `json:"cpu_watchdog"`
+	MemoryWatchdog       bool     `json:"memory_watchdog"`
+	GoroutineWatchdog    bool     `json:"goroutine_watchdog"`
+	Peers                bool     `json:"peers"`
+	OutlierDetection     bool     `json:"outlier_detection"`
+	Vulns                bool     `json:"vulns"`
+	WarmUp               bool     `json:"warm_up"`
+	LoadGenerator        bool     `json:"load_generator"`
+	UsageMetrics         bool     `json:"usage_metrics"`
+	RuntimeMetrics       bool     `json:"runtime_metrics"`
+	AuditLog             bool     `json:"audit_log"`
+	Reports              []string `json:"reports,omitempty"`
+	Modules              []string `json:"modules,omitempty"`
+	BlockProfileRate     int64    `json:"block_profile_rate"`
+	MutexProfileFraction int      `json:"mutex_profile_fraction"`
+	Traceback            string   `json:"traceback"`
+	CrashOutput          bool     `json:"crash_output"`
+	Started              bool     `json:"started"`
+	Enabled              bool     `json:"enabled"`
+	ArmWindowSeconds     float64  `json:"arm_window_seconds,omitempty"`
+}
+
+// aboutPlatform is what the platform the Debugger is running on supports.
+type aboutPlatform struct {
+	GoVersion      string `json:"go_version"`
+	OS             string `json:"os"`
+	Arch           string `json:"arch"`
+	RSS            bool   `json:"rss"`              // needed by MemoryWatchdog.RSS
+	ProcessCPUTime bool   `json:"process_cpu_time"` // needed by CPUWatchdog
+	BuildInfo      bool   `json:"build_info"`       // needed by the dependency reports
+	CgroupMemory   bool   `json:"cgroup_memory"`    // used by the memory headroom check
+}
+
+// netbugVersion returns the version of netbug the binary was built with.
+func netbugVersion() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if bi.Main.Path == modulePath {
+		return bi.Main.Version
+	}
+	for _, dep := range bi.Deps {
+		if dep.Path == modulePath {
+			if dep.Replace != nil {
+				if dep.Replace.Version == "" {
+					// Replaced by a local directory.
+					return "(devel)"
+				}
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return "unknown"
+}
+
+// about serves a description of d as JSON, so that support can tell which
+// capabilities a deployment has.
+func (d *Debugger) about(w http.ResponseWriter, r *http.Request) {
+	info := aboutInfo{
+		Version: netbugVersion(),
+		Features: aboutFeatures{
+			Auth:                 "none",
+			RequireTLS:           d.requireTLS,
+			TrustProxyHeaders:    d.trustProxyHeaders,
+			PeerCredAuth:         d.peerCredAuth != nil,
+			ReadOnly:             d.readOnly,
+			Store:                fmt.Sprintf("%T", d.store),
+			CPUWatchdog:          d.cpuWatchdog != nil,
+			MemoryWatchdog:       d.memWatchdog != nil,
+			GoroutineWatchdog:    d.goroutineWatchdog != nil,
+			Peers:                d.discover != nil,
+			OutlierDetection:     d.outlierDetection != nil,
+			Vulns:                d.vulns != nil,
+			WarmUp:               d.warmUp != nil,
+			LoadGenerator:        d.loadGenerator != nil,
+			UsageMetrics:         d.usage != nil,
+			RuntimeMetrics:       d.runtimeMetrics,
+			AuditLog:             d.auditLog != nil,
+			Reports:              d.reportNames(),
+			BlockProfileRate:     blockProfileRate.Load(),
+			MutexProfileFraction: mutexProfileFraction(),
+			Enabled:              d.Enabled(),
+			ArmWindowSeconds:     d.armWindow.Seconds(),
+		},
+		Compatibility: aboutPlatform{
+			GoVersion: runtime.Version(),
+			OS:        runtime.GOOS,
+			Arch:      runtime.GOARCH,
+		},
+	}
+	var auth []string
+	if d.token != "" || len(d.scopedTokens) > 0 {
+		auth = append(auth, "token")
+	}
+	if d.oidc != nil {
+		auth = append(auth, "oidc")
+	}
+	if d.jwt != nil {
+		auth = append(auth, "jwt")
+	}
+	if auth != nil {
+		info.Features.Auth = strings.Join(auth, ",")
+	}
+	info.Features.ScopedTokens = len(d.scopedTokens)
+	if d.captureDir != nil {
+		info.Features.CaptureDir = d.captureDir.path
+	}
+	info.Features.Retention = d.retention != nil
+	info.Features.EncryptedExports = d.encrypt != nil
+	info.Features.BinaryDownload = d.binaryDownload
+	info.Features.Source = d.source != nil
+	info.Features.TraceFormats = d.traceFormatNames()
+	if d.signingKey != nil {
+		info.Features.SigningKey = base64.StdEncoding.EncodeToString(d.signingKey.Public().(ed25519.PublicKey))
+	}
+	for path := range d.public {
+		info.Features.PublicEndpoints = append(info.Features.PublicEndpoints, path)
+	}
+	sort.Strings(info.Features.PublicEndpoints)
+	traceback, output := crashState()
+	info.Features.Traceback, info.Features.CrashOutput = traceback, output != ""
+	for _, m := range d.modules {
+		info.Features.Modules = append(info.Features.Modules, m.Name())
+	}
+	for _, s := range d.schedules {
+		info.Features.Schedules = append(info.Features.Schedules, s.String())
+	}
+	d.mu.Lock()
+	info.Features.Started = d.cancel != nil
+	d.mu.Unlock()
+
+	_, err := residentSetSize()
+	info.Compatibility.RSS = err == nil
+	_, err = processCPUTime()
+	info.Compatibility.ProcessCPUTime = err == nil
+	_, info.Compatibility.BuildInfo = debug.ReadBuildInfo()
+	_, _, info.Compatibility.CgroupMemory = cgroupMemory()
+
+	for _, e := range d.endpoints() {
+		info.Endpoints = append(info.Endpoints, e.Path)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(info); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
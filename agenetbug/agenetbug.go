@@ -0,0 +1,16 @@
+package agenetbug
+
+import (
+	"io"
+
+	"filippo.io/age"
+	"github.com/e-dard/netbug"
+)
+
+// WithRecipients encrypts exports of captured profiles so that any of
+// recipients can decrypt them, adding ".age" to their names.
+func WithRecipients(recipients ...age.Recipient) netbug.Option {
+	return netbug.WithEncryption(func(w io.Writer) (io.WriteCloser, error) {
+		return age.Encrypt(w, recipients...)
+	}, ".age")
+}
@@ -0,0 +1,19 @@
+// Package agenetbug encrypts exports of captured profiles to age
+// recipients, so that heap profiles, which can contain user data, can be
+// attached to incident tickets safely:
+//
+//	r, err := age.ParseX25519Recipient("age1...")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	d := netbug.New(netbug.WithToken("open sesame"), agenetbug.WithRecipients(r))
+//
+// Exports are then downloaded as .zip.age files, which are decrypted with
+//
+//	$ age -d -i key.txt myservice-host1-history-20240601T120000Z.zip.age > profiles.zip
+//
+// It uses filippo.io/age, which netbug doesn't otherwise depend on, so it
+// is a module of its own:
+//
+//	$ go get github.com/e-dard/netbug/agenetbug
+package agenetbug
@@ -0,0 +1,155 @@
+package netbug
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// asyncTrigger is the trigger recorded for profiles captured
+// asynchronously because the request couldn't wait for them.
+const asyncTrigger = "async"
+
+// asyncCaptures tracks the asynchronous captures that are in progress.
+type asyncCaptures struct {
+	mu      sync.Mutex
+	pending map[string]time.Time // artifact ID to when it should be ready
+}
+
+// readyAt returns when the asynchronous capture with the given ID should
+// be ready, if it is in progress.
+func (c *asyncCaptures) readyAt(id string) (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.pending[id]
+	return t, ok
+}
+
+// add records that the asynchronous capture with the given ID should be
+// ready at ready.
+func (c *asyncCaptures) add(id string, ready time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending == nil {
+		c.pending = make(map[string]time.Time)
+	}
+	c.pending[id] = ready
+}
+
+// remove records that the asynchronous capture with the given ID is no
+// longer in progress.
+func (c *asyncCaptures) remove(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, id)
+}
+
+// captureSeconds returns the length of the CPU profile or trace asked for
+// by r, with the same defaults as net/http/pprof.
+func captureSeconds(r *http.Request, name string) time.Duration {
+	def := 30.0
+	if name == "trace" {
+		def = 1
+	}
+	sec, err := strconv.ParseFloat(r.FormValue("seconds"), 64)
+	if err != nil || sec <= 0 {
+		sec = def
+	}
+	return time.Duration(sec * float64(time.Second))
+}
+
+// serveAsync captures the CPU profile or trace called name in the
+// background if r's deadline would pass before it is done, as it does
+// when the handler is wrapped with http.TimeoutHandler, which fails any
+// response written after its timeout. Rather than a response that is
+// bound to fail, the client receives 202 Accepted and the capture's ID,
+// and can download it from history/<id> once it is ready. serveAsync
+// reports whether it handled r.
+//
+// The capture runs as a job, listed at jobs, so it is limited as jobs
+// are: it can be at most maxJobSeconds long, it is refused while
+// maxRunningJobs jobs are running, and it is cut short by Stop.
+//
+// Captures with load, asked for with with_load=1, aren't made
+// asynchronously.
+func (d *Debugger) serveAsync(w http.ResponseWriter, r *http.Request, name string) bool {
+	deadline, ok := r.Context().Deadline()
+	if !ok || r.FormValue("with_load") == "1" {
+		return false
+	}
+	dur := captureSeconds(r, name)
+	// Allow a little time to write the profile once it has been captured.
+	if time.Until(deadline) > dur+time.Second {
+		return false
+	}
+	if dur > maxJobSeconds*time.Second {
+		http.Error(w, fmt.Sprintf("%s can be at most %d seconds when captured asynchronously", name, maxJobSeconds), http.StatusBadRequest)
+		return true
+	}
+
+	id := newArtifactID()
+	ready := time.Now().Add(dur)
+	profiles := []jobProfile{{Profile: name, Seconds: dur.Seconds(), id: id}}
+	j := &job{
+		ID:        newArtifactID(),
+		State:     "running",
+		Profiles:  profiles,
+		Started:   time.Now(),
+		Artifacts: []jobResult{},
+	}
+	if p, ok := PrincipalFrom(r.Context()); ok {
+		j.By = p.String()
+	}
+	d.async.add(id, ready)
+	started := d.jobs.start(j, func(ctx context.Context) {
+		defer d.async.remove(id)
+		d.runJob(ctx, j.ID, j.By, asyncTrigger, profiles)
+	})
+	if !started {
+		d.async.remove(id)
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, fmt.Sprintf("%d jobs are already running", maxRunningJobs), http.StatusTooManyRequests)
+		return true
+	}
+
+	loc := "history/" + id
+	if tok := d.linkToken(r); tok != "" {
+		loc += "?token=" + url.QueryEscape(tok)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", loc)
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(ready).Seconds())+1))
+	w.WriteHeader(http.StatusAccepted)
+	info := struct {
+		ID    string    `json:"id"`
+		URL   string    `json:"url"` // relative to the Debugger's prefix
+		Ready time.Time `json:"ready"`
+	}{id, "history/" + id, ready}
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+	return true
+}
+
+// servePending responds to a request for the asynchronous capture with
+// the given ID if it is still in progress, reporting whether it did.
+func (d *Debugger) servePending(w http.ResponseWriter, id string) bool {
+	ready, ok := d.async.readyAt(id)
+	if !ok {
+		return false
+	}
+	wait := time.Until(ready)
+	if wait < 0 {
+		wait = 0
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "still capturing; ready in about %v\n", wait.Round(time.Second))
+	return true
+}
@@ -0,0 +1,79 @@
+package netbug
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// serveDeadline serves a GET request for target with a deadline of a
+// second, as if d were wrapped with http.TimeoutHandler.
+func serveDeadline(d http.Handler, target string) *httptest.ResponseRecorder {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, httptest.NewRequest("GET", "/"+target, nil).WithContext(ctx))
+	return w
+}
+
+// TestAsyncCaptureJobs checks that asynchronous captures are limited as
+// jobs are, and cut short by Stop.
+func TestAsyncCaptureJobs(t *testing.T) {
+	d := New()
+
+	if w := serveDeadline(d, "profile?seconds=601"); w.Code != http.StatusBadRequest {
+		t.Errorf("601-second profile: %d %s, want 400", w.Code, w.Body)
+	}
+
+	release := make(chan struct{})
+	for i := 0; i < maxRunningJobs; i++ {
+		id := newArtifactID()
+		d.jobs.start(&job{ID: id}, func(context.Context) {
+			<-release
+			d.jobs.update(id, func(j *job) { j.cancel = nil })
+		})
+	}
+	if w := serveDeadline(d, "profile?seconds=600"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("profile with %d jobs running: %d %s, want 429", maxRunningJobs, w.Code, w.Body)
+	}
+	close(release)
+	d.jobs.wg.Wait()
+
+	w := serveDeadline(d, "profile?seconds=600")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("profile: %d %s, want 202", w.Code, w.Body)
+	}
+	var info struct{ ID string }
+	if err := json.NewDecoder(w.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	running := 0
+	for _, j := range d.jobs.list() {
+		if j.State == "running" {
+			running++
+		}
+	}
+	if running != 1 {
+		t.Errorf("%d jobs running, want the asynchronous capture", running)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	stopped := make(chan struct{})
+	go func() {
+		d.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Stop didn't cut the capture short")
+	}
+	w = httptest.NewRecorder()
+	d.ServeHTTP(w, httptest.NewRequest("GET", "/history/"+info.ID, nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("history/%s after Stop: %d %s, want the profile cut short", info.ID, w.Code, w.Body)
+	}
+}
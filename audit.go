@@ -0,0 +1,181 @@
+package netbug
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// WithAuditLog logs every request made to the Debugger to l, including
+// those refused, for compliance: who made it, from where, which endpoint
+// it was for and with which parameters, and the response's status code,
+// size and how long it took. Tokens are never logged; requests are
+// attributed to principals, as in the request journal.
+func WithAuditLog(l *slog.Logger) Option {
+	return func(d *Debugger) {
+		d.auditLog = l
+	}
+}
+
+// A RequestObserver is told about each request made to a Debugger, such as
+// to trace it. It is called with the request and the endpoint it is for,
+// as named in the endpoint catalog, such as "profile" or
+// "goroutines/{id}", before the request is handled, and returns the
+// context to handle it with and a function to call once it has been, with
+// the response's status code and size.
+type RequestObserver func(r *http.Request, endpoint string) (ctx context.Context, done func(status int, bytes int64))
+
+// WithRequestObserver has o observe every request made to the Debugger,
+// including those refused, such as to add them to traces. It can be used
+// more than once; observers are called in order, and their done functions
+// in reverse order.
+func WithRequestObserver(o RequestObserver) Option {
+	return func(d *Debugger) {
+		d.observers = append(d.observers, o)
+	}
+}
+
+type auditKey struct{}
+
+// auditRecord is the principal that made an audited request, set once
+// the request is authenticated, and whether it started a capture.
+type auditRecord struct {
+	principal *Principal
+	capture   bool // whether a capture was started, for WithLogger
+}
+
+// setAuditPrincipal records that r, if audited, was made by p.
+func setAuditPrincipal(r *http.Request, p Principal) {
+	if a, ok := r.Context().Value(auditKey{}).(*auditRecord); ok {
+		a.principal = &p
+	}
+}
+
+// auditChange records change, a change to the process's settings made by
+// r, in d's audit log, attributed to the principal that made it, so that
+// the log has what was changed from and to, not just the parameters the
+// request was made with.
+func (d *Debugger) auditChange(r *http.Request, change string) {
+	if d.auditLog == nil {
+		return
+	}
+	by := ""
+	if p, ok := PrincipalFrom(r.Context()); ok {
+		by = p.String()
+	}
+	d.auditLog.LogAttrs(r.Context(), slog.LevelInfo, "netbug change",
+		requestIDAttr(r),
+		slog.String("by", by),
+		slog.String("remote", r.RemoteAddr),
+		slog.String("change", change),
+	)
+}
+
+// auditWriter is an http.ResponseWriter that records the status code and
+// size of the response.
+type auditWriter struct {
+	http.ResponseWriter
+	status  int
+	bytes   int64
+	errBody []byte // the start of a server error's response, for WithLogger
+}
+
+func (w *auditWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= 500 && len(w.errBody) < maxLoggedError {
+		w.errBody = append(w.errBody, b[:min(len(b), maxLoggedError-len(w.errBody))]...)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher, so that streamed responses, such as
+// those proxied from peers, aren't buffered.
+func (w *auditWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying ResponseWriter, for
+// http.ResponseController.
+func (w *auditWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// serveObserved serves r as serve does, logging it to d's audit log or
+// logger, recording it in d's usage metrics and passing it to d's
+// observers, whichever d has.
+func (d *Debugger) serveObserved(w http.ResponseWriter, r *http.Request) {
+	a := &auditRecord{}
+	if p, ok := PrincipalFrom(r.Context()); ok {
+		a.principal = &p
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	endpoint := d.usageEndpoint(name)
+	if d.usage != nil {
+		d.usage.begin(endpoint)
+	}
+	ctx := r.Context()
+	dones := make([]func(int, int64), len(d.observers))
+	for i, o := range d.observers {
+		ctx, dones[i] = o(r.WithContext(ctx), endpoint)
+	}
+	aw := &auditWriter{ResponseWriter: w}
+	start := time.Now()
+	d.serve(aw, r.WithContext(context.WithValue(ctx, auditKey{}, a)))
+	dur := time.Since(start)
+
+	status := aw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	for i := len(dones) - 1; i >= 0; i-- {
+		dones[i](status, aw.bytes)
+	}
+	if d.usage != nil {
+		d.usage.end(endpoint, status, aw.bytes, dur)
+	}
+	if d.logger != nil {
+		d.logResult(r, name, a, aw, status, dur)
+	}
+	if d.auditLog == nil {
+		return
+	}
+	params := r.Form
+	if params == nil {
+		params = r.URL.Query()
+	}
+	q := make(url.Values, len(params))
+	for k, v := range params {
+		if k != "token" {
+			q[k] = v
+		}
+	}
+	by := ""
+	if a.principal != nil {
+		by = a.principal.String()
+	}
+	d.auditLog.LogAttrs(r.Context(), slog.LevelInfo, "netbug request",
+		requestIDAttr(r),
+		slog.String("by", by),
+		slog.String("remote", r.RemoteAddr),
+		slog.String("method", r.Method),
+		slog.String("path", name),
+		slog.String("params", q.Encode()),
+		slog.Int("status", status),
+		slog.Duration("duration", dur),
+		slog.Int64("bytes", aw.bytes),
+	)
+}
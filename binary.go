@@ -0,0 +1,68 @@
+package netbug
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// WithBinaryDownload serves the running executable at debug/binary, so
+// that profiles can be symbolized offline with
+//
+//	go tool pprof ./binary profile.pb.gz
+//
+// even when the build artifact the process was started from is hard to
+// find. As the binary may embed secrets, it is only served to
+// authenticated requests, even if debug/binary is made public.
+func WithBinaryDownload() Option {
+	return func(d *Debugger) {
+		d.binaryDownload = true
+	}
+}
+
+// openExecutable opens the running executable. On Linux, this is the file
+// the process was started from even if it has since been replaced or
+// removed, such as by an in-place deploy.
+func openExecutable() (*os.File, string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, "", err
+	}
+	name := filepath.Base(exe)
+	if runtime.GOOS == "linux" {
+		if f, err := os.Open("/proc/self/exe"); err == nil {
+			return f, name, nil
+		}
+	}
+	f, err := os.Open(exe)
+	return f, name, err
+}
+
+// binary serves the running executable, supporting range requests so that
+// an interrupted download of a large binary can be resumed.
+func (d *Debugger) binary(w http.ResponseWriter, r *http.Request) {
+	if !d.binaryDownload {
+		http.NotFound(w, r)
+		return
+	}
+	if _, ok := PrincipalFrom(r.Context()); !ok {
+		http.Error(w, "debug/binary is only served to authenticated requests", http.StatusForbidden)
+		return
+	}
+	f, name, err := openExecutable()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("opening executable: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("opening executable: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
@@ -0,0 +1,43 @@
+package netbug
+
+import "html/template"
+
+// Branding customizes the index page, such as to match a company's other
+// internal tools or to warn whoever uses it that they are on a production
+// system.
+type Branding struct {
+	// Title is the page's title. It is "Debug Information" if empty.
+	Title string
+
+	// Banner is shown at the top of the page, such as "production system:
+	// captures are audited".
+	Banner template.HTML
+
+	// CSS is added to the page in a style element. The page's elements
+	// have no classes besides the banner's, netbug-banner, so it is best
+	// used to style elements by type.
+	CSS template.CSS
+
+	// Links are shown in their own section of the page, such as to
+	// internal runbooks and dashboards.
+	Links []Link
+}
+
+// A Link is a link to another page, such as a runbook.
+type Link struct {
+	// URL is the link's target.
+	URL string
+
+	// Title is the link's text.
+	Title string
+}
+
+// WithBranding customizes the index page with b. Banner and CSS aren't
+// escaped, so mustn't come from untrusted sources. Images and other
+// resources they load are blocked by the default Content-Security-Policy,
+// which WithSecurityHeaders can relax.
+func WithBranding(b Branding) Option {
+	return func(d *Debugger) {
+		d.branding = b
+	}
+}
@@ -0,0 +1,184 @@
+package netbug
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCapabilityTTL is the longest a capability token can be valid for.
+const maxCapabilityTTL = 24 * time.Hour
+
+// capabilityPrefix begins every capability token, distinguishing them
+// from the Debugger's own token.
+const capabilityPrefix = "nb1."
+
+// capabilities is the single-use capability tokens a Debugger has
+// accepted, so that they aren't accepted again.
+type capabilities struct {
+	mu   sync.Mutex
+	used map[string]time.Time // nonce to expiry
+}
+
+// use records the single-use token with nonce as used, reporting whether
+// it hadn't been already. Tokens that have expired are forgotten.
+func (c *capabilities) use(nonce string, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.used[nonce]; ok {
+		return false
+	}
+	if c.used == nil {
+		c.used = make(map[string]time.Time)
+	}
+	now := time.Now()
+	for n, exp := range c.used {
+		if now.After(exp) {
+			delete(c.used, n)
+		}
+	}
+	c.used[nonce] = expires
+	return true
+}
+
+// capabilityMAC returns the MAC of a capability token's fields, keyed with
+// the Debugger's token.
+func capabilityMAC(key, fields string) string {
+	m := hmac.New(sha256.New, []byte(key))
+	m.Write([]byte(fields))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// MintToken returns a capability token that authenticates requests as the
+// Debugger's token does, but only until ttl has passed and, if once is
+// set, only for a single request, so that a URL can be handed to a
+// teammate without sharing the long-lived token. ttl can be at most 24
+// hours.
+//
+// Capability tokens are signed with the Debugger's token, which must be
+// set, and changing it revokes every capability token. The expiry is
+// checked against each instance's clock, and single-use tokens are only
+// tracked within a process, so a single-use token for a set of replicas
+// can be used once on each.
+func (d *Debugger) MintToken(ttl time.Duration, once bool) (string, error) {
+	if d.token == "" {
+		return "", errors.New("netbug: capability tokens require a token")
+	}
+	if ttl <= 0 || ttl > maxCapabilityTTL {
+		return "", fmt.Errorf("netbug: capability tokens can be valid for at most %v", maxCapabilityTTL)
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	use := "m"
+	if once {
+		use = "o"
+	}
+	fields := fmt.Sprintf("%d.%s.%s", time.Now().Add(ttl).Unix(), use, hex.EncodeToString(b))
+	return capabilityPrefix + fields + "." + capabilityMAC(d.token, fields), nil
+}
+
+// singleUse reports whether tok is a single-use capability token, which
+// has been spent by the time a page linking with it is served.
+func singleUse(tok string) bool {
+	rest, ok := strings.CutPrefix(tok, capabilityPrefix)
+	if !ok {
+		return false
+	}
+	f := strings.Split(rest, ".")
+	return len(f) == 4 && f[1] == "o"
+}
+
+// checkCapability returns the principal for a request authenticated with
+// the capability token tok, reporting whether tok is valid, unexpired and,
+// if single-use, unused.
+func (d *Debugger) checkCapability(tok string) (Principal, bool) {
+	rest, ok := strings.CutPrefix(tok, capabilityPrefix)
+	if !ok {
+		return Principal{}, false
+	}
+	i := strings.LastIndexByte(rest, '.')
+	if i < 0 {
+		return Principal{}, false
+	}
+	fields, mac := rest[:i], rest[i+1:]
+	if !hmac.Equal([]byte(mac), []byte(capabilityMAC(d.token, fields))) {
+		return Principal{}, false
+	}
+	f := strings.Split(fields, ".")
+	if len(f) != 3 {
+		return Principal{}, false
+	}
+	exp, err := strconv.ParseInt(f[0], 10, 64)
+	if err != nil {
+		return Principal{}, false
+	}
+	expires := time.Unix(exp, 0)
+	if time.Now().After(expires) {
+		return Principal{}, false
+	}
+	if f[1] == "o" && !d.capabilities.use(f[2], expires) {
+		return Principal{}, false
+	}
+	return Principal{Kind: "capability", ID: f[2]}, true
+}
+
+// mintToken handles a POST minting a capability token, valid for the
+// number of minutes given by the minutes parameter, 30 by default, and
+// for a single request if once is true. Only requests authenticated with
+// the Debugger's own token can mint capability tokens.
+func (d *Debugger) mintToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "use POST to mint a token", http.StatusMethodNotAllowed)
+		return
+	}
+	if d.token == "" || !equalTokens(requestToken(r), d.token) {
+		http.Error(w, "capability tokens can only be minted with netbug's token", http.StatusForbidden)
+		return
+	}
+	p := tokenPrincipal(d.token)
+	ttl := 30 * time.Minute
+	if s := r.FormValue("minutes"); s != "" {
+		mins, err := strconv.Atoi(s)
+		if err != nil || mins <= 0 {
+			http.Error(w, fmt.Sprintf("invalid minutes: %q", s), http.StatusBadRequest)
+			return
+		}
+		ttl = time.Duration(mins) * time.Minute
+	}
+	once := false
+	if s := r.FormValue("once"); s != "" {
+		var err error
+		if once, err = strconv.ParseBool(s); err != nil {
+			http.Error(w, fmt.Sprintf("invalid once: %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+	tok, err := d.MintToken(ttl, once)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d.logf(slog.LevelInfo, "netbug: capability token valid for %v (single-use %t) minted by %s from %s", ttl, once, p, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Token     string    `json:"token"`
+		Expires   time.Time `json:"expires"`
+		SingleUse bool      `json:"single_use"`
+	}{tok, time.Now().Add(ttl), once}); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package netbug
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mint mints a capability token by POSTing query to d's control/tokens
+// with tok.
+func mint(t *testing.T, d *Debugger, tok, query string) string {
+	t.Helper()
+	w := serveToken(d, "POST", "control/tokens?"+query, tok)
+	if w.Code != http.StatusOK {
+		t.Fatalf("minting with %s: %d %s", query, w.Code, w.Body)
+	}
+	var minted struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &minted); err != nil {
+		t.Fatal(err)
+	}
+	return minted.Token
+}
+
+// TestCapabilityTokens checks that capability tokens are accepted until
+// they expire or, if single-use, are used, and that those not signed
+// with the Debugger's token are refused.
+func TestCapabilityTokens(t *testing.T) {
+	d := New(WithToken("main"))
+
+	many := mint(t, d, "main", "minutes=5")
+	for i := 0; i < 2; i++ {
+		if w := serveToken(d, "GET", "heap", many); w.Code != http.StatusOK {
+			t.Errorf("reusable token, use %d: %d, want 200", i+1, w.Code)
+		}
+	}
+	once := mint(t, d, "main", "once=true")
+	if w := serveToken(d, "GET", "heap", once); w.Code != http.StatusOK {
+		t.Errorf("single-use token: %d, want 200", w.Code)
+	}
+	if w := serveToken(d, "GET", "heap", once); w.Code != http.StatusUnauthorized {
+		t.Errorf("single-use token used again: %d, want 401", w.Code)
+	}
+
+	fields := fmt.Sprintf("%d.m.0123456789abcdef", time.Now().Add(-time.Minute).Unix())
+	expired := capabilityPrefix + fields + "." + capabilityMAC("main", fields)
+	i := strings.IndexByte(many[len(capabilityPrefix):], '.') + len(capabilityPrefix)
+	tampered := capabilityPrefix + "9999999999" + many[i:]
+	foreign, err := New(WithToken("other")).MintToken(time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct{ name, tok string }{
+		{"expired", expired},
+		{"tampered", tampered},
+		{"signed with another token", foreign},
+	} {
+		if w := serveToken(d, "GET", "heap", tc.tok); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: %d, want 401", tc.name, w.Code)
+		}
+	}
+
+	// Only the Debugger's own token can mint more.
+	if w := serveToken(d, "POST", "control/tokens", many); w.Code != http.StatusForbidden {
+		t.Errorf("minting with a capability token: %d, want 403", w.Code)
+	}
+	if _, err := New().MintToken(time.Minute, false); err == nil {
+		t.Error("MintToken without a token succeeded")
+	}
+	if _, err := d.MintToken(maxCapabilityTTL+time.Minute, false); err == nil {
+		t.Errorf("MintToken for longer than %v succeeded", maxCapabilityTTL)
+	}
+}
@@ -0,0 +1,146 @@
+package netbug
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime/pprof"
+	"runtime/trace"
+	"time"
+)
+
+// WriteProfile writes the profile called name to w, in the same format
+// that the netbug handler serves it to go tool pprof.
+//
+// As well as the profiles available from runtime/pprof, name may be
+// "profile", which captures a CPU profile lasting d, or "trace", which
+// captures an execution trace lasting d. d is ignored for all other
+// profiles.
+//
+// CPU profiles and traces are cut short if ctx is done before d has
+// elapsed, in which case the partial profile is still written to w and
+// ctx.Err() is returned.
+func WriteProfile(ctx context.Context, w io.Writer, name string, d time.Duration) error {
+	return writeProfile(ctx, w, name, d, 0)
+}
+
+// writeProfile is WriteProfile, with the debug parameter passed to the
+// runtime/pprof profile called name.
+func writeProfile(ctx context.Context, w io.Writer, name string, d time.Duration, debug int) error {
+	switch name {
+	case "profile":
+		if err := pprof.StartCPUProfile(w); err != nil {
+			return err
+		}
+		err := sleep(ctx, d)
+		pprof.StopCPUProfile()
+		return err
+	case "trace":
+		if err := trace.Start(w); err != nil {
+			return err
+		}
+		err := sleep(ctx, d)
+		trace.Stop()
+		return err
+	}
+
+	p := pprof.Lookup(name)
+	if p == nil {
+		return fmt.Errorf("netbug: unknown profile %q", name)
+	}
+	return p.WriteTo(w, debug)
+}
+
+// sleep pauses for d, or until ctx is done, in which case it returns
+// ctx.Err().
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// capture captures a profile, as with WriteProfile, and keeps it in d's
+// store. trigger records why the profile was captured.
+func (d *Debugger) capture(ctx context.Context, name string, dur time.Duration, debug int, trigger string) (Artifact, error) {
+	return d.captureID(ctx, newArtifactID(), name, dur, debug, trigger)
+}
+
+// captureID is capture, keeping the profile as the artifact with the given
+// ID.
+func (d *Debugger) captureID(ctx context.Context, id, name string, dur time.Duration, debug int, trigger string) (Artifact, error) {
+	var buf bytes.Buffer
+	if err := writeProfile(ctx, &buf, name, dur, debug); err != nil {
+		return Artifact{}, err
+	}
+	return d.keep(id, name, dur, debug, trigger, buf.Bytes())
+}
+
+// keep keeps data, the profile called name captured for trigger, in d's
+// store as the artifact with the given ID.
+func (d *Debugger) keep(id, name string, dur time.Duration, debug int, trigger string, data []byte) (Artifact, error) {
+	a := Artifact{
+		ID:      id,
+		Profile: name,
+		Debug:   debug,
+		Trigger: trigger,
+		Created: time.Now(),
+		Size:    int64(len(data)),
+	}
+	if name == "profile" || name == "trace" {
+		a.Debug, a.Duration = 0, dur
+	}
+	d.annotate(&a)
+	// Only profiles in the protocol buffer format have room for
+	// metadata.
+	if a.Debug == 0 && name != "trace" {
+		if tagged, err := addProfileComments(data, a.comments()); err != nil {
+			d.logf(slog.LevelError, "netbug: adding metadata to %s: %v", name, err)
+		} else {
+			data = tagged
+			a.Size = int64(len(data))
+		}
+	}
+	a.SHA256 = sha256Hex(data)
+	if err := d.store.Put(a, data); err != nil {
+		return Artifact{}, err
+	}
+	// A failure is only logged, as the capture itself has succeeded.
+	if d.captureDir != nil {
+		filename := d.downloadFilename(name, a.Debug, a.Created)
+		if err := d.captureDir.write(filename, data); err != nil {
+			d.logf(slog.LevelError, "netbug: writing %s to %s: %v", filename, d.captureDir.path, err)
+		}
+	}
+	d.prune()
+	return a, nil
+}
+
+// captureSpec describes one of the profiles captured by captureAll.
+type captureSpec struct {
+	name  string
+	dur   time.Duration
+	debug int
+}
+
+// captureAll captures each of specs in turn, logging any failures.
+func (d *Debugger) captureAll(ctx context.Context, trigger string, specs ...captureSpec) []Artifact {
+	var as []Artifact
+	for _, s := range specs {
+		a, err := d.capture(ctx, s.name, s.dur, s.debug, trigger)
+		if err != nil {
+			if ctx.Err() == nil {
+				d.logf(slog.LevelError, "netbug: capturing %s for %s: %v", s.name, trigger, err)
+			}
+			continue
+		}
+		as = append(as, a)
+	}
+	return as
+}
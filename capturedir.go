@@ -0,0 +1,126 @@
+package netbug
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// WithCaptureDir writes a copy of every profile netbug captures to dir,
+// whether served in response to a request or kept in the Debugger's store,
+// named as it would be downloaded. Profiles then survive an interrupted
+// download, or an operator forgetting to save one. Only the newest keep
+// files in dir are kept, the oldest being removed as new ones are written,
+// so dir should be used for nothing else. If keep is zero or less, every
+// file is kept.
+//
+// A profile served in response to a request is written to dir as it is
+// served, and kept even if the client goes away before it is done.
+func WithCaptureDir(dir string, keep int) Option {
+	return func(d *Debugger) {
+		d.captureDir = &captureDir{path: dir, keep: keep}
+	}
+}
+
+// captureDir is the directory profiles are copied to.
+type captureDir struct {
+	path string
+	keep int
+
+	mu sync.Mutex // serialises rotation
+}
+
+// create returns a file to write the copy of a profile to, which is given
+// its name, filename, once it is committed. Until then it is hidden, and
+// ignored when rotating.
+func (c *captureDir) create(filename string) (*captureFile, error) {
+	if err := os.MkdirAll(c.path, 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(c.path, ".netbug-*")
+	if err != nil {
+		return nil, err
+	}
+	return &captureFile{File: f, dir: c, name: filename}, nil
+}
+
+// write writes data, a profile, to a file called filename.
+func (c *captureDir) write(filename string, data []byte) error {
+	f, err := c.create(filename)
+	if err == nil {
+		_, err = f.Write(data)
+		if err == nil {
+			err = f.commit()
+		} else {
+			f.abort()
+		}
+	}
+	return err
+}
+
+// rotate removes the oldest files from the directory, leaving the newest
+// c.keep.
+func (c *captureDir) rotate() error {
+	if c.keep <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries, err := os.ReadDir(c.path)
+	if err != nil {
+		return err
+	}
+	type file struct {
+		name string
+		mod  int64
+	}
+	var files []file
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, file{e.Name(), info.ModTime().UnixNano()})
+	}
+	if len(files) <= c.keep {
+		return nil
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].mod > files[j].mod })
+	for _, f := range files[c.keep:] {
+		if err := os.Remove(filepath.Join(c.path, f.name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// captureFile is a copy of a profile being written to a captureDir.
+type captureFile struct {
+	*os.File
+	dir  *captureDir
+	name string
+}
+
+// commit closes f and gives it its name, rotating the directory.
+func (f *captureFile) commit() error {
+	if err := f.Close(); err != nil {
+		os.Remove(f.File.Name())
+		return err
+	}
+	if err := os.Rename(f.File.Name(), filepath.Join(f.dir.path, f.name)); err != nil {
+		os.Remove(f.File.Name())
+		return err
+	}
+	return f.dir.rotate()
+}
+
+// abort closes and removes f.
+func (f *captureFile) abort() {
+	f.Close()
+	os.Remove(f.File.Name())
+}
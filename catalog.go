@@ -0,0 +1,176 @@
+package netbug
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"runtime/pprof"
+)
+
+// A Runbook points whoever uses an endpoint at the team's documentation
+// on it, such as how to read a mutex profile.
+type Runbook struct {
+	// URL is the address of the runbook.
+	URL string `json:"url,omitempty"`
+
+	// Note is a short note shown alongside the endpoint.
+	Note string `json:"note,omitempty"`
+}
+
+// WithRunbooks attaches runbooks to endpoints, keyed by the endpoint's
+// path relative to the Debugger's prefix, such as "mutex", "profile" or
+// "goroutines/leaks". Runbooks are shown on the index page and included
+// in the endpoint catalog served at <prefix>endpoints.json.
+//
+// The map's JSON encoding is convenient to load from configuration:
+//
+//	{"mutex": {"url": "https://wiki.example.com/mutex", "note": "ask #perf first"}}
+func WithRunbooks(runbooks map[string]Runbook) Option {
+	return func(d *Debugger) {
+		if d.runbooks == nil {
+			d.runbooks = make(map[string]Runbook)
+		}
+		for path, rb := range runbooks {
+			d.runbooks[path] = rb
+		}
+	}
+}
+
+// endpoint describes one of the endpoints a Debugger serves.
+type endpoint struct {
+	Path        string   `json:"path"`
+	Methods     []string `json:"methods"`
+	Description string   `json:"description"`
+	Runbook     *Runbook `json:"runbook,omitempty"`
+}
+
+// catalog is the endpoints served by every Debugger, besides the named
+// runtime/pprof profiles. enabled, if set, reports whether d serves the
+// endpoint.
+var catalog = []struct {
+	endpoint
+	enabled func(d *Debugger) bool
+}{
+	{endpoint: endpoint{Path: "", Methods: []string{"GET"}, Description: "index page"}},
+	{endpoint: endpoint{Path: "profile", Methods: []string{"GET"}, Description: "CPU profile"}},
+	{endpoint: endpoint{Path: "trace", Methods: []string{"GET"}, Description: "execution trace"}},
+	{endpoint: endpoint{Path: "cmdline", Methods: []string{"GET"}, Description: "command line"}},
+	{endpoint: endpoint{Path: "symbol", Methods: []string{"GET", "POST"}, Description: "symbol lookup for go tool pprof"}},
+	{endpoint: endpoint{Path: "history", Methods: []string{"GET"}, Description: "captured profiles"}},
+	{endpoint: endpoint{Path: "history/{id}", Methods: []string{"GET"}, Description: "download a captured profile"}},
+	{endpoint: endpoint{Path: "history/export", Methods: []string{"GET"}, Description: "download captured profiles as a zip archive"}},
+	{endpoint: endpoint{Path: "status", Methods: []string{"GET"}, Description: "captures in progress, and the most recent ones"}},
+	{endpoint: endpoint{Path: "jobs", Methods: []string{"GET", "POST"}, Description: "capture jobs; POST starts one capturing profiles in the background"}},
+	{endpoint: endpoint{Path: "jobs/{id}", Methods: []string{"GET", "DELETE"}, Description: "status of a capture job, with its profiles' download URLs; DELETE cancels it"}},
+	{endpoint: endpoint{Path: "deploy", Methods: []string{"GET", "POST"}, Description: "deploy baselines; POST captures one"}},
+	{endpoint: endpoint{Path: "deploy/warmup", Methods: []string{"GET"}, Description: "warm-up heuristics"},
+		enabled: func(d *Debugger) bool { return d.warmUp != nil }},
+	{endpoint: endpoint{Path: "journal", Methods: []string{"GET"}, Description: "request journal and replay script"}},
+	{endpoint: endpoint{Path: "control/block", Methods: []string{"POST"}, Description: "set the block profile rate"}},
+	{endpoint: endpoint{Path: "control/mutex", Methods: []string{"POST"}, Description: "set the mutex profile fraction"}},
+	{endpoint: endpoint{Path: "control/arm", Methods: []string{"POST"}, Description: "enable block or mutex profiling for a limited time"}},
+	{endpoint: endpoint{Path: "control/crash", Methods: []string{"POST"}, Description: "set the traceback level and crash output"}},
+	{endpoint: endpoint{Path: "control/enabled", Methods: []string{"POST"}, Description: "enable or disable every endpoint, for good or for a limited time"}},
+	{endpoint: endpoint{Path: "control/tokens", Methods: []string{"POST"}, Description: "mint a single-use or expiring capability token"},
+		enabled: func(d *Debugger) bool { return d.token != "" }},
+	{endpoint: endpoint{Path: "control/runtime", Methods: []string{"GET", "POST"}, Description: "view and change GOGC, GOMEMLIMIT and GOMAXPROCS"}},
+	{endpoint: endpoint{Path: "control/gc", Methods: []string{"POST"}, Description: "run a GC, reporting memory use before and after"}},
+	{endpoint: endpoint{Path: "control/freeosmemory", Methods: []string{"POST"}, Description: "return memory to the OS, reporting memory use before and after"}},
+	{endpoint: endpoint{Path: "stats.json", Methods: []string{"GET"}, Description: "runtime stats"}},
+	{endpoint: endpoint{Path: "goroutines", Methods: []string{"GET"}, Description: "goroutines, filtered or grouped by stack"}},
+	{endpoint: endpoint{Path: "goroutines/{id}", Methods: []string{"GET"}, Description: "stack of a single goroutine"}},
+	{endpoint: endpoint{Path: "goroutines/leaks", Methods: []string{"GET", "POST"}, Description: "goroutine leak analysis; POST captures a baseline"}},
+	{endpoint: endpoint{Path: "debug/sbom", Methods: []string{"GET"}, Description: "dependencies as a CycloneDX SBOM"}},
+	{endpoint: endpoint{Path: "debug/licenses", Methods: []string{"GET"}, Description: "dependencies for license review"}},
+	{endpoint: endpoint{Path: "debug/binary", Methods: []string{"GET"}, Description: "the running executable, for symbolizing profiles offline"},
+		enabled: func(d *Debugger) bool { return d.binaryDownload }},
+	{endpoint: endpoint{Path: "debug/source", Methods: []string{"GET"}, Description: "source of a function or file in the binary"},
+		enabled: func(d *Debugger) bool { return d.source != nil }},
+	{endpoint: endpoint{Path: "debug/vulns", Methods: []string{"GET"}, Description: "known vulnerabilities in dependencies"},
+		enabled: func(d *Debugger) bool { return d.vulns != nil }},
+	{endpoint: endpoint{Path: "peers/{name}/", Methods: []string{"GET", "POST"}, Description: "proxy to a peer's netbug handler"},
+		enabled: func(d *Debugger) bool { return d.discover != nil }},
+	{endpoint: endpoint{Path: "fleet/", Methods: []string{"GET"}, Description: "fleet overview"},
+		enabled: func(d *Debugger) bool { return d.discover != nil }},
+	{endpoint: endpoint{Path: "fleet/outliers", Methods: []string{"GET"}, Description: "outlier instances"},
+		enabled: func(d *Debugger) bool { return d.discover != nil }},
+	{endpoint: endpoint{Path: "fleet/goroutine-diff", Methods: []string{"GET"}, Description: "compare goroutines across instances"},
+		enabled: func(d *Debugger) bool { return d.discover != nil }},
+	{endpoint: endpoint{Path: "reports/", Methods: []string{"GET"}, Description: "names of the custom reports"},
+		enabled: func(d *Debugger) bool { return len(d.reports) > 0 }},
+	{endpoint: endpoint{Path: "reports/{name}", Methods: []string{"GET"}, Description: "custom report"},
+		enabled: func(d *Debugger) bool { return len(d.reports) > 0 }},
+	{endpoint: endpoint{Path: "metrics", Methods: []string{"GET"}, Description: "netbug's own usage, in the Prometheus text format"},
+		enabled: func(d *Debugger) bool { return d.usage != nil }},
+	{endpoint: endpoint{Path: "metrics/runtime", Methods: []string{"GET"}, Description: "runtime/metrics, in the Prometheus text format"},
+		enabled: func(d *Debugger) bool { return d.runtimeMetrics }},
+	{endpoint: endpoint{Path: "oidc/callback", Methods: []string{"GET"}, Description: "where the OpenID Connect provider returns users who have signed in"},
+		enabled: func(d *Debugger) bool { return d.oidc != nil }},
+	{endpoint: endpoint{Path: "about", Methods: []string{"GET"}, Description: "netbug version, features and platform support"}},
+	{endpoint: endpoint{Path: "endpoints.json", Methods: []string{"GET"}, Description: "this catalog"}},
+}
+
+// endpoints returns the endpoints d serves, including its modules', with
+// their runbooks.
+func (d *Debugger) endpoints() []endpoint {
+	var es []endpoint
+	for _, p := range pprof.Profiles() {
+		if d.exposes(p.Name()) {
+			es = append(es, endpoint{Path: p.Name(), Methods: []string{"GET"}, Description: p.Name() + " profile"})
+		}
+	}
+	for _, c := range catalog {
+		if (c.enabled == nil || c.enabled(d)) && d.exposesEndpoint(c.Path) {
+			es = append(es, c.endpoint)
+		}
+	}
+	for _, m := range d.modules {
+		for _, rt := range m.Routes() {
+			es = append(es, endpoint{Path: m.Name() + "/" + rt.Path, Methods: rt.Methods, Description: rt.Description})
+		}
+	}
+	for _, p := range d.pages.list() {
+		es = append(es, endpoint{Path: "pages/" + p.Name, Methods: []string{"GET"}, Description: p.Description})
+	}
+	if d.readOnly {
+		es = readOnlyEndpoints(es)
+	}
+	for i := range es {
+		if rb, ok := d.runbooks[es[i].Path]; ok {
+			es[i].Runbook = &rb
+		}
+	}
+	return es
+}
+
+// readOnlyEndpoints returns es without the methods, and the endpoints with
+// no methods left, that a read-only Debugger doesn't serve.
+func readOnlyEndpoints(es []endpoint) []endpoint {
+	var ro []endpoint
+	for _, e := range es {
+		var methods []string
+		for _, m := range e.Methods {
+			if readOnlyAllows(m, e.Path) {
+				methods = append(methods, m)
+			}
+		}
+		if len(methods) > 0 {
+			e.Methods = methods
+			ro = append(ro, e)
+		}
+	}
+	return ro
+}
+
+// serveEndpoints serves the endpoint catalog as JSON, so that tooling can
+// discover what a deployment offers.
+func (d *Debugger) serveEndpoints(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	catalog := struct {
+		SchemaVersion int        `json:"schema_version"`
+		Endpoints     []endpoint `json:"endpoints"`
+	}{schemaVersion, d.endpoints()}
+	if err := json.NewEncoder(w).Encode(catalog); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package netbug
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// cgroupMemory returns the memory used by the process's cgroup and the
+// cgroup's memory limit, in bytes. ok is false if the process isn't in a
+// cgroup with a memory limit. Only the cgroup mounted at /sys/fs/cgroup is
+// read, which is the process's own in a container.
+func cgroupMemory() (usage, limit uint64, ok bool) {
+	for _, files := range [][2]string{
+		{"/sys/fs/cgroup/memory.current", "/sys/fs/cgroup/memory.max"},                                 // v2
+		{"/sys/fs/cgroup/memory/memory.usage_in_bytes", "/sys/fs/cgroup/memory/memory.limit_in_bytes"}, // v1
+	} {
+		limit, err := readCgroupValue(files[1])
+		// Without a limit, v2 reports "max" and v1 a number close to
+		// math.MaxInt64.
+		if err != nil || limit >= 1<<62 {
+			continue
+		}
+		usage, err := readCgroupValue(files[0])
+		if err != nil {
+			continue
+		}
+		return usage, limit, true
+	}
+	return 0, 0, false
+}
+
+// readCgroupValue reads the number in the cgroup file called name.
+func readCgroupValue(name string) (uint64, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package netbug
+
+// cgroupMemory returns the memory used by the process's cgroup and the
+// cgroup's memory limit, in bytes. ok is false if the process isn't in a
+// cgroup with a memory limit, as it never is on this platform.
+func cgroupMemory() (usage, limit uint64, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,38 @@
+// Package chiadapter mounts netbug on a go-chi router, taking care of
+// stripping the route prefix, which chi's Mount leaves in the request's
+// path:
+//
+//	r := chi.NewRouter()
+//	chiadapter.Mount(r, "/debug", netbug.New(netbug.WithToken("open sesame")))
+//
+// It doesn't import chi, so using it adds no dependencies.
+package chiadapter
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/e-dard/netbug"
+)
+
+// Router is the part of chi.Router that Mount needs.
+type Router interface {
+	Mount(pattern string, h http.Handler)
+}
+
+// Mount mounts d on r at prefix, such as "/debug", or "/" for the root.
+// prefix must be the full path of the route, including the patterns of any
+// routers r is mounted under, and mustn't contain URL parameters. Requests
+// for the prefix without a trailing slash are redirected to it with one,
+// so that the relative links on netbug's pages work.
+func Mount(r Router, prefix string, d *netbug.Debugger) {
+	prefix = "/" + strings.Trim(prefix, "/")
+	strip := http.StripPrefix(strings.TrimSuffix(prefix, "/")+"/", d)
+	r.Mount(prefix, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == prefix && prefix != "/" {
+			http.Redirect(w, req, prefix+"/", http.StatusMovedPermanently)
+			return
+		}
+		strip.ServeHTTP(w, req)
+	}))
+}
@@ -0,0 +1,296 @@
+// Package cloudprofiler forwards the profiles netbug captures to Google
+// Cloud Profiler, so services that already run netbug don't need to run
+// the Cloud Profiler agent as well.
+//
+// The agent decides for itself when to profile, and its CPU profiles
+// collide with those netbug is asked for: only one can run at a time.
+// Instead, this package provides a netbug.Store that keeps captures as the
+// store it wraps does, and uploads each CPU, heap, goroutine and mutex
+// profile to Cloud Profiler as it is captured, with the API's
+// createOffline method. Profiles are captured when netbug's schedules,
+// watchdogs and jobs capture them:
+//
+//	s, err := cloudprofiler.NewStore(ctx, cloudprofiler.Config{
+//		Service:        "myservice",
+//		ServiceVersion: "1.0.0",
+//	}, netbug.NewMemoryStore(32))
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer s.Close()
+//	d := netbug.New(netbug.WithStore(s), netbug.WithSchedule(
+//		netbug.Schedule{Profile: "profile", Duration: 10 * time.Second, Every: time.Minute},
+//		netbug.Schedule{Profile: "heap", Every: time.Minute},
+//	))
+//
+// Credentials are found with Application Default Credentials, as
+// implemented by golang.org/x/oauth2/google: a service account key named
+// by GOOGLE_APPLICATION_CREDENTIALS, the credentials written by "gcloud
+// auth application-default login", or the service account of the GCE
+// instance, GKE node or Cloud Run service the process is running on.
+//
+// The package is a module of its own, so that netbug doesn't depend on
+// golang.org/x/oauth2.
+package cloudprofiler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/e-dard/netbug"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const defaultAPIAddr = "https://cloudprofiler.googleapis.com"
+
+// scope is the OAuth2 scope required to upload profiles.
+const scope = "https://www.googleapis.com/auth/monitoring.write"
+
+// maxPendingUploads is how many profiles can wait to be uploaded before
+// more are dropped.
+const maxPendingUploads = 16
+
+// uploadTimeout bounds the upload of a single profile.
+const uploadTimeout = time.Minute
+
+// Config configures forwarding profiles to Cloud Profiler.
+type Config struct {
+	// Service is the name of the service being profiled. It is required.
+	Service string
+
+	// ServiceVersion is the version of the service being profiled, used
+	// to compare profiles between releases. It is optional.
+	ServiceVersion string
+
+	// ProjectID is the Google Cloud project profiles are uploaded to. If
+	// empty, it is taken from the credentials, the GOOGLE_CLOUD_PROJECT
+	// environment variable, or the metadata server.
+	ProjectID string
+
+	// Zone is the zone the service is running in. If empty, it is taken
+	// from the metadata server when running on Google Cloud.
+	Zone string
+
+	// NoHeap, NoGoroutine and NoMutex stop heap, goroutine and mutex
+	// contention profiles being forwarded. CPU profiles always are.
+	NoHeap      bool
+	NoGoroutine bool
+	NoMutex     bool
+
+	// HTTPClient is the client that Cloud Profiler API requests, with
+	// the credentials' tokens added, are made with. If nil,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// APIAddr overrides the address of the Cloud Profiler API.
+	APIAddr string
+}
+
+// profile is the Cloud Profiler API representation of a profile.
+type profile struct {
+	ProfileType  string            `json:"profileType"`
+	Deployment   *deployment       `json:"deployment"`
+	Duration     string            `json:"duration,omitempty"`
+	ProfileBytes []byte            `json:"profileBytes"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+type deployment struct {
+	ProjectID string            `json:"projectId"`
+	Target    string            `json:"target"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// profileTypes maps the netbug profiles that are forwarded to their Cloud
+// Profiler profile types.
+var profileTypes = map[string]string{
+	"profile":   "CPU",
+	"heap":      "HEAP",
+	"allocs":    "HEAP_ALLOC",
+	"goroutine": "THREADS",
+	"mutex":     "CONTENTION",
+}
+
+// A Store is a netbug.Store that keeps artifacts in the Store it wraps and
+// uploads the profiles among them to Cloud Profiler in the background.
+type Store struct {
+	netbug.Store
+
+	cfg    Config
+	client *http.Client
+	addr   string
+	dep    *deployment
+
+	uploads chan *profile
+	stop    context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// NewStore returns a Store that keeps artifacts in next, and forwards
+// profiles to Cloud Profiler as configured by cfg until it is closed. It
+// returns an error if cfg is invalid or credentials can't be found.
+func NewStore(ctx context.Context, cfg Config, next netbug.Store) (*Store, error) {
+	if cfg.Service == "" {
+		return nil, errors.New("cloudprofiler: Config.Service is required")
+	}
+	base := cfg.HTTPClient
+	if base == nil {
+		base = http.DefaultClient
+	}
+	creds, err := google.FindDefaultCredentials(context.WithValue(ctx, oauth2.HTTPClient, base), scope)
+	if err != nil {
+		return nil, fmt.Errorf("cloudprofiler: %v", err)
+	}
+
+	project := cfg.ProjectID
+	if project == "" {
+		project = creds.ProjectID
+	}
+	if project == "" {
+		project = os.Getenv("GOOGLE_CLOUD_PROJECT")
+	}
+	if project == "" && metadata.OnGCE() {
+		project, _ = metadata.ProjectIDWithContext(ctx)
+	}
+	if project == "" {
+		return nil, errors.New("cloudprofiler: could not determine the project ID; set Config.ProjectID")
+	}
+
+	zone := cfg.Zone
+	if zone == "" && metadata.OnGCE() {
+		// Zone is optional, so failing to find it isn't an error.
+		zone, _ = metadata.ZoneWithContext(ctx)
+	}
+	labels := map[string]string{"language": "go"}
+	if cfg.ServiceVersion != "" {
+		labels["version"] = cfg.ServiceVersion
+	}
+	if zone != "" {
+		labels["zone"] = zone
+	}
+
+	s := &Store{
+		Store: next,
+		cfg:   cfg,
+		client: &http.Client{
+			Transport: &oauth2.Transport{Source: creds.TokenSource, Base: base.Transport},
+			Timeout:   base.Timeout,
+		},
+		addr:    strings.TrimSuffix(cfg.APIAddr, "/"),
+		dep:     &deployment{ProjectID: project, Target: cfg.Service, Labels: labels},
+		uploads: make(chan *profile, maxPendingUploads),
+	}
+	if s.addr == "" {
+		s.addr = defaultAPIAddr
+	}
+	ctx, s.stop = context.WithCancel(context.Background())
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.run(ctx)
+	}()
+	return s, nil
+}
+
+// Put keeps data in the wrapped Store and, if it is a profile Cloud
+// Profiler accepts, queues it to be uploaded.
+func (s *Store) Put(a netbug.Artifact, data []byte) error {
+	if err := s.Store.Put(a, data); err != nil {
+		return err
+	}
+	t, ok := s.profileType(a)
+	if !ok {
+		return nil
+	}
+	p := &profile{ProfileType: t, Deployment: s.dep, ProfileBytes: data}
+	if a.Duration > 0 {
+		p.Duration = fmt.Sprintf("%gs", a.Duration.Seconds())
+	}
+	select {
+	case s.uploads <- p:
+	default:
+		log.Printf("netbug/cloudprofiler: dropping %s profile; %d are waiting to be uploaded", t, maxPendingUploads)
+	}
+	return nil
+}
+
+// profileType returns the Cloud Profiler profile type of a, reporting
+// whether it is to be forwarded.
+func (s *Store) profileType(a netbug.Artifact) (string, bool) {
+	if a.Debug != 0 {
+		// Only profiles in the protocol buffer format are accepted.
+		return "", false
+	}
+	t, ok := profileTypes[a.Profile]
+	switch a.Profile {
+	case "heap", "allocs":
+		ok = ok && !s.cfg.NoHeap
+	case "goroutine":
+		ok = ok && !s.cfg.NoGoroutine
+	case "mutex":
+		// Without a mutex profile fraction, the profile is empty.
+		ok = ok && !s.cfg.NoMutex && runtime.SetMutexProfileFraction(-1) > 0
+	}
+	return t, ok
+}
+
+// Close stops forwarding profiles, waiting for the upload in progress, if
+// any, to finish. Profiles waiting to be uploaded are dropped.
+func (s *Store) Close() error {
+	s.stop()
+	s.wg.Wait()
+	return nil
+}
+
+// run uploads the queued profiles until ctx is done.
+func (s *Store) run(ctx context.Context) {
+	for {
+		select {
+		case p := <-s.uploads:
+			if err := s.upload(ctx, p); err != nil && ctx.Err() == nil {
+				log.Printf("netbug/cloudprofiler: uploading %s profile: %v", p.ProfileType, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// upload uploads p with the API's createOffline method.
+func (s *Store) upload(ctx context.Context, p *profile) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, uploadTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", s.addr+"/v2/projects/"+s.dep.ProjectID+"/profiles:createOffline", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "netbug-cloudprofiler")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("%s: %s", resp.Status, b)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+module github.com/e-dard/netbug/cloudprofiler
+
+go 1.21
+
+require (
+	cloud.google.com/go/compute/metadata v0.5.2
+	github.com/e-dard/netbug v0.0.0
+	golang.org/x/oauth2 v0.23.0
+)
+
+require golang.org/x/sys v0.25.0 // indirect
+
+replace github.com/e-dard/netbug => ../
@@ -0,0 +1,235 @@
+package netbug
+
+import (
+	"bufio"
+	"bytes"
+	"html"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// legacyRoutes are requests for the routes netbug served before the
+// options API. testdata/compat/<file>.http holds the response each got
+// from RegisterHandler("/open/", mux) or, for those with a token,
+// RegisterAuthHandler("secret", "/auth/", mux), as they were then,
+// recorded with the command line replaced by {{cmdline}}.
+var legacyRoutes = []struct {
+	file, method, target string
+	token                bool
+	body                 compareBody
+}{
+	{"index", "GET", "", false, sameLinks},
+	{"cmdline", "GET", "cmdline", false, sameBody},
+	{"symbol", "GET", "symbol", false, sameBody},
+	{"symbol-post", "POST", "symbol", false, sameBody},
+	{"threadcreate", "GET", "threadcreate?debug=1", false, sameFirstLine},
+	{"nosuchprofile", "GET", "nosuchprofile", false, sameBody},
+	{"nosuchprofile-debug", "GET", "nosuchprofile?debug=1", false, sameBody},
+	{"auth-index", "GET", "?token=secret", true, sameLinks},
+	{"auth-cmdline", "GET", "cmdline?token=secret", true, sameBody},
+	{"auth-none", "GET", "cmdline", true, sameBody},
+	{"auth-wrong", "GET", "cmdline?token=wrong", true, sameBody},
+}
+
+// compareBody is how much of a legacy response's body must be the same.
+type compareBody int
+
+const (
+	// sameBody is the whole body.
+	sameBody compareBody = iota
+	// sameFirstLine is the first line, but for its numbers, for profiles
+	// whose samples differ between processes.
+	sameFirstLine
+	// sameLinks is every link on an index page, which lists much more
+	// than it used to.
+	sameLinks
+)
+
+// readLegacy reads the legacy response recorded in
+// testdata/compat/<file>.http, with the command line of this process.
+func readLegacy(t *testing.T, file string) (*http.Response, []byte) {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", "compat", file+".http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	resp, err := http.ReadResponse(bufio.NewReader(f), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Header.Del("Content-Length")
+	return resp, bytes.ReplaceAll(b, []byte("{{cmdline}}"), []byte(strings.Join(os.Args, "\x00")))
+}
+
+// serveCompat serves a request for target, relative to prefix, with h.
+func serveCompat(h http.Handler, method, prefix, target string) *httptest.ResponseRecorder {
+	var body io.Reader
+	if method == "POST" {
+		body = strings.NewReader("0x0")
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, prefix+target, body))
+	return w
+}
+
+// liveNumbers matches numbers, with any units, which change from one
+// request to the next.
+var liveNumbers = regexp.MustCompile(`(\d+(\.\d+)?(ns|µs|ms|s|m|h))+|\d+(\.\d+)?( [KMGTPE]?i?B)?`)
+
+var actions = regexp.MustCompile(`action="([^"]*)"`)
+
+// links returns the targets of the links or, with re set to actions, the
+// forms on an HTML page, resolved against the page's own URL, /.
+func links(re *regexp.Regexp, page []byte) map[string]bool {
+	base := &url.URL{Path: "/"}
+	l := map[string]bool{}
+	for _, m := range re.FindAllSubmatch(page, -1) {
+		if u, err := base.Parse(html.UnescapeString(string(m[1]))); err == nil {
+			l[u.String()] = true
+		}
+	}
+	return l
+}
+
+// compareLegacy fails t unless got is the legacy response recorded in
+// file, but for the deliberate differences TestHandlerCompat lists.
+func compareLegacy(t *testing.T, what, file string, body compareBody, got *httptest.ResponseRecorder) {
+	t.Helper()
+	want, wantBody := readLegacy(t, file)
+	if got.Code != want.StatusCode {
+		t.Errorf("%s: status %d, want %d", what, got.Code, want.StatusCode)
+	}
+	for k := range want.Header {
+		if g, w := got.Header().Values(k), want.Header.Values(k); strings.Join(g, "\n") != strings.Join(w, "\n") {
+			t.Errorf("%s: header %s = %q, want %q", what, k, g, w)
+		}
+	}
+	for k := range got.Header() {
+		_, legacy := want.Header[k]
+		_, security := defaultSecurityHeaders[k]
+		challenge := k == "Www-Authenticate" && got.Code == http.StatusUnauthorized
+		negotiated := k == "Vary" && body == sameLinks
+		if !legacy && !security && !challenge && !negotiated && k != "X-Request-Id" {
+			t.Errorf("%s: unexpected header %s: %q", what, k, got.Header().Values(k))
+		}
+	}
+	g := got.Body.Bytes()
+	switch body {
+	case sameBody:
+		if !bytes.Equal(g, wantBody) {
+			t.Errorf("%s: body %q, want %q", what, g, wantBody)
+		}
+	case sameFirstLine:
+		g, _, _ = bytes.Cut(g, []byte("\n"))
+		w, _, _ := bytes.Cut(wantBody, []byte("\n"))
+		if g, w := liveNumbers.ReplaceAll(g, []byte("0")), liveNumbers.ReplaceAll(w, []byte("0")); !bytes.Equal(g, w) {
+			t.Errorf("%s: first line %q, want %q", what, g, w)
+		}
+	case sameLinks:
+		have, forms := links(hrefs, g), links(actions, g)
+		for l := range links(hrefs, wantBody) {
+			path, _, _ := strings.Cut(l, "?")
+			if !have[l] && !forms[path] {
+				t.Errorf("%s: no link or form to %q", what, l)
+			}
+		}
+	}
+}
+
+// TestHandlerCompat checks that Handler, AuthHandler, Register,
+// RegisterHandler and RegisterAuthHandler serve the routes netbug served
+// before the options API as they did then. The differences are
+// deliberate:
+//
+//   - every response has the security headers WithSecurityHeaders
+//     documents, and an X-Request-Id header;
+//   - a 401 has a WWW-Authenticate header, asking for basic
+//     authentication, so that browsers prompt for the token;
+//   - after more than freeAuthFailures wrong tokens in a row, a client
+//     is locked out, getting a 429 rather than a 401 until it may try
+//     again;
+//   - the index page lists more than it did, varying with the Accept
+//     header, as it can be served as JSON; it still links to everything
+//     it did, but for the CPU profile and traces, which it has forms for
+//     to choose their duration;
+//   - on a ServeMux, the endpoints only accepting methods other than
+//     GET, such as control/gc, have patterns qualified by those methods
+//     as well as prefix, which is all that used to be registered.
+func TestHandlerCompat(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		open   func(mux *http.ServeMux, prefix string)
+		secret func(mux *http.ServeMux, prefix string)
+	}{
+		{
+			"Handler",
+			func(mux *http.ServeMux, prefix string) { mux.Handle(prefix, http.StripPrefix(prefix, Handler())) },
+			func(mux *http.ServeMux, prefix string) {
+				mux.Handle(prefix, http.StripPrefix(prefix, AuthHandler("secret")))
+			},
+		},
+		{
+			"Register",
+			func(mux *http.ServeMux, prefix string) { Register(prefix, mux) },
+			func(mux *http.ServeMux, prefix string) { RegisterAuthHandler("secret", prefix, mux) },
+		},
+		{
+			"RegisterHandler",
+			func(mux *http.ServeMux, prefix string) { RegisterHandler(prefix, mux) },
+			func(mux *http.ServeMux, prefix string) { RegisterAuthHandler("secret", prefix, mux) },
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			tc.open(mux, "/open/")
+			tc.secret(mux, "/auth/")
+			for _, rt := range legacyRoutes {
+				prefix := "/open/"
+				if rt.token {
+					prefix = "/auth/"
+				}
+				got := serveCompat(mux, rt.method, prefix, rt.target)
+				compareLegacy(t, rt.method+" "+prefix+rt.target, rt.file, rt.body, got)
+
+				r := httptest.NewRequest(rt.method, prefix+rt.target, nil)
+				if _, pattern := mux.Handler(r); pattern != prefix {
+					t.Errorf("%s %s%s: routed to %q, want %q", rt.method, prefix, rt.target, pattern, prefix)
+				}
+			}
+			if tc.name != "Handler" && methodPatterns() {
+				r := httptest.NewRequest("POST", "/open/control/gc", nil)
+				if _, pattern := mux.Handler(r); pattern != "POST /open/control/gc" {
+					t.Errorf("POST /open/control/gc: routed to %q, want %q", pattern, "POST /open/control/gc")
+				}
+			}
+		})
+	}
+}
+
+// TestLockoutCompat checks the one way in which a legacy handler with a
+// token refuses requests differently: a client that keeps getting the
+// token wrong is locked out.
+func TestLockoutCompat(t *testing.T) {
+	h := AuthHandler("secret")
+	for i := 0; i <= freeAuthFailures; i++ {
+		got := serveCompat(h, "GET", "/", "cmdline?token=wrong")
+		compareLegacy(t, "GET /cmdline?token=wrong", "auth-wrong", sameBody, got)
+	}
+	for _, target := range []string{"cmdline?token=wrong", "cmdline?token=secret"} {
+		if w := serveCompat(h, "GET", "/", target); w.Code != http.StatusTooManyRequests {
+			t.Errorf("GET /%s when locked out: %d, want %d", target, w.Code, http.StatusTooManyRequests)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package netbug
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// blockProfileRate is the rate last passed to runtime.SetBlockProfileRate
+// by netbug, as the runtime doesn't report it.
+var blockProfileRate atomic.Int64
+
+// setBlockProfileRate sets the block profile rate, as with
+// runtime.SetBlockProfileRate, remembering it so that it can be reported.
+func setBlockProfileRate(rate int) {
+	if rate < 0 {
+		rate = 0
+	}
+	runtime.SetBlockProfileRate(rate)
+	blockProfileRate.Store(int64(rate))
+}
+
+// WithBlockProfileRate enables the block profile, which is empty unless a
+// rate is set, by calling runtime.SetBlockProfileRate with rate when the
+// Debugger is created. On average, one blocking event is sampled per rate
+// nanoseconds spent blocked; 1 samples every event.
+//
+// The rate can also be changed at runtime by POSTing a rate parameter to
+// <prefix>control/block.
+func WithBlockProfileRate(rate int) Option {
+	return func(d *Debugger) {
+		setBlockProfileRate(rate)
+	}
+}
+
+// WithMutexProfileFraction enables the mutex profile, which is empty
+// unless a fraction is set, by calling runtime.SetMutexProfileFraction
+// with rate when the Debugger is created. On average, 1/rate of mutex
+// contention events are sampled.
+//
+// The fraction can also be changed at runtime by POSTing a rate parameter
+// to <prefix>control/mutex.
+func WithMutexProfileFraction(rate int) Option {
+	return func(d *Debugger) {
+		setMutexProfileFraction(rate)
+	}
+}
+
+// setMutexProfileFraction sets the mutex profile fraction, as with
+// runtime.SetMutexProfileFraction.
+func setMutexProfileFraction(rate int) {
+	if rate < 0 {
+		rate = 0
+	}
+	runtime.SetMutexProfileFraction(rate)
+}
+
+// mutexProfileFraction returns the current mutex profile fraction.
+func mutexProfileFraction() int {
+	return runtime.SetMutexProfileFraction(-1)
+}
+
+// rateControl is a runtime profiling rate that can be changed at runtime.
+type rateControl struct {
+	get func() int
+	set func(int)
+
+	// armRate is the rate used when the profile is armed without one.
+	armRate int
+}
+
+// rateControls are the rates that can be changed, by profile name.
+var rateControls = map[string]rateControl{
+	"block": {
+		get:     func() int { return int(blockProfileRate.Load()) },
+		set:     setBlockProfileRate,
+		armRate: 10000,
+	},
+	"mutex": {
+		get:     mutexProfileFraction,
+		set:     setMutexProfileFraction,
+		armRate: 10,
+	},
+}
+
+// maxArmFor is the longest a profile can be armed for.
+const maxArmFor = time.Hour
+
+// arming is a profile that has been armed, which is disarmed by timer.
+type arming struct {
+	timer *time.Timer
+	prev  int // the rate to restore
+	until time.Time
+}
+
+// armed holds the profiles that are currently armed.
+var armed struct {
+	mu sync.Mutex
+	m  map[string]*arming
+}
+
+// arm sets the rate of profile to rate for dur, after which the rate it
+// had before it was armed is restored. Arming an armed profile extends it.
+func (d *Debugger) arm(profile string, rate int, dur time.Duration) {
+	c := rateControls[profile]
+	armed.mu.Lock()
+	defer armed.mu.Unlock()
+	if armed.m == nil {
+		armed.m = make(map[string]*arming)
+	}
+	// Each arming is a new *arming, so that the callback of a timer that
+	// fired while the profile was being re-armed, and so couldn't be
+	// stopped, finds it has been replaced and leaves the profile armed.
+	a := &arming{prev: c.get(), until: time.Now().Add(dur)}
+	if old := armed.m[profile]; old != nil {
+		old.timer.Stop()
+		a.prev = old.prev
+	}
+	armed.m[profile] = a
+	a.timer = time.AfterFunc(dur, func() {
+		armed.mu.Lock()
+		defer armed.mu.Unlock()
+		if armed.m[profile] != a {
+			return
+		}
+		delete(armed.m, profile)
+		c.set(a.prev)
+		d.logf(slog.LevelInfo, "netbug: %s profiling disarmed, rate restored to %d", profile, a.prev)
+	})
+	c.set(rate)
+}
+
+// disarm cancels the restoration of profile's rate, if it's armed.
+func disarm(profile string) {
+	armed.mu.Lock()
+	defer armed.mu.Unlock()
+	if a := armed.m[profile]; a != nil {
+		a.timer.Stop()
+		delete(armed.m, profile)
+	}
+}
+
+// armedUntil returns when profile will be disarmed, or the zero time if it
+// isn't armed.
+func armedUntil(profile string) time.Time {
+	armed.mu.Lock()
+	defer armed.mu.Unlock()
+	if a := armed.m[profile]; a != nil {
+		return a.until
+	}
+	return time.Time{}
+}
+
+// controlRate handles a POST changing the rate of profile, taking the new
+// rate from the rate parameter, and redirects back to the index page.
+// Setting the rate by hand disarms the profile.
+func controlRate(w http.ResponseWriter, r *http.Request, token, profile string) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "use POST to change the rate", http.StatusMethodNotAllowed)
+		return
+	}
+	rate, err := strconv.Atoi(r.FormValue("rate"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid rate: %v", err), http.StatusBadRequest)
+		return
+	}
+	disarm(profile)
+	rateControls[profile].set(rate)
+	redirectIndex(w, token)
+}
+
+// controlArm handles a POST arming the profile named by the profile
+// parameter, "block" or "mutex", for the number of seconds in the seconds
+// parameter (60 by default), so that the profiling overhead can't be left
+// on by accident. The rate parameter sets the rate to use while armed.
+func (d *Debugger) controlArm(w http.ResponseWriter, r *http.Request, token string) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "use POST to arm a profile", http.StatusMethodNotAllowed)
+		return
+	}
+	profile := r.FormValue("profile")
+	c, ok := rateControls[profile]
+	if !ok {
+		http.Error(w, fmt.Sprintf("profile must be block or mutex, not %q", profile), http.StatusBadRequest)
+		return
+	}
+	dur := time.Minute
+	if s := r.FormValue("seconds"); s != "" {
+		secs, err := strconv.Atoi(s)
+		if err != nil || secs <= 0 {
+			http.Error(w, fmt.Sprintf("invalid seconds: %q", s), http.StatusBadRequest)
+			return
+		}
+		dur = time.Duration(secs) * time.Second
+	}
+	if dur > maxArmFor {
+		http.Error(w, fmt.Sprintf("profiles can be armed for at most %v", maxArmFor), http.StatusBadRequest)
+		return
+	}
+	rate := c.armRate
+	if s := r.FormValue("rate"); s != "" {
+		var err error
+		if rate, err = strconv.Atoi(s); err != nil || rate <= 0 {
+			http.Error(w, fmt.Sprintf("invalid rate: %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+	d.arm(profile, rate, dur)
+	d.logf(slog.LevelInfo, "netbug: %s profiling armed at rate %d for %v", profile, rate, dur)
+	redirectIndex(w, token)
+}
+
+// redirectIndex redirects a request to a control route back to the index
+// page.
+func redirectIndex(w http.ResponseWriter, token string) {
+	loc := "../"
+	if token != "" {
+		loc += "?token=" + url.QueryEscape(token)
+	}
+	redirect(w, loc, http.StatusSeeOther)
+}
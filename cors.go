@@ -0,0 +1,57 @@
+package netbug
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// corsMaxAge is how long browsers may cache the response to a CORS
+// preflight request.
+const corsMaxAge = 10 * time.Minute
+
+// WithCORS allows pages from origins, such as "https://dash.example.com",
+// to make requests to the Debugger with XHR or fetch, such as an internal
+// dashboard fetching the endpoint catalog or profiles. Responses to their
+// requests carry the CORS headers browsers require, preflight requests are
+// answered without authentication, and their state-changing requests,
+// which are otherwise refused as cross-origin, are allowed. Pages must
+// still authenticate, such as with a bearer token in the Authorization
+// header.
+func WithCORS(origins []string) Option {
+	return func(d *Debugger) {
+		if d.corsOrigins == nil {
+			d.corsOrigins = make(map[string]bool)
+		}
+		for _, o := range origins {
+			d.corsOrigins[o] = true
+		}
+	}
+}
+
+// allowsOrigin reports whether d allows requests from pages from origin.
+func (d *Debugger) allowsOrigin(origin string) bool {
+	return origin != "" && d.corsOrigins[origin]
+}
+
+// serveCORS sets the CORS headers for r on w if it is from an origin d
+// allows, reporting whether r was a preflight request, which it answers.
+func (d *Debugger) serveCORS(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if !d.allowsOrigin(origin) {
+		return false
+	}
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Set("Access-Control-Allow-Credentials", "true")
+	h.Set("Access-Control-Expose-Headers", "Content-Disposition, Retry-After, X-Request-Id")
+	if r.Method != "OPTIONS" || r.Header.Get("Access-Control-Request-Method") == "" {
+		return false
+	}
+	h.Set("Access-Control-Allow-Methods", "GET, HEAD, POST, DELETE")
+	h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	h.Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package netbug
+
+import (
+	"errors"
+	"time"
+)
+
+// processCPUTime returns the user and system CPU time consumed by the
+// process so far.
+func processCPUTime() (time.Duration, error) {
+	return 0, errors.New("netbug: process CPU time is not available on this platform")
+}
@@ -0,0 +1,134 @@
+package netbug
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+)
+
+// tracebackLevels are the levels accepted by debug.SetTraceback, least
+// detailed first.
+var tracebackLevels = []string{"none", "single", "all", "system", "crash"}
+
+// crash holds the crash diagnostics settings netbug has made, as the
+// runtime doesn't report them.
+var crash = struct {
+	mu        sync.Mutex
+	traceback string
+	output    *os.File // nil unless crash output is enabled
+}{traceback: startupTraceback()}
+
+// startupTraceback returns the traceback level set by GOTRACEBACK.
+func startupTraceback() string {
+	if t := os.Getenv("GOTRACEBACK"); t != "" {
+		return t
+	}
+	return "single"
+}
+
+// WithCrashOutputPath sets the file that fatal errors are copied to when
+// crash output is enabled at <prefix>control/crash. It defaults to a file
+// named after the executable in os.TempDir().
+func WithCrashOutputPath(path string) Option {
+	return func(d *Debugger) {
+		d.crashPath = path
+	}
+}
+
+// crashOutputPath returns the file that fatal errors are copied to when
+// crash output is enabled.
+func (d *Debugger) crashOutputPath() string {
+	if d.crashPath != "" {
+		return d.crashPath
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		exe = "netbug"
+	}
+	return filepath.Join(os.TempDir(), filepath.Base(exe)+"-crash.log")
+}
+
+// controlCrash handles a POST changing the crash diagnostics of the
+// process, so that they can be upgraded on a running instance that is
+// expected to crash. The traceback parameter sets the level passed to
+// debug.SetTraceback, which can't be lower than GOTRACEBACK. The output
+// parameter, "on" or "off", sets whether fatal errors are also copied to
+// the crash output file, as with debug.SetCrashOutput, which keeps them
+// when standard error is lost.
+func (d *Debugger) controlCrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "use POST to change crash diagnostics", http.StatusMethodNotAllowed)
+		return
+	}
+	who := r.RemoteAddr
+	if p, ok := PrincipalFrom(r.Context()); ok {
+		who = p.String() + " from " + who
+	}
+
+	crash.mu.Lock()
+	defer crash.mu.Unlock()
+	if level := r.FormValue("traceback"); level != "" {
+		valid := false
+		for _, l := range tracebackLevels {
+			valid = valid || l == level
+		}
+		if !valid {
+			http.Error(w, fmt.Sprintf("traceback must be one of %v", tracebackLevels), http.StatusBadRequest)
+			return
+		}
+		debug.SetTraceback(level)
+		d.logf(slog.LevelInfo, "netbug: traceback level %s -> %s by %s", crash.traceback, level, who)
+		crash.traceback = level
+	}
+	switch r.FormValue("output") {
+	case "":
+	case "on":
+		if crash.output != nil {
+			break
+		}
+		path := d.crashOutputPath()
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := setCrashOutput(f); err != nil {
+			f.Close()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		crash.output = f
+		d.logf(slog.LevelInfo, "netbug: crash output to %s enabled by %s", path, who)
+	case "off":
+		if crash.output == nil {
+			break
+		}
+		if err := setCrashOutput(nil); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		crash.output.Close()
+		crash.output = nil
+		d.logf(slog.LevelInfo, "netbug: crash output disabled by %s", who)
+	default:
+		http.Error(w, "output must be on or off", http.StatusBadRequest)
+		return
+	}
+	redirectIndex(w, d.linkToken(r))
+}
+
+// crashState returns the current traceback level and the file fatal
+// errors are copied to, if any.
+func crashState() (traceback, output string) {
+	crash.mu.Lock()
+	defer crash.mu.Unlock()
+	if crash.output != nil {
+		output = crash.output.Name()
+	}
+	return crash.traceback, output
+}
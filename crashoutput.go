@@ -0,0 +1,14 @@
+//go:build go1.23
+
+package netbug
+
+import (
+	"os"
+	"runtime/debug"
+)
+
+// setCrashOutput makes the runtime write a copy of fatal errors, such as
+// unrecovered panics, to f as well as to standard error. A nil f stops it.
+func setCrashOutput(f *os.File) error {
+	return debug.SetCrashOutput(f, debug.CrashOptions{})
+}
@@ -0,0 +1,14 @@
+//go:build !go1.23
+
+package netbug
+
+import (
+	"errors"
+	"os"
+)
+
+// setCrashOutput makes the runtime write a copy of fatal errors, such as
+// unrecovered panics, to f as well as to standard error. A nil f stops it.
+func setCrashOutput(f *os.File) error {
+	return errors.New("netbug: crash output requires Go 1.23 or later")
+}
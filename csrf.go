@@ -0,0 +1,34 @@
+package netbug
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// crossOrigin reports whether r is a state-changing request that a
+// browser made on behalf of a page from another origin, which is refused
+// so that a malicious page can't use an operator's session, such as their
+// cached basic authentication or sign-in cookie, to run the GC or change
+// profiling rates. Browsers identify the origin in the Sec-Fetch-Site
+// header, or in older browsers, the Origin header. Requests with neither,
+// such as those made by curl and go tool pprof, aren't from browsers and
+// are allowed.
+func crossOrigin(r *http.Request) bool {
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return false
+	}
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "":
+	case "same-origin", "none":
+		return false
+	default:
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || u.Host != r.Host
+}
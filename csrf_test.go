@@ -0,0 +1,43 @@
+package netbug
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestCrossOrigin checks that state-changing requests a browser makes for
+// a page from another origin are refused, even with a valid token, unless
+// WithCORS allows the origin, and that other requests are allowed.
+func TestCrossOrigin(t *testing.T) {
+	d := New(WithToken("secret"), WithCORS([]string{"https://dash.example.com"}))
+	for _, tc := range []struct {
+		name, method, target string
+		header               map[string]string
+		want                 int
+	}{
+		{"not from a browser", "POST", "control/gc", nil, http.StatusOK},
+		{"same origin", "POST", "control/gc", map[string]string{"Sec-Fetch-Site": "same-origin"}, http.StatusOK},
+		{"typed in", "POST", "control/gc", map[string]string{"Sec-Fetch-Site": "none"}, http.StatusOK},
+		{"same origin, old browser", "POST", "control/gc", map[string]string{"Origin": "http://example.com"}, http.StatusOK},
+		{"cross-site GET", "GET", "cmdline", map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.example"}, http.StatusOK},
+		{"allowed origin", "POST", "control/gc", map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://dash.example.com"}, http.StatusOK},
+
+		{"cross-site", "POST", "control/gc", map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.example"}, http.StatusForbidden},
+		{"same site", "POST", "control/gc", map[string]string{"Sec-Fetch-Site": "same-site"}, http.StatusForbidden},
+		{"cross-site, old browser", "POST", "control/gc", map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"opaque origin", "POST", "control/gc", map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{"cross-site DELETE", "DELETE", "control/gc", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(tc.method, "/"+tc.target, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		for k, v := range tc.header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		d.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s: %s /%s: %d %s, want %d", tc.name, tc.method, tc.target, w.Code, w.Body, tc.want)
+		}
+	}
+}
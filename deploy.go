@@ -0,0 +1,189 @@
+package netbug
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// deployTriggerPrefix prefixes the trigger of artifacts captured by
+// CaptureBaseline. The rest of the trigger is the deployed version.
+const deployTriggerPrefix = "deploy:"
+
+// errNoVersion is returned by CaptureBaseline when no version is given
+// and none is recorded in the binary.
+var errNoVersion = errors.New("netbug: no version for baseline, and none recorded in the binary")
+
+// defaultBaselineDuration is how long the CPU profile in a deploy
+// baseline runs for.
+const defaultBaselineDuration = 10 * time.Second
+
+// CaptureBaseline captures a baseline set of profiles, a CPU profile lasting
+// dur followed by heap, allocs and goroutine profiles, and keeps them in
+// d's Store with the trigger "deploy:<version>". Capturing a baseline for
+// every release means there's always something to compare against with
+// go tool pprof -diff_base when a release misbehaves.
+//
+// If version is empty, the VCS revision the binary was built from is used,
+// falling back to its module version. If d is configured WithWarmUp, the
+// capture waits for the process to warm up first.
+func (d *Debugger) CaptureBaseline(ctx context.Context, version string, dur time.Duration) ([]Artifact, error) {
+	if version == "" {
+		version = buildVersion()
+	}
+	if version == "" {
+		return nil, errNoVersion
+	}
+	if err := d.waitForWarmUp(ctx); err != nil {
+		return nil, err
+	}
+	if dur <= 0 {
+		dur = defaultBaselineDuration
+	}
+	as := d.captureAll(ctx, deployTriggerPrefix+version,
+		captureSpec{name: "profile", dur: dur},
+		captureSpec{name: "heap"},
+		captureSpec{name: "allocs"},
+		captureSpec{name: "goroutine", debug: 1},
+	)
+	if len(as) == 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("netbug: no baseline profiles could be captured")
+	}
+	return as, nil
+}
+
+// buildVersion returns the VCS revision the binary was built from, or its
+// module version if the revision isn't known.
+func buildVersion() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, s := range bi.Settings {
+		if s.Key == "vcs.revision" {
+			return s.Value
+		}
+	}
+	if bi.Main.Version == "(devel)" {
+		return ""
+	}
+	return bi.Main.Version
+}
+
+// deployBaseline is the set of artifacts captured for a deployed version.
+type deployBaseline struct {
+	Version   string     `json:"version"`
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+// deployBaselines returns the baselines in d's store, most recently
+// deployed first.
+func (d *Debugger) deployBaselines() ([]deployBaseline, error) {
+	as, err := d.store.List()
+	if err != nil {
+		return nil, err
+	}
+	idx := make(map[string]int)
+	var bs []deployBaseline
+	for _, a := range as {
+		version := strings.TrimPrefix(a.Trigger, deployTriggerPrefix)
+		if version == a.Trigger {
+			continue
+		}
+		i, ok := idx[version]
+		if !ok {
+			i = len(bs)
+			idx[version] = i
+			bs = append(bs, deployBaseline{Version: version})
+		}
+		bs[i].Artifacts = append(bs[i].Artifacts, a)
+	}
+	return bs, nil
+}
+
+// deploy captures a baseline for a new release on POST, as with
+// deployCapture. Otherwise it lists the baselines that have been
+// captured.
+func (d *Debugger) deploy(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		d.withLoad(w, r, d.deployCapture)
+		return
+	}
+
+	bs, err := d.deployBaselines()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if responseFormat(w, r) == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(bs); err != nil {
+			d.logf(slog.LevelWarn, "netbug: %v", err)
+		}
+		return
+	}
+	info := struct {
+		Baselines []deployBaseline
+		WarmUp    bool
+		Token     string
+	}{bs, d.warmUp != nil, d.linkToken(r)}
+	if err := deployTmpl.Execute(w, info); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
+
+// deployCapture captures a baseline for a new release, which deploy
+// tooling can request once the release has started, taking the version
+// from the version parameter and the CPU profile's duration from the
+// seconds parameter.
+func (d *Debugger) deployCapture(w http.ResponseWriter, r *http.Request) {
+	var dur time.Duration
+	if s := r.FormValue("seconds"); s != "" {
+		var err error
+		if dur, err = time.ParseDuration(s + "s"); err != nil {
+			http.Error(w, "invalid seconds: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	as, err := d.CaptureBaseline(r.Context(), r.FormValue("version"), dur)
+	if errors.Is(err, errNoVersion) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(as); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
+
+var deployTmpl = template.Must(template.New("deploy").Funcs(pageFuncs).Parse(`<html>
+  <head>
+    <title>Deploy Baselines</title>
+    {{pageHead}}
+  </head>
+  <body>
+    baselines captured by deploy tooling, with POST deploy?version=&lt;version&gt;{{if .WarmUp}}
+    once the process has <a href="deploy/warmup{{if .Token}}?token={{.Token}}{{end}}">warmed up</a>{{end}}:<br>
+    <table>
+      <tr><th align=left>version<th align=left>captured<th align=left>profiles
+    {{range .Baselines}}
+      <tr><td>{{.Version}}<td>{{with index .Artifacts 0}}{{.Created.Format "2006-01-02 15:04:05 MST"}}{{end}}
+        <td>{{range .Artifacts}}<a href="history/{{.ID}}{{if $.Token}}?token={{$.Token}}{{end}}">{{.Profile}}</a> {{end}}
+    {{else}}
+      <tr><td colspan=3>No baselines have been captured yet.
+    {{end}}
+    </table>
+  </body>
+</html>`))
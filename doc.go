@@ -111,4 +111,22 @@
 //
 //	$ go tool pprof https://example.com/myroute/profile
 //
+// All of the functions above accept Options, which enable netbug's other
+// features, such as scheduled captures, watchdogs and fleet views. For
+// more control, create a Debugger with New, which also has Start and Stop
+// methods for its background work:
+//
+//	d := netbug.New(
+//		netbug.WithToken("open sesame"),
+//		netbug.WithCPUWatchdog(netbug.CPUWatchdog{Threshold: 200}),
+//	)
+//	if err := d.Start(); err != nil {
+//		log.Fatal(err)
+//	}
+//	defer d.Stop()
+//	d.Register("/myroute/", r)
+//
+// Handler, AuthHandler, Register, RegisterHandler and RegisterAuthHandler
+// are thin wrappers around New, and serve the same routes as they always
+// have.
 package netbug
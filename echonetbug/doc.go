@@ -0,0 +1,11 @@
+// Package echonetbug registers netbug on an echo server, with the same
+// single call as netbug.RegisterHandler for net/http:
+//
+//	e := echo.New()
+//	echonetbug.RegisterEcho(e, "/debug", netbug.WithToken("open sesame"))
+//
+// It uses github.com/labstack/echo/v4, which netbug doesn't otherwise
+// depend on, so it is a module of its own:
+//
+//	$ go get github.com/e-dard/netbug/echonetbug
+package echonetbug
@@ -0,0 +1,29 @@
+package echonetbug
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/e-dard/netbug"
+	"github.com/labstack/echo/v4"
+)
+
+// RegisterEcho registers a netbug handler configured with the provided
+// options, as with netbug.New, on e at prefix, such as "/debug" or "/" for
+// the root, for every method. Every netbug route is served, including the index page, and the
+// handler authenticates requests itself, so no echo middleware is needed.
+// Requests for the prefix without a trailing slash are redirected to it
+// with one, so that the relative links on netbug's pages work.
+//
+// It returns the handler's Debugger, so that its background work can be
+// started with Start.
+func RegisterEcho(e *echo.Echo, prefix string, opts ...netbug.Option) *netbug.Debugger {
+	d := netbug.New(opts...)
+	// At the root, prefix is empty, and there's nothing to redirect.
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		prefix = "/" + prefix
+		e.Any(prefix, echo.WrapHandler(http.RedirectHandler(prefix+"/", http.StatusMovedPermanently)))
+	}
+	e.Any(prefix+"/*", echo.WrapHandler(http.StripPrefix(prefix+"/", d)))
+	return d
+}
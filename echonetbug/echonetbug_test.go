@@ -0,0 +1,42 @@
+package echonetbug
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// TestRegisterEcho checks that netbug is served at every spelling of a
+// prefix, including the root, and that the prefix without a trailing
+// slash redirects to it with one.
+func TestRegisterEcho(t *testing.T) {
+	for _, tc := range []struct {
+		prefix, mount string
+	}{
+		{"/debug", "/debug/"},
+		{"debug/", "/debug/"},
+		{"/a/b/", "/a/b/"},
+		{"/", "/"},
+		{"", "/"},
+	} {
+		e := echo.New()
+		RegisterEcho(e, tc.prefix)
+		for _, target := range []string{"", "cmdline"} {
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, httptest.NewRequest("GET", tc.mount+target, nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("prefix %q: GET %s%s: %d, want %d", tc.prefix, tc.mount, target, w.Code, http.StatusOK)
+			}
+		}
+		if tc.mount == "/" {
+			continue
+		}
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, httptest.NewRequest("GET", tc.mount[:len(tc.mount)-1], nil))
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != tc.mount {
+			t.Errorf("prefix %q: %d to %q, want %d to %q", tc.prefix, w.Code, w.Header().Get("Location"), http.StatusMovedPermanently, tc.mount)
+		}
+	}
+}
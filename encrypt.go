@@ -0,0 +1,25 @@
+package netbug
+
+import "io"
+
+// An Encrypter returns a writer that encrypts what is written to it to w,
+// such as to a public key, finishing the ciphertext when it is closed.
+type Encrypter func(w io.Writer) (io.WriteCloser, error)
+
+// WithEncryption encrypts exports of captured profiles, from
+// history/export, with encrypt, adding ext to their names, so that heap
+// profiles, which can contain user data, can pass safely through ticketing
+// systems and chat. Profiles served to go tool pprof are not encrypted.
+//
+// The agenetbug package encrypts exports to age recipients. For OpenPGP,
+// use an Encrypter wrapping a library such as
+// github.com/ProtonMail/go-crypto/openpgp:
+//
+//	netbug.WithEncryption(func(w io.Writer) (io.WriteCloser, error) {
+//		return openpgp.Encrypt(w, keys, nil, nil, nil)
+//	}, ".gpg")
+func WithEncryption(encrypt Encrypter, ext string) Option {
+	return func(d *Debugger) {
+		d.encrypt, d.encryptExt = encrypt, ext
+	}
+}
@@ -0,0 +1,211 @@
+// Command queuelag is an example of extending netbug with a Module. It
+// adds a page showing the lag of the application's queue consumers, the
+// kind of diagnostic an application team would add alongside netbug's
+// profiles, with the same authentication, journal, catalog entry and
+// index link as netbug's own pages.
+//
+// The queue here is simulated; a real module would ask its Kafka client,
+// or whichever queue the application consumes, for each partition's
+// offsets.
+//
+//	$ go run ./examples/queuelag
+//	$ open http://localhost:8080/debug/?token=secret
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/e-dard/netbug"
+)
+
+// A Partition is a partition of a topic consumed by the application.
+type Partition struct {
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	Head      int64  `json:"head_offset"`      // the newest message's offset
+	Committed int64  `json:"committed_offset"` // the consumer group's offset
+}
+
+// Lag returns how many messages the consumer is behind.
+func (p Partition) Lag() int64 { return p.Head - p.Committed }
+
+// A LagSource reports the offsets of the partitions the application
+// consumes, such as a Kafka admin client.
+type LagSource func() ([]Partition, error)
+
+// lagModule is a netbug.Module serving the consumer lag reported by a
+// LagSource, sampled periodically so that it can show the trend.
+type lagModule struct {
+	source LagSource
+	every  time.Duration
+
+	mu      sync.Mutex
+	samples []lagSample // oldest first
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// lagSample is the total lag at a point in time.
+type lagSample struct {
+	Time time.Time `json:"time"`
+	Lag  int64     `json:"lag"`
+}
+
+// maxSamples is the number of samples kept.
+const maxSamples = 60
+
+func (m *lagModule) Name() string { return "queues" }
+
+func (m *lagModule) Routes() []netbug.Route {
+	return []netbug.Route{
+		{Path: "", Methods: []string{"GET"}, Description: "consumer lag", Handler: http.HandlerFunc(m.page)},
+		{Path: "lag.json", Methods: []string{"GET"}, Description: "consumer lag as JSON", Handler: http.HandlerFunc(m.json)},
+	}
+}
+
+func (m *lagModule) IndexEntries() []netbug.IndexEntry {
+	return []netbug.IndexEntry{{Path: "", Title: "consumer lag"}}
+}
+
+func (m *lagModule) Start() error {
+	m.stop, m.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(m.done)
+		t := time.NewTicker(m.every)
+		defer t.Stop()
+		for {
+			m.sample()
+			select {
+			case <-t.C:
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (m *lagModule) Stop() {
+	close(m.stop)
+	<-m.done
+}
+
+// sample records the current total lag.
+func (m *lagModule) sample() {
+	ps, err := m.source()
+	if err != nil {
+		log.Printf("queues: %v", err)
+		return
+	}
+	var total int64
+	for _, p := range ps {
+		total += p.Lag()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.samples) == maxSamples {
+		m.samples = m.samples[1:]
+	}
+	m.samples = append(m.samples, lagSample{time.Now(), total})
+}
+
+// state returns the partitions, most lagged first, and the samples.
+func (m *lagModule) state() ([]Partition, []lagSample, error) {
+	ps, err := m.source()
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].Lag() > ps[j].Lag() })
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return ps, append([]lagSample(nil), m.samples...), nil
+}
+
+func (m *lagModule) json(w http.ResponseWriter, r *http.Request) {
+	ps, samples, err := m.state()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Partitions []Partition `json:"partitions"`
+		History    []lagSample `json:"history"`
+	}{ps, samples})
+}
+
+func (m *lagModule) page(w http.ResponseWriter, r *http.Request) {
+	ps, samples, err := m.state()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	info := struct {
+		Partitions []Partition
+		History    []lagSample
+		Token      string
+	}{ps, samples, r.FormValue("token")}
+	if err := pageTmpl.Execute(w, info); err != nil {
+		log.Println(err)
+	}
+}
+
+var pageTmpl = template.Must(template.New("queues").Parse(`<html>
+  <head>
+    <title>Consumer Lag</title>
+  </head>
+  <body>
+    consumer lag, most lagged first (<a href="lag.json{{if .Token}}?token={{.Token}}{{end}}">JSON</a>):<br>
+    <table>
+      <tr><th align=left>topic<th align=right>partition<th align=right>head<th align=right>committed<th align=right>lag
+    {{range .Partitions}}
+      <tr><td>{{.Topic}}<td align=right>{{.Partition}}<td align=right>{{.Head}}<td align=right>{{.Committed}}<td align=right>{{.Lag}}
+    {{end}}
+    </table>
+    <br>
+    total lag over time:<br>
+    <table>
+    {{range .History}}
+      <tr><td>{{.Time.Format "15:04:05"}}<td align=right>{{.Lag}}
+    {{end}}
+    </table>
+  </body>
+</html>`))
+
+// simulated returns a LagSource for a simulated topic, whose consumer
+// falls behind and catches up at random.
+func simulated() LagSource {
+	var mu sync.Mutex
+	ps := []Partition{{Topic: "orders", Partition: 0}, {Topic: "orders", Partition: 1}, {Topic: "payments", Partition: 0}}
+	return func() ([]Partition, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		for i := range ps {
+			ps[i].Head += rand.Int63n(100)
+			ps[i].Committed += rand.Int63n(ps[i].Head - ps[i].Committed + 1)
+		}
+		return append([]Partition(nil), ps...), nil
+	}
+}
+
+func main() {
+	d := netbug.New(
+		netbug.WithToken("secret"),
+		netbug.WithModules(&lagModule{source: simulated(), every: 5 * time.Second}),
+	)
+	if err := d.Start(); err != nil {
+		log.Fatal(err)
+	}
+	defer d.Stop()
+
+	mux := http.NewServeMux()
+	d.Register("/debug/", mux)
+	log.Fatal(http.ListenAndServe(":8080", mux))
+}
@@ -0,0 +1,156 @@
+package netbug
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A Download describes a profile being downloaded, for naming its file.
+type Download struct {
+	// Service is the name of the program, the base name of os.Args[0].
+	Service string
+
+	// Host is the name of the host.
+	Host string
+
+	// Profile is the name of the profile, such as "heap", or "profile"
+	// for a CPU profile, or "history" for an export of captured profiles.
+	Profile string
+
+	// Time is when the profile was captured.
+	Time time.Time
+
+	// Ext is the file's extension, ".pb.gz" for profiles, ".trace" for
+	// execution traces and ".txt" for profiles written as text, with
+	// debug=1 or 2. Exports of captured profiles are ".zip".
+	Ext string
+}
+
+// DefaultFilename is the name profiles are downloaded as unless changed
+// with WithFilenames, such as myservice-host1-heap-20240601T120000Z.pb.gz,
+// so that folders of profiles gathered during an incident say where each
+// came from.
+func DefaultFilename(dl Download) string {
+	return fmt.Sprintf("%s-%s-%s-%s%s", dl.Service, dl.Host, dl.Profile, dl.Time.UTC().Format("20060102T150405Z"), dl.Ext)
+}
+
+// WithFilenames names the files profiles are downloaded as with name,
+// rather than DefaultFilename. It is used for every profile served for
+// download, including CPU profiles, traces and captured profiles in the
+// history, but not those served as text, with debug=1 or 2, except when
+// they are written to a directory configured WithCaptureDir.
+func WithFilenames(name func(Download) string) Option {
+	return func(d *Debugger) {
+		d.filename = name
+	}
+}
+
+// serviceName is the name of the program, for filenames.
+var serviceName = strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+
+// downloadFilename returns the name the profile called name, written with
+// the given debug level and captured at t, is downloaded as.
+func (d *Debugger) downloadFilename(name string, debug int, t time.Time) string {
+	dl := Download{Service: serviceName, Host: hostname(), Profile: name, Time: t, Ext: ".pb.gz"}
+	switch {
+	case name == "trace":
+		dl.Ext = ".trace"
+	case debug > 0:
+		dl.Ext = ".txt"
+	}
+	return d.filenameFor(dl)
+}
+
+// filenameFor returns the name the file described by dl is downloaded as.
+func (d *Debugger) filenameFor(dl Download) string {
+	f := DefaultFilename
+	if d.filename != nil {
+		f = d.filename
+	}
+	// The name is quoted in the header, so mustn't break out of it.
+	return strings.NewReplacer(`"`, "_", `\`, "_", "/", "_", "\n", "_", "\r", "_").Replace(f(dl))
+}
+
+// named returns w, renaming the file the profile called name is served
+// as, and copying it to d's capture directory if it has one. The
+// returned writer must be closed once the profile has been served.
+func (d *Debugger) named(w http.ResponseWriter, name string) *filenameWriter {
+	return &filenameWriter{ResponseWriter: w, d: d, profile: name}
+}
+
+// filenameWriter is an http.ResponseWriter that renames the file a
+// net/http/pprof handler serves a profile as, which is always just the
+// profile's name.
+type filenameWriter struct {
+	http.ResponseWriter
+	d           *Debugger
+	profile     string
+	wroteHeader bool
+	copy        *captureFile // the copy in d's capture directory
+}
+
+func (w *filenameWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		now := time.Now()
+		if strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment") {
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", w.d.downloadFilename(w.profile, 0, now)))
+		}
+		if code == http.StatusOK && w.d.captureDir != nil {
+			debug := 0
+			if strings.HasPrefix(w.Header().Get("Content-Type"), "text/") {
+				debug = 1
+			}
+			var err error
+			if w.copy, err = w.d.captureDir.create(w.d.downloadFilename(w.profile, debug, now)); err != nil {
+				w.d.logf(slog.LevelError, "netbug: copying %s to %s: %v", w.profile, w.d.captureDir.path, err)
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *filenameWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	// Write the copy first, so that it is complete even if the client
+	// has gone away.
+	if w.copy != nil {
+		if _, err := w.copy.Write(b); err != nil {
+			w.d.logf(slog.LevelError, "netbug: copying %s to %s: %v", w.profile, w.d.captureDir.path, err)
+			w.copy.abort()
+			w.copy = nil
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// close finishes the copy of the profile in d's capture directory, if
+// any.
+func (w *filenameWriter) close() {
+	if w.copy == nil {
+		return
+	}
+	if err := w.copy.commit(); err != nil {
+		w.d.logf(slog.LevelError, "netbug: copying %s to %s: %v", w.profile, w.d.captureDir.path, err)
+	}
+	w.copy = nil
+}
+
+// Flush implements http.Flusher, so that streamed profiles aren't
+// buffered.
+func (w *filenameWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying ResponseWriter, for
+// http.ResponseController.
+func (w *filenameWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,376 @@
+package netbug
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"runtime/pprof"
+	"sort"
+	"time"
+)
+
+// selfPeer is the name used for the local instance when comparing it with
+// its peers.
+const selfPeer = "self"
+
+// goroutineGroups returns the goroutine stack groups of the instance
+// called name, which is either a peer or selfPeer.
+func (d *Debugger) goroutineGroups(ctx context.Context, name string) ([]stackGroup, error) {
+	var buf bytes.Buffer
+	if name == selfPeer {
+		if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+			return nil, err
+		}
+		return parseGoroutineProfile(&buf)
+	}
+
+	p, err := d.peer(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := d.fetchPeer(ctx, p, "goroutine", url.Values{"debug": {"1"}})
+	if err != nil {
+		return nil, err
+	}
+	return parseGoroutineProfile(bytes.NewReader(b))
+}
+
+// stackDelta compares the number of goroutines with the same stack on two
+// instances.
+type stackDelta struct {
+	Frames []stackFrame `json:"frames"`
+	A      int          `json:"a"`
+	B      int          `json:"b"`
+}
+
+// diffStacks returns the stacks whose goroutine counts differ between a
+// and b, largest difference first.
+func diffStacks(a, b []stackGroup) []stackDelta {
+	bySig := make(map[string]*stackDelta)
+	add := func(groups []stackGroup, isA bool) {
+		for _, g := range groups {
+			sig := g.signature()
+			sd, ok := bySig[sig]
+			if !ok {
+				sd = &stackDelta{Frames: g.Frames}
+				bySig[sig] = sd
+			}
+			if isA {
+				sd.A += g.Count
+			} else {
+				sd.B += g.Count
+			}
+		}
+	}
+	add(a, true)
+	add(b, false)
+
+	var deltas []stackDelta
+	for _, sd := range bySig {
+		if sd.A != sd.B {
+			deltas = append(deltas, *sd)
+		}
+	}
+	abs := func(n int) int {
+		if n < 0 {
+			return -n
+		}
+		return n
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		di, dj := abs(deltas[i].A-deltas[i].B), abs(deltas[j].A-deltas[j].B)
+		if di != dj {
+			return di > dj
+		}
+		return stackGroup{Frames: deltas[i].Frames}.signature() < stackGroup{Frames: deltas[j].Frames}.signature()
+	})
+	return deltas
+}
+
+// diffSection is a titled set of stack deltas on the comparison page.
+type diffSection struct {
+	Title  string       `json:"title"`
+	Deltas []stackDelta `json:"deltas"`
+}
+
+// goroutineDiff compares the goroutine stacks of the two instances named
+// by the a and b parameters, which are peer names or "self", as HTML or
+// JSON.
+func (d *Debugger) goroutineDiff(w http.ResponseWriter, r *http.Request) {
+	info := struct {
+		Token     string
+		A, B      string
+		Instances []string
+		Sections  []diffSection
+		Error     string
+	}{
+		Token: d.linkToken(r),
+		A:     r.FormValue("a"),
+		B:     r.FormValue("b"),
+	}
+
+	peers, err := d.peers(r.Context())
+	if err != nil {
+		info.Error = err.Error()
+	}
+	info.Instances = append(info.Instances, selfPeer)
+	for _, p := range peers {
+		info.Instances = append(info.Instances, p.Name)
+	}
+
+	if info.A != "" && info.B != "" && info.Error == "" {
+		a, err := d.goroutineGroups(r.Context(), info.A)
+		var b []stackGroup
+		if err == nil {
+			b, err = d.goroutineGroups(r.Context(), info.B)
+		}
+		if err != nil {
+			info.Error = err.Error()
+		}
+
+		onlyA := diffSection{Title: "stacks only on " + info.A, Deltas: []stackDelta{}}
+		onlyB := diffSection{Title: "stacks only on " + info.B, Deltas: []stackDelta{}}
+		both := diffSection{Title: "stacks on both, with different counts", Deltas: []stackDelta{}}
+		for _, sd := range diffStacks(a, b) {
+			switch {
+			case sd.B == 0:
+				onlyA.Deltas = append(onlyA.Deltas, sd)
+			case sd.A == 0:
+				onlyB.Deltas = append(onlyB.Deltas, sd)
+			default:
+				both.Deltas = append(both.Deltas, sd)
+			}
+		}
+		if info.Error == "" {
+			info.Sections = []diffSection{onlyA, onlyB, both}
+		}
+	}
+
+	if responseFormat(w, r) == "json" {
+		if info.Error != "" {
+			http.Error(w, info.Error, http.StatusBadGateway)
+			return
+		}
+		if info.Sections == nil {
+			http.Error(w, "a and b must name the instances to compare", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(struct {
+			SchemaVersion int           `json:"schema_version"`
+			A             string        `json:"a"`
+			B             string        `json:"b"`
+			Sections      []diffSection `json:"sections"`
+		}{schemaVersion, info.A, info.B, info.Sections}); err != nil {
+			d.logf(slog.LevelWarn, "netbug: %v", err)
+		}
+		return
+	}
+	if err := goroutineDiffTmpl.Execute(w, info); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
+
+var goroutineDiffTmpl = template.Must(template.New("goroutinediff").Funcs(pageFuncs).Parse(`<html>
+  <head>
+    <title>Goroutine Comparison</title>
+    {{pageHead}}
+  </head>
+  <body>
+    <form method="get">
+      {{if .Token}}<input type="hidden" name="token" value="{{.Token}}">{{end}}
+      compare goroutines on
+      <select name="a">{{range .Instances}}<option{{if eq . $.A}} selected{{end}}>{{.}}</option>{{end}}</select>
+      with
+      <select name="b">{{range .Instances}}<option{{if eq . $.B}} selected{{end}}>{{.}}</option>{{end}}</select>
+      <input type="submit" value="compare">
+    </form>
+    {{if .Error}}<p>Error: {{.Error}}</p>{{end}}
+    {{range .Sections}}
+    <br>
+    {{.Title}}:<br>
+    <table>
+      <tr><th align=right>{{$.A}}<th align=right>{{$.B}}<th align=left>stack
+    {{range .Deltas}}
+      <tr><td align=right valign=top>{{.A}}<td align=right valign=top>{{.B}}<td><details><summary>{{range $i, $f := .Frames}}{{if eq $i 0}}{{$f.Func}}{{end}}{{end}}</summary><pre>{{range .Frames}}{{.Func}}
+	{{.File}}:{{.Line}}
+{{end}}</pre></details>
+    {{else}}
+      <tr><td colspan=3>None.
+    {{end}}
+    </table>
+    {{end}}
+  </body>
+</html>`))
+
+// fleetRow is an instance's row on the fleet overview page.
+type fleetRow struct {
+	Name        string
+	Link        string
+	Version     string
+	Uptime      string
+	HeapInUse   string
+	Goroutines  int
+	LastCapture string
+	Outliers    map[string]float64
+	Skewed      bool
+	Error       string
+}
+
+// versionGroup is a set of instances running the same build.
+type versionGroup struct {
+	Build     string   `json:"build"`
+	Instances []string `json:"instances"`
+}
+
+// buildKey identifies the build an instance is running: its VCS revision
+// if known, otherwise its module version.
+func buildKey(s *instanceStats) string {
+	key := s.Version
+	if s.Revision != "" {
+		key = shortRevision(s.Revision)
+		if s.Modified {
+			key += " (modified)"
+		}
+	}
+	if key == "" {
+		key = "unknown"
+	}
+	return key
+}
+
+// versionSkew groups the instances in snap by the build they're running,
+// largest group first.
+func versionSkew(snap *fleetSnapshot) []versionGroup {
+	idx := make(map[string]int)
+	var groups []versionGroup
+	for _, inst := range snap.Instances {
+		if inst.Stats == nil {
+			continue
+		}
+		key := buildKey(inst.Stats)
+		i, ok := idx[key]
+		if !ok {
+			i = len(groups)
+			idx[key] = i
+			groups = append(groups, versionGroup{Build: key})
+		}
+		groups[i].Instances = append(groups[i].Instances, inst.Name)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].Instances) > len(groups[j].Instances)
+	})
+	return groups
+}
+
+// overview serves a summary of the local instance and all of its peers,
+// linking to each instance's debug pages, or as JSON, their stats and the
+// builds they are running.
+func (d *Debugger) overview(w http.ResponseWriter, r *http.Request) {
+	snap, err := d.snapshotFleet(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	factor := 3.0
+	if d.outlierDetection != nil {
+		factor = d.outlierDetection.Factor
+	}
+	flagOutliers(snap, factor)
+	versions := versionSkew(snap)
+	if responseFormat(w, r) == "json" {
+		if versions == nil {
+			versions = []versionGroup{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(struct {
+			SchemaVersion int `json:"schema_version"`
+			*fleetSnapshot
+			Versions []versionGroup `json:"versions"`
+		}{schemaVersion, snap, versions}); err != nil {
+			d.logf(slog.LevelWarn, "netbug: %v", err)
+		}
+		return
+	}
+
+	var rows []fleetRow
+	for _, inst := range snap.Instances {
+		row := fleetRow{Name: inst.Name, Link: "../peers/" + url.PathEscape(inst.Name) + "/", Error: inst.Error}
+		if inst.Name == selfPeer {
+			row.Link = "../"
+		}
+		if s := inst.Stats; s != nil {
+			row.Name += " (" + s.Instance + ")"
+			row.Version = s.Version
+			if s.Revision != "" {
+				row.Version += " " + shortRevision(s.Revision)
+			}
+			row.Uptime = time.Duration(s.Uptime * float64(time.Second)).Round(time.Second).String()
+			row.HeapInUse = formatBytes(s.HeapInUse)
+			row.Goroutines = s.Goroutines
+			if s.LastCapture != nil {
+				row.LastCapture = s.LastCapture.Format("2006-01-02 15:04:05 MST")
+			}
+			row.Outliers = inst.Outliers
+			row.Skewed = len(versions) > 1 && buildKey(s) != versions[0].Build
+		}
+		rows = append(rows, row)
+	}
+
+	info := struct {
+		Taken    time.Time
+		Rows     []fleetRow
+		Versions []versionGroup
+		Token    string
+	}{snap.Taken, rows, versions, d.linkToken(r)}
+	if err := overviewTmpl.Execute(w, info); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
+
+// shortRevision abbreviates a VCS revision for display.
+func shortRevision(rev string) string {
+	if len(rev) > 12 {
+		return rev[:12]
+	}
+	return rev
+}
+
+var overviewTmpl = template.Must(template.New("overview").Funcs(pageFuncs).Parse(`<html>
+  <head>
+    <title>Fleet Overview</title>
+    {{pageHead}}
+  </head>
+  <body>
+    {{if gt (len .Versions) 1}}
+    <p><b>Mixed versions: the fleet is running {{len .Versions}} different builds.</b></p>
+    <table>
+      <tr><th align=left>build<th align=right>instances<th align=left>
+    {{range .Versions}}
+      <tr><td>{{.Build}}<td align=right>{{len .Instances}}<td>{{range .Instances}}{{.}} {{end}}
+    {{end}}
+    </table>
+    <br>
+    {{end}}
+    instances at {{.Taken.Format "2006-01-02 15:04:05 MST"}}:<br>
+    <table>
+      <tr><th align=left>instance<th align=left>version<th align=right>uptime<th align=right>heap in use<th align=right>goroutines<th align=left>last capture<th align=left>outliers
+    {{range .Rows}}
+      <tr><td><a href="{{.Link}}{{if $.Token}}?token={{$.Token}}{{end}}">{{.Name}}</a>
+      {{if .Error}}
+        <td colspan=6>{{.Error}}
+      {{else}}
+        <td>{{.Version}}{{if .Skewed}} <b>(skew)</b>{{end}}<td align=right>{{.Uptime}}<td align=right>{{.HeapInUse}}<td align=right>{{.Goroutines}}<td>{{.LastCapture}}
+        <td>{{range $metric, $ratio := .Outliers}}<b>{{$metric}} {{printf "%.1f" $ratio}}x</b> {{end}}
+      {{end}}
+    {{end}}
+    </table>
+    <br>
+    <a href="outliers{{if .Token}}?token={{.Token}}{{end}}">outlier detection</a> |
+    <a href="goroutine-diff{{if .Token}}?token={{.Token}}{{end}}">compare goroutines</a>
+  </body>
+</html>`))
@@ -0,0 +1,48 @@
+package netbug
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// foldedFrame escapes a function name for a folded stack, in which
+// semicolons separate frames and the value follows the last space.
+var foldedFrame = strings.NewReplacer(";", ":", " ", "_")
+
+// writeFolded writes p's samples as folded stacks, as produced by Brendan
+// Gregg's stackcollapse scripts and read by flamegraph.pl: a line for each
+// distinct stack, its functions outermost first and separated by
+// semicolons, followed by its total value of the sample type named by the
+// sample_index parameter.
+func writeFolded(w io.Writer, p *profileData, name string, params url.Values) error {
+	si, err := p.sampleIndex(params.Get("sample_index"))
+	if err != nil {
+		return err
+	}
+	totals := make(map[string]int64)
+	var frames []string
+	for _, s := range p.Samples {
+		if si >= len(s.Values) || s.Values[si] == 0 || len(s.Stack) == 0 {
+			continue
+		}
+		frames = frames[:0]
+		for i := len(s.Stack) - 1; i >= 0; i-- {
+			frames = append(frames, foldedFrame.Replace(s.Stack[i].Func))
+		}
+		totals[strings.Join(frames, ";")] += s.Values[si]
+	}
+	stacks := make([]string, 0, len(totals))
+	for stack := range totals {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+	bw := bufio.NewWriter(w)
+	for _, stack := range stacks {
+		fmt.Fprintf(bw, "%s %d\n", stack, totals[stack])
+	}
+	return bw.Flush()
+}
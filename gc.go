@@ -0,0 +1,69 @@
+package netbug
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// memorySnapshot is the memory use of the process at a point in time.
+type memorySnapshot struct {
+	HeapAlloc    uint64 `json:"heap_alloc_bytes"`
+	HeapInuse    uint64 `json:"heap_inuse_bytes"`
+	HeapIdle     uint64 `json:"heap_idle_bytes"`
+	HeapReleased uint64 `json:"heap_released_bytes"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	Sys          uint64 `json:"sys_bytes"`
+	RSS          uint64 `json:"rss_bytes,omitempty"`
+	NumGC        uint32 `json:"num_gc"`
+}
+
+// readMemory returns the current memory use of the process.
+func readMemory() memorySnapshot {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	s := memorySnapshot{
+		HeapAlloc:    ms.HeapAlloc,
+		HeapInuse:    ms.HeapInuse,
+		HeapIdle:     ms.HeapIdle,
+		HeapReleased: ms.HeapReleased,
+		HeapObjects:  ms.HeapObjects,
+		Sys:          ms.Sys,
+		NumGC:        ms.NumGC,
+	}
+	if rss, err := residentSetSize(); err == nil {
+		s.RSS = rss
+	}
+	return s
+}
+
+// controlMemory handles a POST running fn, which is runtime.GC or
+// debug.FreeOSMemory, and serves the process's memory use before and
+// after as JSON. If memory use barely drops after a GC, its growth isn't
+// garbage waiting to be collected; if RSS barely drops after
+// FreeOSMemory, it isn't memory the runtime is holding on to.
+func (d *Debugger) controlMemory(w http.ResponseWriter, r *http.Request, fn func()) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "use POST to run this", http.StatusMethodNotAllowed)
+		return
+	}
+	res := struct {
+		Before   memorySnapshot `json:"before"`
+		After    memorySnapshot `json:"after"`
+		Duration float64        `json:"duration_seconds"`
+	}{Before: readMemory()}
+	start := time.Now()
+	fn()
+	res.Duration = time.Since(start).Seconds()
+	res.After = readMemory()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(res); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
@@ -0,0 +1,11 @@
+// Package ginnetbug registers netbug on a gin engine, with the same single
+// call as netbug.RegisterHandler for net/http:
+//
+//	r := gin.Default()
+//	ginnetbug.Register(r, "/debug", netbug.WithToken("open sesame"))
+//
+// It uses github.com/gin-gonic/gin, which netbug doesn't otherwise depend
+// on, so it is a module of its own:
+//
+//	$ go get github.com/e-dard/netbug/ginnetbug
+package ginnetbug
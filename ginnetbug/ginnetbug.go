@@ -0,0 +1,23 @@
+package ginnetbug
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/e-dard/netbug"
+	"github.com/gin-gonic/gin"
+)
+
+// Register registers a netbug handler configured with the provided
+// options, as with netbug.New, on r at prefix, such as "/debug" or "/" for
+// the root, for every method. It returns the handler's Debugger, so that its background work
+// can be started with Start.
+func Register(r *gin.Engine, prefix string, opts ...netbug.Option) *netbug.Debugger {
+	d := netbug.New(opts...)
+	// At the root, prefix is empty.
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		prefix = "/" + prefix
+	}
+	r.Any(prefix+"/*path", gin.WrapH(http.StripPrefix(prefix+"/", d)))
+	return d
+}
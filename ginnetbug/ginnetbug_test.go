@@ -0,0 +1,34 @@
+package ginnetbug
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestRegister checks that netbug is served at every spelling of a
+// prefix, including the root.
+func TestRegister(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, tc := range []struct {
+		prefix, mount string
+	}{
+		{"/debug", "/debug/"},
+		{"debug/", "/debug/"},
+		{"/a/b/", "/a/b/"},
+		{"/", "/"},
+		{"", "/"},
+	} {
+		r := gin.New()
+		Register(r, tc.prefix)
+		for _, target := range []string{"", "cmdline"} {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", tc.mount+target, nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("prefix %q: GET %s%s: %d, want %d", tc.prefix, tc.mount, target, w.Code, http.StatusOK)
+			}
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/e-dard/netbug
+
+go 1.21
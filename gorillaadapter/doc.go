@@ -0,0 +1,15 @@
+// Package gorillaadapter mounts netbug on a gorilla/mux router, taking
+// care of the path prefix and of stripping it from requests, including
+// on subrouters, whose routes carry their parents' prefixes:
+//
+//	r := mux.NewRouter()
+//	api := r.PathPrefix("/api").Subrouter()
+//	gorillaadapter.Register(api, "/debug", netbug.New(netbug.WithToken("open sesame")))
+//
+// serves netbug at /api/debug/.
+//
+// It uses github.com/gorilla/mux, which netbug doesn't otherwise depend
+// on, so it is a module of its own:
+//
+//	$ go get github.com/e-dard/netbug/gorillaadapter
+package gorillaadapter
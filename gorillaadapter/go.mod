@@ -0,0 +1,10 @@
+module github.com/e-dard/netbug/gorillaadapter
+
+go 1.21
+
+require (
+	github.com/e-dard/netbug v0.0.0
+	github.com/gorilla/mux v1.8.1
+)
+
+replace github.com/e-dard/netbug => ../
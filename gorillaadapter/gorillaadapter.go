@@ -0,0 +1,25 @@
+package gorillaadapter
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/e-dard/netbug"
+	"github.com/gorilla/mux"
+)
+
+// Register mounts d on r at prefix, such as "/debug", relative to any
+// prefix r has as a subrouter. Requests for the prefix without a trailing
+// slash are redirected to it with one, so that the relative links on
+// netbug's pages work. prefix mustn't contain route variables.
+func Register(r *mux.Router, prefix string, d *netbug.Debugger) error {
+	prefix = "/" + strings.Trim(prefix, "/")
+	route := r.PathPrefix(prefix + "/")
+	full, err := route.GetPathTemplate()
+	if err != nil {
+		return err
+	}
+	route.Handler(http.StripPrefix(full, d))
+	r.Handle(prefix, http.RedirectHandler(full, http.StatusMovedPermanently))
+	return nil
+}
@@ -0,0 +1,247 @@
+package netbug
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stackFrame is a single frame of a goroutine stack.
+type stackFrame struct {
+	Func string `json:"func"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// stackGroup is a set of goroutines with identical stacks, as reported by
+// the goroutine profile with debug=1.
+type stackGroup struct {
+	Count  int          `json:"count"`
+	Labels string       `json:"labels,omitempty"`
+	Frames []stackFrame `json:"frames"`
+}
+
+// signature identifies the stack of g by its function names alone, so that
+// stacks can be compared between binaries built from different revisions.
+func (g stackGroup) signature() string {
+	fs := make([]string, len(g.Frames))
+	for i, f := range g.Frames {
+		fs[i] = f.Func
+	}
+	return strings.Join(fs, "\n")
+}
+
+// parseGoroutineProfile parses the output of the goroutine profile written
+// with debug=1, which looks like:
+//
+//	goroutine profile: total 6
+//	2 @ 0x43e5ee 0x40cc5c 0x46fbc1
+//	#	0x4703a0	runtime/pprof.writeRuntimeProfile+0xc0	/usr/local/go/src/runtime/pprof/pprof.go:622
+//	#	...
+//
+// Stack groups are separated by blank lines.
+func parseGoroutineProfile(r io.Reader) ([]stackGroup, error) {
+	var (
+		groups []stackGroup
+		cur    *stackGroup
+	)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "" || strings.HasPrefix(line, "goroutine profile:"):
+			cur = nil
+		case strings.HasPrefix(line, "# labels:"):
+			if cur != nil {
+				cur.Labels = strings.TrimSpace(strings.TrimPrefix(line, "# labels:"))
+			}
+		case strings.HasPrefix(line, "#"):
+			if cur == nil {
+				continue
+			}
+			// #	0x4703a0	runtime/pprof.writeRuntimeProfile+0xc0	/path/pprof.go:622
+			f := strings.Fields(strings.TrimPrefix(line, "#"))
+			if len(f) < 2 {
+				continue
+			}
+			fn := f[1]
+			if i := strings.LastIndex(fn, "+0x"); i >= 0 {
+				fn = fn[:i]
+			}
+			frame := stackFrame{Func: fn}
+			if len(f) >= 3 {
+				loc := f[len(f)-1]
+				if i := strings.LastIndex(loc, ":"); i >= 0 {
+					frame.File = loc[:i]
+					frame.Line, _ = strconv.Atoi(loc[i+1:])
+				}
+			}
+			cur.Frames = append(cur.Frames, frame)
+		default:
+			// 2 @ 0x43e5ee 0x40cc5c ...
+			i := strings.Index(line, " @")
+			if i < 0 {
+				return nil, fmt.Errorf("netbug: unexpected line in goroutine profile: %q", line)
+			}
+			n, err := strconv.Atoi(line[:i])
+			if err != nil {
+				return nil, fmt.Errorf("netbug: unexpected line in goroutine profile: %q", line)
+			}
+			groups = append(groups, stackGroup{Count: n})
+			cur = &groups[len(groups)-1]
+		}
+	}
+	return groups, sc.Err()
+}
+
+// goroutine is a single goroutine, as reported by the goroutine profile
+// with debug=2.
+type goroutine struct {
+	ID     int64
+	State  string        // such as "chan receive" or "IO wait"
+	Wait   time.Duration // how long it has been blocked, to the minute
+	Frames []stackFrame
+	Raw    string // the goroutine's section of the dump
+}
+
+// maxGoroutineRaw is the most of a single goroutine's section of a stack
+// dump that is kept. Deeply recursive goroutines can have stacks of many
+// megabytes, and the frames past this point are rarely the interesting
+// ones.
+const maxGoroutineRaw = 64 << 10
+
+// parseGoroutineDump parses the output of the goroutine profile written
+// with debug=2, which is the same as the stack dump from an unrecovered
+// panic:
+//
+//	goroutine 7 [chan receive, 5 minutes]:
+//	main.main.func2()
+//		/path/main.go:15 +0x19
+//	created by main.main in goroutine 1
+//		/path/main.go:15 +0xe5
+//
+// Goroutines are separated by blank lines.
+func parseGoroutineDump(r io.Reader) ([]goroutine, error) {
+	var gs []goroutine
+	err := scanGoroutineDump(r, func(g goroutine) error {
+		gs = append(gs, g)
+		return nil
+	})
+	return gs, err
+}
+
+// scanGoroutineDump parses a stack dump as parseGoroutineDump does, but
+// calls fn with each goroutine as soon as it has been read rather than
+// returning them all, so that only one goroutine is held in memory at a
+// time. Frames past the first maxGoroutineRaw bytes of a goroutine are
+// dropped. If fn returns an error, scanning stops and it is returned.
+func scanGoroutineDump(r io.Reader, fn func(goroutine) error) error {
+	var (
+		cur    *goroutine
+		raw    strings.Builder
+		elided bool // whether frames have been dropped from cur
+	)
+	flush := func() error {
+		if cur == nil {
+			return nil
+		}
+		if elided {
+			raw.WriteString("...additional frames elided by netbug...\n")
+		}
+		cur.Raw = raw.String()
+		g := *cur
+		cur, elided = nil, false
+		raw.Reset()
+		return fn(g)
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			if err := flush(); err != nil {
+				return err
+			}
+			continue
+		case strings.HasPrefix(line, "goroutine "):
+			if err := flush(); err != nil {
+				return err
+			}
+			g, err := parseGoroutineHeader(line)
+			if err != nil {
+				return err
+			}
+			cur = &g
+		case cur == nil:
+			continue
+		case elided || raw.Len()+len(line) >= maxGoroutineRaw:
+			elided = true
+			continue
+		case strings.HasPrefix(line, "\t"):
+			// 	/path/main.go:15 +0x19
+			if n := len(cur.Frames); n > 0 && cur.Frames[n-1].File == "" {
+				loc := strings.TrimSpace(line)
+				if i := strings.LastIndex(loc, " +0x"); i >= 0 {
+					loc = loc[:i]
+				}
+				if i := strings.LastIndex(loc, ":"); i >= 0 {
+					cur.Frames[n-1].File = loc[:i]
+					cur.Frames[n-1].Line, _ = strconv.Atoi(loc[i+1:])
+				}
+			}
+		case strings.HasPrefix(line, "created by "):
+			// Neither this nor the location of the go statement that
+			// follows it are part of the stack. As the last frame already
+			// has a file, the location is skipped.
+		case strings.HasPrefix(line, "..."):
+			// ...additional frames elided...
+		default:
+			// main.main.func2(0x1, {0x2, 0x3})
+			fn := line
+			if strings.HasSuffix(fn, ")") {
+				if i := strings.LastIndex(fn, "("); i > 0 {
+					fn = fn[:i]
+				}
+			}
+			cur.Frames = append(cur.Frames, stackFrame{Func: fn})
+		}
+		raw.WriteString(line)
+		raw.WriteByte('\n')
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// parseGoroutineHeader parses the first line of a goroutine in a stack
+// dump, such as "goroutine 7 [chan receive, 5 minutes]:".
+func parseGoroutineHeader(line string) (goroutine, error) {
+	var g goroutine
+	f := strings.Fields(line)
+	open, end := strings.Index(line, "["), strings.LastIndex(line, "]")
+	if len(f) < 3 || open < 0 || end < open {
+		return g, fmt.Errorf("netbug: unexpected line in goroutine dump: %q", line)
+	}
+	id, err := strconv.ParseInt(f[1], 10, 64)
+	if err != nil {
+		return g, fmt.Errorf("netbug: unexpected line in goroutine dump: %q", line)
+	}
+	g.ID = id
+	for i, item := range strings.Split(line[open+1:end], ", ") {
+		if i == 0 {
+			g.State = item
+			continue
+		}
+		var mins int
+		if _, err := fmt.Sscanf(item, "%d minutes", &mins); err == nil {
+			g.Wait = time.Duration(mins) * time.Minute
+		}
+	}
+	return g, nil
+}
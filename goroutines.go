@@ -0,0 +1,363 @@
+package netbug
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"time"
+)
+
+// groupStacks merges the groups in gs that have the same signature, so
+// that goroutines blocked in the same place on different lines, or with
+// different labels, are counted together. The result is sorted by count,
+// largest first.
+func groupStacks(gs []stackGroup) []stackGroup {
+	idx := make(map[string]int)
+	var merged []stackGroup
+	for _, g := range gs {
+		sig := g.signature()
+		i, ok := idx[sig]
+		if !ok {
+			idx[sig] = len(merged)
+			merged = append(merged, stackGroup{Frames: g.Frames})
+			i = len(merged) - 1
+		}
+		merged[i].Count += g.Count
+		if g.Labels != "" && !strings.Contains(merged[i].Labels, g.Labels) {
+			if merged[i].Labels != "" {
+				merged[i].Labels += " "
+			}
+			merged[i].Labels += g.Labels
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Count > merged[j].Count
+	})
+	return merged
+}
+
+// matchStack reports whether any frame in g has a function name
+// containing match, which may be a package path or a function name.
+func matchStack(g stackGroup, match string) bool {
+	for _, f := range g.Frames {
+		if strings.Contains(f.Func, match) {
+			return true
+		}
+	}
+	return false
+}
+
+// goroutineFilter selects goroutines from a stack dump.
+type goroutineFilter struct {
+	match   string        // package or function in the stack
+	state   string        // prefix of the state, such as "chan receive"
+	minWait time.Duration // minimum time blocked
+}
+
+// parseGoroutineFilter returns the filter described by r's match, state
+// and minwait parameters.
+func parseGoroutineFilter(r *http.Request) (goroutineFilter, error) {
+	f := goroutineFilter{match: r.FormValue("match"), state: r.FormValue("state")}
+	if s := r.FormValue("minwait"); s != "" {
+		var err error
+		if f.minWait, err = time.ParseDuration(s); err != nil {
+			return f, fmt.Errorf("invalid minwait: %v", err)
+		}
+	}
+	return f, nil
+}
+
+// needsDump reports whether f can only be applied to goroutines parsed
+// from a full stack dump, as the goroutine profile with debug=1 doesn't
+// record goroutines' states.
+func (f goroutineFilter) needsDump() bool {
+	return f.state != "" || f.minWait > 0
+}
+
+// keep reports whether g is selected by f.
+func (f goroutineFilter) keep(g goroutine) bool {
+	if f.match != "" && !matchStack(stackGroup{Frames: g.Frames}, f.match) {
+		return false
+	}
+	if f.state != "" && !strings.HasPrefix(g.State, f.state) {
+		return false
+	}
+	return g.Wait >= f.minWait
+}
+
+// dumpChunk is how much of a goroutine stack dump is written before the
+// response is flushed.
+const dumpChunk = 32 << 10
+
+// eachGoroutine calls fn with each goroutine in a full stack dump, in the
+// order they appear in it, stopping if fn returns an error. The dump is
+// parsed as the runtime writes it, rather than copied into a buffer of
+// netbug's own first; the runtime still builds the whole dump in memory
+// before writing it.
+func eachGoroutine(fn func(goroutine) error) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(pprof.Lookup("goroutine").WriteTo(pw, 2))
+	}()
+	err := scanGoroutineDump(pr, fn)
+	// Unblock the runtime's write if scanning stopped early.
+	pr.Close()
+	return err
+}
+
+// filteredGoroutines returns the goroutines currently selected by f, from
+// a full stack dump.
+func filteredGoroutines(f goroutineFilter) ([]goroutine, error) {
+	var gs []goroutine
+	err := eachGoroutine(func(g goroutine) error {
+		if f.keep(g) {
+			gs = append(gs, g)
+		}
+		return nil
+	})
+	return gs, err
+}
+
+// flushWriter flushes the response it writes to after every dumpChunk
+// bytes, so that a long stack dump is sent to the client in chunks as it
+// is produced rather than accumulating in buffers along the way.
+type flushWriter struct {
+	w       http.ResponseWriter
+	n       int // bytes written since the last flush
+	written bool
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	fw.written = true
+	if fw.n += n; fw.n >= dumpChunk {
+		http.NewResponseController(fw.w).Flush()
+		fw.n = 0
+	}
+	return n, err
+}
+
+// goroutineDump serves a full goroutine stack dump, in the format of the
+// goroutine profile with debug=2, containing only the goroutines selected
+// by the match, state and minwait parameters. For example,
+//
+//	goroutine?match=net/http&state=chan receive&minwait=5m
+//
+// returns the goroutines that have been waiting to receive from a channel
+// for at least five minutes with net/http in their stack.
+//
+// The dump is written one goroutine at a time as it is parsed, and each
+// goroutine's stack is limited to maxGoroutineRaw bytes. Without a
+// filter, the dump is copied byte for byte as the runtime writes it.
+// Either way, it is flushed every dumpChunk bytes, so that the client
+// receives it as it is written, though the runtime still builds the whole
+// dump in memory first.
+func (d *Debugger) goroutineDump(w http.ResponseWriter, r *http.Request) {
+	f, err := parseGoroutineFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	fw := &flushWriter{w: w}
+	if f == (goroutineFilter{}) {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(pprof.Lookup("goroutine").WriteTo(pw, 2))
+		}()
+		_, err = io.Copy(fw, pr)
+		// Unblock the runtime's write if the client went away.
+		pr.Close()
+		if err != nil {
+			d.logf(slog.LevelWarn, "netbug: %v", err)
+		}
+		return
+	}
+	err = eachGoroutine(func(g goroutine) error {
+		if !f.keep(g) {
+			return nil
+		}
+		if fw.written {
+			if _, err := io.WriteString(fw, "\n"); err != nil {
+				return err
+			}
+		}
+		_, err := io.WriteString(fw, g.Raw)
+		return err
+	})
+	if err != nil {
+		if !fw.written {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
+
+// errFound stops scanning a stack dump once the goroutine being looked for
+// has been found.
+var errFound = errors.New("found")
+
+// goroutineStack serves the stack of the goroutine with the given ID, as it
+// appears in a full goroutine stack dump. This is useful for following up
+// on a goroutine mentioned in a panic or log line.
+func goroutineStack(w http.ResponseWriter, r *http.Request, id int64) {
+	var found goroutine
+	err := eachGoroutine(func(g goroutine) error {
+		if g.ID != id {
+			return nil
+		}
+		found = g
+		return errFound
+	})
+	switch {
+	case errors.Is(err, errFound):
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, found.Raw)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		http.Error(w, fmt.Sprintf("goroutine %d not found; it may have exited", id), http.StatusNotFound)
+	}
+}
+
+// hasGoroutineFilter reports whether r asks for goroutines to be filtered.
+func hasGoroutineFilter(r *http.Request) bool {
+	return r.FormValue("match") != "" || r.FormValue("state") != "" || r.FormValue("minwait") != ""
+}
+
+// goroutines serves the goroutines in the process. With group=1, identical
+// stacks are collapsed and sorted by the number of goroutines in them,
+// which is far easier to read than the goroutine profile when there are
+// tens of thousands of goroutines. The match parameter keeps only stacks
+// with a function whose name contains it, and format=text or format=json,
+// or an Accept header preferring them, serves plain text or JSON rather
+// than HTML. The state and minwait parameters filter goroutines as with
+// goroutineDump.
+//
+// Without group=1 the full goroutine stack dump is streamed, filtered as
+// with goroutineDump.
+func (d *Debugger) goroutines(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("group") != "1" {
+		d.goroutineDump(w, r)
+		return
+	}
+
+	f, err := parseGoroutineFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	info := struct {
+		Groups  []stackGroup
+		Total   int
+		Shown   int
+		Match   string
+		State   string
+		MinWait string
+		Token   string
+	}{Match: f.match, State: f.state, MinWait: r.FormValue("minwait"), Token: d.linkToken(r)}
+
+	if f.needsDump() {
+		// The goroutine profile doesn't record states, so group the
+		// selected goroutines from a full dump instead.
+		info.Total = runtime.NumGoroutine()
+		gs, err := filteredGoroutines(f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		groups := make([]stackGroup, len(gs))
+		for i, g := range gs {
+			groups[i] = stackGroup{Count: 1, Frames: g.Frames}
+		}
+		info.Shown, info.Groups = len(gs), groupStacks(groups)
+	} else {
+		var buf bytes.Buffer
+		if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		gs, err := parseGoroutineProfile(&buf)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, g := range groupStacks(gs) {
+			info.Total += g.Count
+			if f.match != "" && !matchStack(g, f.match) {
+				continue
+			}
+			info.Groups = append(info.Groups, g)
+			info.Shown += g.Count
+		}
+	}
+
+	switch responseFormat(w, r) {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(struct {
+			SchemaVersion int          `json:"schema_version"`
+			Total         int          `json:"total"`
+			Shown         int          `json:"shown"`
+			Groups        []stackGroup `json:"groups"`
+		}{schemaVersion, info.Total, info.Shown, info.Groups}); err != nil {
+			d.logf(slog.LevelWarn, "netbug: %v", err)
+		}
+		return
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "%d of %d goroutines in %d stacks\n", info.Shown, info.Total, len(info.Groups))
+		for _, g := range info.Groups {
+			fmt.Fprintf(w, "\n%d", g.Count)
+			if g.Labels != "" {
+				fmt.Fprintf(w, " %s", g.Labels)
+			}
+			fmt.Fprintln(w)
+			for _, f := range g.Frames {
+				fmt.Fprintf(w, "\t%s\n\t\t%s:%d\n", f.Func, f.File, f.Line)
+			}
+		}
+		return
+	}
+	if err := goroutinesTmpl.Execute(w, info); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
+
+var goroutinesTmpl = template.Must(template.New("goroutines").Funcs(pageFuncs).Parse(`<html>
+  <head>
+    <title>Goroutines</title>
+    {{pageHead}}
+  </head>
+  <body>
+    <form method="get" action="goroutines">
+      <input type="hidden" name="group" value="1">
+      {{if .Token}}<input type="hidden" name="token" value="{{.Token}}">{{end}}
+      package or function: <input type="text" name="match" value="{{.Match}}">
+      state: <input type="text" name="state" value="{{.State}}">
+      waiting at least: <input type="text" name="minwait" value="{{.MinWait}}" size=6>
+      <input type="submit" value="filter">
+    </form>
+    {{.Shown}} of {{.Total}} goroutines in {{len .Groups}} stacks
+    (<a href="goroutines?group=1&format=text{{if .Match}}&match={{.Match}}{{end}}{{if .State}}&state={{.State}}{{end}}{{if .MinWait}}&minwait={{.MinWait}}{{end}}{{if .Token}}&token={{.Token}}{{end}}">text</a>):<br>
+    <table>
+      <tr><th align=right>goroutines<th align=left>stack
+    {{range .Groups}}
+      <tr><td align=right valign=top>{{.Count}}<td><details><summary>{{range $i, $f := .Frames}}{{if eq $i 0}}{{$f.Func}}{{end}}{{end}}{{if .Labels}} {{.Labels}}{{end}}</summary><pre>{{range .Frames}}{{.Func}}
+	{{.File}}:{{.Line}}
+{{end}}</pre></details>
+    {{end}}
+    </table>
+  </body>
+</html>`))
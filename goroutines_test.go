@@ -0,0 +1,63 @@
+package netbug
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// flushRecorder is a ResponseRecorder that records how much of the body
+// had been written each time the response was flushed.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushed []int
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushed = append(r.flushed, r.Body.Len())
+	r.ResponseRecorder.Flush()
+}
+
+//go:noinline
+func dumpParked(started *sync.WaitGroup, c chan struct{}) {
+	started.Done()
+	<-c
+}
+
+// TestGoroutineDumpStreams checks that full goroutine stack dumps, with
+// and without filters, are flushed as they are written rather than sent
+// once complete.
+func TestGoroutineDumpStreams(t *testing.T) {
+	const parked = 2000
+	stop := make(chan struct{})
+	defer close(stop)
+	var started sync.WaitGroup
+	started.Add(parked)
+	for i := 0; i < parked; i++ {
+		go dumpParked(&started, stop)
+	}
+	started.Wait()
+
+	d := New()
+	for _, target := range []string{
+		"goroutine?debug=2",
+		"goroutine?debug=2&match=dumpParked",
+		"goroutines",
+		"goroutines?state=chan+receive",
+	} {
+		w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+		d.ServeHTTP(w, httptest.NewRequest("GET", "/"+target, nil))
+		if w.Code != 200 {
+			t.Errorf("%s: %d %s", target, w.Code, w.Body)
+			continue
+		}
+		body := w.Body.String()
+		if n := strings.Count(body, "dumpParked("); n < parked {
+			t.Errorf("%s: %d parked goroutines in dump, want at least %d", target, n, parked)
+		}
+		if len(w.flushed) < 2 || w.flushed[0] == 0 || w.flushed[0] >= len(body) {
+			t.Errorf("%s: flushed after %v of %d bytes, want in chunks as it was written", target, w.flushed, len(body))
+		}
+	}
+}
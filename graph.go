@@ -0,0 +1,573 @@
+package netbug
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults for which functions and calls are drawn in a call graph, as
+// with go tool pprof's -nodecount, -nodefraction and -edgefraction.
+const (
+	graphNodeCount    = 80
+	graphNodeFraction = 0.005
+	graphEdgeFraction = 0.001
+)
+
+// callGraph is the graph of calls between functions in a profile, as
+// drawn by go tool pprof's -dot and -svg.
+type callGraph struct {
+	name         string
+	sampleType   valueType
+	total        int64
+	maxFlat      int64        // of the nodes drawn
+	nodes        []*graphNode // by cumulative value, largest first
+	edges        []*graphEdge // by weight, largest first
+	time         time.Time
+	duration     time.Duration
+	droppedNodes int
+	droppedEdges int
+	nodeCutoff   int64
+}
+
+// graphNode is a function in a call graph.
+type graphNode struct {
+	id        int
+	name      string
+	flat, cum int64
+	kept      bool
+	stamp     int // the last sample counted in cum
+
+	// Where the node is drawn, in layers from the roots down.
+	layer, order int
+	x, y, w, h   float64
+}
+
+// graphEdge is a call in a call graph.
+type graphEdge struct {
+	caller, callee *graphNode
+	weight         int64
+	stamp          int // the last sample counted in weight
+
+	// residual is whether the call is through functions left out of
+	// the graph.
+	residual bool
+
+	// back is whether the call is drawn against the flow of the graph,
+	// up from callee to caller, as it closes a cycle.
+	back bool
+}
+
+// buildCallGraph builds the call graph of p, the profile called name, from
+// the sample type named by the sample_index parameter, keeping the
+// nodecount functions, 80 by default, with the largest cumulative values.
+func buildCallGraph(p *profileData, name string, params url.Values) (*callGraph, error) {
+	si, err := p.sampleIndex(params.Get("sample_index"))
+	if err != nil {
+		return nil, err
+	}
+	nodeCount := graphNodeCount
+	if s := params.Get("nodecount"); s != "" {
+		if nodeCount, err = strconv.Atoi(s); err != nil || nodeCount <= 0 {
+			return nil, fmt.Errorf("netbug: bad nodecount %q", s)
+		}
+	}
+	g := &callGraph{name: name, sampleType: p.SampleTypes[si], duration: time.Duration(p.DurationNanos)}
+	if p.TimeNanos != 0 {
+		g.time = time.Unix(0, p.TimeNanos)
+	}
+
+	nodes := make(map[string]*graphNode)
+	var all []*graphNode
+	for k, s := range p.Samples {
+		if si >= len(s.Values) || s.Values[si] == 0 {
+			continue
+		}
+		v := s.Values[si]
+		g.total += abs(v)
+		for i, f := range s.Stack {
+			n := nodes[f.Func]
+			if n == nil {
+				n = &graphNode{name: f.Func}
+				nodes[f.Func] = n
+				all = append(all, n)
+			}
+			if i == 0 {
+				n.flat += v
+			}
+			// Count recursive calls once.
+			if n.stamp != k+1 {
+				n.stamp = k + 1
+				n.cum += v
+			}
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if a, b := abs(all[i].cum), abs(all[j].cum); a != b {
+			return a > b
+		}
+		return all[i].name < all[j].name
+	})
+	g.nodeCutoff = int64(graphNodeFraction * float64(g.total))
+	for _, n := range all {
+		if len(g.nodes) == nodeCount || abs(n.cum) < g.nodeCutoff {
+			g.droppedNodes++
+			continue
+		}
+		n.kept, n.id = true, len(g.nodes)+1
+		g.nodes = append(g.nodes, n)
+		if abs(n.flat) > g.maxFlat {
+			g.maxFlat = abs(n.flat)
+		}
+	}
+
+	// Calls through functions that have been left out are drawn as
+	// calls between the functions either side of them.
+	edges := make(map[[2]*graphNode]*graphEdge)
+	var allEdges []*graphEdge
+	for k, s := range p.Samples {
+		if si >= len(s.Values) || s.Values[si] == 0 {
+			continue
+		}
+		var (
+			callee  *graphNode
+			skipped bool
+		)
+		for _, f := range s.Stack {
+			n := nodes[f.Func]
+			if !n.kept {
+				skipped = callee != nil
+				continue
+			}
+			if callee != nil && n != callee {
+				e := edges[[2]*graphNode{n, callee}]
+				if e == nil {
+					e = &graphEdge{caller: n, callee: callee, residual: true}
+					edges[[2]*graphNode{n, callee}] = e
+					allEdges = append(allEdges, e)
+				}
+				if e.stamp != k+1 {
+					e.stamp = k + 1
+					e.weight += s.Values[si]
+				}
+				e.residual = e.residual && skipped
+			}
+			callee, skipped = n, false
+		}
+	}
+	sort.Slice(allEdges, func(i, j int) bool {
+		if a, b := abs(allEdges[i].weight), abs(allEdges[j].weight); a != b {
+			return a > b
+		}
+		return allEdges[i].caller.id < allEdges[j].caller.id ||
+			allEdges[i].caller.id == allEdges[j].caller.id && allEdges[i].callee.id < allEdges[j].callee.id
+	})
+	edgeCutoff := int64(graphEdgeFraction * float64(g.total))
+	for _, e := range allEdges {
+		if abs(e.weight) < edgeCutoff {
+			g.droppedEdges++
+			continue
+		}
+		g.edges = append(g.edges, e)
+	}
+	return g, nil
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// legend returns the lines of text describing g.
+func (g *callGraph) legend() []string {
+	lines := []string{"Profile: " + g.name, "Type: " + g.sampleType.Type}
+	if !g.time.IsZero() {
+		lines = append(lines, "Time: "+g.time.Format("2006-01-02 15:04:05 MST"))
+	}
+	if g.duration > 0 {
+		lines = append(lines, "Duration: "+g.duration.Round(10*time.Millisecond).String())
+	}
+	var shown int64
+	for _, n := range g.nodes {
+		shown += n.flat
+	}
+	lines = append(lines, fmt.Sprintf("Showing nodes accounting for %s, %s of %s total",
+		formatValue(shown, g.sampleType.Unit), g.percent(shown), formatValue(g.total, g.sampleType.Unit)))
+	if g.droppedNodes > 0 {
+		lines = append(lines, fmt.Sprintf("Dropped %d nodes (cum <= %s)", g.droppedNodes, formatValue(g.nodeCutoff, g.sampleType.Unit)))
+	}
+	if g.droppedEdges > 0 {
+		lines = append(lines, fmt.Sprintf("Dropped %d edges", g.droppedEdges))
+	}
+	return lines
+}
+
+// percent formats v as a percentage of g's total.
+func (g *callGraph) percent(v int64) string {
+	if g.total == 0 {
+		return "0%"
+	}
+	return strconv.FormatFloat(100*float64(v)/float64(g.total), 'f', 2, 64) + "%"
+}
+
+// label returns the lines of n's label: its package and function, and
+// its flat and, if different, cumulative values.
+func (g *callGraph) label(n *graphNode) []string {
+	lines := splitFuncName(n.name)
+	lines = append(lines, fmt.Sprintf("%s (%s)", formatValue(n.flat, g.sampleType.Unit), g.percent(n.flat)))
+	if n.cum != n.flat {
+		lines = append(lines, fmt.Sprintf("of %s (%s)", formatValue(n.cum, g.sampleType.Unit), g.percent(n.cum)))
+	}
+	return lines
+}
+
+// fontSize returns the size of the text in n's label, which grows with
+// its flat value, as in go tool pprof's graphs.
+func (g *callGraph) fontSize(n *graphNode) float64 {
+	if g.maxFlat == 0 {
+		return 8
+	}
+	return 8 + math.Ceil(16*math.Sqrt(float64(abs(n.flat))/float64(g.maxFlat)))
+}
+
+// fraction returns v as a fraction of g's total.
+func (g *callGraph) fraction(v int64) float64 {
+	if g.total == 0 {
+		return 0
+	}
+	return math.Min(1, float64(abs(v))/float64(g.total))
+}
+
+// splitFuncName splits a function name into its package, such as
+// "net/http", and the rest, such as "(*conn).serve".
+func splitFuncName(name string) []string {
+	i := strings.LastIndex(name, "/") + 1
+	if j := strings.Index(name[i:], "."); j > 0 {
+		return []string{name[:i+j], name[i+j+1:]}
+	}
+	return []string{name}
+}
+
+// formatValue formats v, a value in unit, for reading, such as "1.25s"
+// for 1250000000 nanoseconds.
+func formatValue(v int64, unit string) string {
+	round := func(f float64) string {
+		return strconv.FormatFloat(math.Round(f*100)/100, 'f', -1, 64)
+	}
+	a := float64(abs(v))
+	switch unit {
+	case "nanoseconds":
+		switch {
+		case a >= 1e9:
+			return round(float64(v)/1e9) + "s"
+		case a >= 1e6:
+			return round(float64(v)/1e6) + "ms"
+		case a >= 1e3:
+			return round(float64(v)/1e3) + "us"
+		}
+		return strconv.FormatInt(v, 10) + "ns"
+	case "bytes":
+		switch {
+		case a >= 1<<30:
+			return round(float64(v)/(1<<30)) + "GB"
+		case a >= 1<<20:
+			return round(float64(v)/(1<<20)) + "MB"
+		case a >= 1<<10:
+			return round(float64(v)/(1<<10)) + "kB"
+		}
+		return strconv.FormatInt(v, 10) + "B"
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+// graphColor returns the colour of a node or edge accounting for frac of
+// a graph's total: grey for little of it, shading to red for much of it.
+// Backgrounds are paler.
+func graphColor(frac float64, background bool) string {
+	from, to := [3]float64{0x88, 0x88, 0x88}, [3]float64{0xb2, 0, 0}
+	if background {
+		from, to = [3]float64{0xf8, 0xf8, 0xf8}, [3]float64{0xed, 0xd5, 0xd5}
+	}
+	t := math.Sqrt(frac)
+	var c [3]int
+	for i := range c {
+		c[i] = int(math.Round(from[i] + (to[i]-from[i])*t))
+	}
+	return fmt.Sprintf("#%02x%02x%02x", c[0], c[1], c[2])
+}
+
+// writeGraphDOT writes the call graph of p, the profile called name, in
+// Graphviz's DOT language, as go tool pprof -dot does.
+func writeGraphDOT(w io.Writer, p *profileData, name string, params url.Values) error {
+	g, err := buildCallGraph(p, name, params)
+	if err != nil {
+		return err
+	}
+	q := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	fmt.Fprintf(w, "digraph \"%s\" {\n", q.Replace(name))
+	fmt.Fprintf(w, "node [style=filled fillcolor=\"#f8f8f8\"]\n")
+	var legend strings.Builder
+	for _, l := range g.legend() {
+		legend.WriteString(q.Replace(l) + `\l`)
+	}
+	fmt.Fprintf(w, "subgraph cluster_L { \"legend\" [shape=box fontsize=16 label=\"%s\"] }\n", legend.String())
+	for _, n := range g.nodes {
+		frac := g.fraction(n.cum)
+		fmt.Fprintf(w, "N%d [label=\"%s\" fontsize=%g shape=box tooltip=\"%s (%s)\" color=\"%s\" fillcolor=\"%s\"]\n",
+			n.id, q.Replace(strings.Join(g.label(n), "\n")), g.fontSize(n), q.Replace(n.name),
+			formatValue(n.cum, g.sampleType.Unit), graphColor(frac, false), graphColor(frac, true))
+	}
+	for _, e := range g.edges {
+		frac := g.fraction(e.weight)
+		style := ""
+		if e.residual {
+			style = " style=\"dotted\""
+		}
+		fmt.Fprintf(w, "N%d -> N%d [label=\" %s\" weight=%d penwidth=%g color=\"%s\" tooltip=\"%s -> %s (%s)\"%s]\n",
+			e.caller.id, e.callee.id, formatValue(e.weight, g.sampleType.Unit), 1+int(100*frac), 1+5*math.Round(frac*100)/100,
+			graphColor(frac, false), q.Replace(e.caller.name), q.Replace(e.callee.name), formatValue(e.weight, g.sampleType.Unit), style)
+	}
+	_, err = fmt.Fprintln(w, "}")
+	return err
+}
+
+// Spacing of graphs drawn as SVG, in pixels.
+const (
+	graphMargin     = 10
+	graphNodeGap    = 20 // between nodes in a layer
+	graphLayerGap   = 60 // between layers
+	graphLegendSize = 14 // font size of the legend
+)
+
+// layout places g's nodes in layers, so that calls flow down the page
+// where they can, in the manner of the layered layouts of Graphviz's dot,
+// much simplified: cycles are broken by reversing calls that close them,
+// each node is placed in the layer below its lowest caller, the nodes in
+// each layer are ordered by the average order of those they are
+// connected to, to reduce crossings, and then placed as close to above or
+// below them as the others in the layer allow. It returns the size of the
+// drawing, which starts at top.
+func (g *callGraph) layout(top float64) (width, height float64) {
+	calls := make(map[*graphNode][]*graphEdge) // by caller
+	for _, e := range g.edges {
+		calls[e.caller] = append(calls[e.caller], e)
+	}
+
+	// Break cycles with a depth-first search from the roots, reversing
+	// the calls that lead back to a node being searched.
+	called := make(map[*graphNode]bool)
+	for _, e := range g.edges {
+		called[e.callee] = true
+	}
+	state := make(map[*graphNode]int) // 1 while being searched, then 2
+	var search func(n *graphNode)
+	search = func(n *graphNode) {
+		state[n] = 1
+		for _, e := range calls[n] {
+			switch state[e.callee] {
+			case 0:
+				search(e.callee)
+			case 1:
+				e.back = true
+			}
+		}
+		state[n] = 2
+	}
+	for _, roots := range []bool{true, false} {
+		for _, n := range g.nodes {
+			if state[n] == 0 && called[n] != roots {
+				search(n)
+			}
+		}
+	}
+
+	// Place each node in the layer below the lowest node that points to
+	// it, once cycles are broken.
+	for changed := true; changed; {
+		changed = false
+		for _, e := range g.edges {
+			from, to := e.caller, e.callee
+			if e.back {
+				from, to = to, from
+			}
+			if to.layer < from.layer+1 {
+				to.layer, changed = from.layer+1, true
+			}
+		}
+	}
+	var layers [][]*graphNode
+	for _, n := range g.nodes {
+		for len(layers) <= n.layer {
+			layers = append(layers, nil)
+		}
+		layers[n.layer] = append(layers[n.layer], n)
+	}
+	neighbours := make(map[*graphNode][]*graphNode)
+	for _, e := range g.edges {
+		neighbours[e.caller] = append(neighbours[e.caller], e.callee)
+		neighbours[e.callee] = append(neighbours[e.callee], e.caller)
+	}
+	reorder := func(layer []*graphNode, adjacent int) {
+		key := make(map[*graphNode]float64, len(layer))
+		for _, n := range layer {
+			sum, count := 0.0, 0
+			for _, m := range neighbours[n] {
+				if m.layer == adjacent {
+					sum, count = sum+float64(m.order), count+1
+				}
+			}
+			key[n] = float64(n.order)
+			if count > 0 {
+				key[n] = sum / float64(count)
+			}
+		}
+		sort.SliceStable(layer, func(i, j int) bool { return key[layer[i]] < key[layer[j]] })
+		for i, n := range layer {
+			n.order = i
+		}
+	}
+	for _, layer := range layers {
+		for i, n := range layer {
+			n.order = i
+		}
+	}
+	for sweep := 0; sweep < 8; sweep++ {
+		if sweep%2 == 0 {
+			for i := 1; i < len(layers); i++ {
+				reorder(layers[i], i-1)
+			}
+		} else {
+			for i := len(layers) - 2; i >= 0; i-- {
+				reorder(layers[i], i+1)
+			}
+		}
+	}
+
+	// Size the nodes and place them, each layer as close under the nodes
+	// it is connected to in the layers above as it can be.
+	y := top
+	minX := math.Inf(1)
+	for i, layer := range layers {
+		var layerHeight, prevRight float64
+		var shift float64
+		for j, n := range layer {
+			fs := g.fontSize(n)
+			lines := g.label(n)
+			longest := 0
+			for _, l := range lines {
+				if len(l) > longest {
+					longest = len(l)
+				}
+			}
+			n.w, n.h = float64(longest)*fs*0.6+12, float64(len(lines))*fs*1.2+8
+			n.y = y
+			layerHeight = math.Max(layerHeight, n.h)
+
+			want := prevRight + graphNodeGap + n.w/2
+			sum, count := 0.0, 0
+			for _, m := range neighbours[n] {
+				if m.layer < i {
+					sum, count = sum+m.x+m.w/2, count+1
+				}
+			}
+			if count > 0 {
+				want = sum / float64(count)
+			}
+			n.x = want - n.w/2
+			if j > 0 && n.x < prevRight+graphNodeGap {
+				n.x = prevRight + graphNodeGap
+			}
+			shift += n.x - (want - n.w/2)
+			prevRight = n.x + n.w
+		}
+		// Nodes pushed right of where they belong pull the whole layer
+		// back left.
+		for _, n := range layer {
+			n.x -= shift / float64(len(layer))
+			minX = math.Min(minX, n.x)
+		}
+		y += layerHeight + graphLayerGap
+	}
+	for _, n := range g.nodes {
+		n.x += graphMargin - minX
+		width = math.Max(width, n.x+n.w+graphMargin)
+	}
+	return width, y - graphLayerGap + graphMargin
+}
+
+// writeGraphSVG writes the call graph of p, the profile called name, as
+// SVG, laid out as by callGraph.layout rather than with Graphviz, so that
+// it can be viewed without installing anything.
+func writeGraphSVG(w io.Writer, p *profileData, name string, params url.Values) error {
+	g, err := buildCallGraph(p, name, params)
+	if err != nil {
+		return err
+	}
+	legend := g.legend()
+	top := graphMargin + float64(len(legend))*graphLegendSize*1.3 + graphLayerGap/2
+	width, height := g.layout(top)
+	for _, l := range legend {
+		width = math.Max(width, 2*graphMargin+float64(len(l))*graphLegendSize*0.6)
+	}
+
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f" font-family="Times, serif">
+<title>%s</title>
+<defs><marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="6" markerHeight="6" orient="auto-start-reverse"><path d="M0,0 L10,5 L0,10 z" fill="context-stroke"/></marker></defs>
+<rect width="100%%" height="100%%" fill="white"/>
+`, width, height, width, height, html.EscapeString(name))
+	for i, l := range legend {
+		fmt.Fprintf(w, "<text x=\"%d\" y=\"%.1f\" font-size=\"%d\">%s</text>\n", graphMargin, graphMargin+float64(i+1)*graphLegendSize*1.3, graphLegendSize, html.EscapeString(l))
+	}
+
+	// Calls are drawn first, so that they pass under the nodes.
+	for _, e := range g.edges {
+		frac := g.fraction(e.weight)
+		from, to := e.caller, e.callee
+		x1, y1 := from.x+from.w/2, from.y+from.h
+		x2, y2 := to.x+to.w/2, to.y
+		bend := (y2 - y1) / 2
+		if e.back {
+			// Loop back up, out to the side.
+			y1, y2 = from.y, to.y+to.h
+			bend = -graphLayerGap
+		}
+		c1x, c1y, c2x, c2y := x1, y1+bend, x2, y2-bend
+		if e.back {
+			c1x, c2x = x1+from.w/2, x2+to.w/2
+		}
+		dash := ""
+		if e.residual {
+			dash = ` stroke-dasharray="2,3"`
+		}
+		color := graphColor(frac, false)
+		fmt.Fprintf(w, "<g><title>%s -&gt; %s (%s)</title><path d=\"M%.1f,%.1f C%.1f,%.1f %.1f,%.1f %.1f,%.1f\" fill=\"none\" stroke=\"%s\" stroke-width=\"%.2f\"%s marker-end=\"url(#arrow)\"/>",
+			html.EscapeString(e.caller.name), html.EscapeString(e.callee.name), formatValue(e.weight, g.sampleType.Unit),
+			x1, y1, c1x, c1y, c2x, c2y, x2, y2, color, 1+5*frac, dash)
+		// The midpoint of the curve.
+		mx, my := (x1+3*c1x+3*c2x+x2)/8, (y1+3*c1y+3*c2y+y2)/8
+		fmt.Fprintf(w, "<text x=\"%.1f\" y=\"%.1f\" font-size=\"12\">%s</text></g>\n", mx+4, my, formatValue(e.weight, g.sampleType.Unit))
+	}
+
+	for _, n := range g.nodes {
+		frac := g.fraction(n.cum)
+		fs := g.fontSize(n)
+		fmt.Fprintf(w, "<g><title>%s (%s)</title><rect x=\"%.1f\" y=\"%.1f\" width=\"%.1f\" height=\"%.1f\" fill=\"%s\" stroke=\"%s\"/>",
+			html.EscapeString(n.name), formatValue(n.cum, g.sampleType.Unit), n.x, n.y, n.w, n.h, graphColor(frac, true), graphColor(frac, false))
+		for i, l := range g.label(n) {
+			fmt.Fprintf(w, "<text x=\"%.1f\" y=\"%.1f\" font-size=\"%g\" text-anchor=\"middle\">%s</text>",
+				n.x+n.w/2, n.y+4+float64(i+1)*fs*1.2-fs*0.25, fs, html.EscapeString(l))
+		}
+		fmt.Fprintln(w, "</g>")
+	}
+	_, err = fmt.Fprintln(w, "</svg>")
+	return err
+}
@@ -0,0 +1,15 @@
+//go:build go1.24
+
+package netbug
+
+import "net/http"
+
+// enableH2C makes srv accept HTTP/2 without TLS (h2c) as well as HTTP/1,
+// as service meshes often speak h2c to the processes behind them.
+func enableH2C(srv *http.Server) {
+	var p http.Protocols
+	p.SetHTTP1(true)
+	p.SetHTTP2(true)
+	p.SetUnencryptedHTTP2(true)
+	srv.Protocols = &p
+}
@@ -0,0 +1,10 @@
+//go:build !go1.24
+
+package netbug
+
+import "net/http"
+
+// enableH2C makes srv accept HTTP/2 without TLS (h2c) as well as HTTP/1,
+// as service meshes often speak h2c to the processes behind them. It
+// requires Go 1.24 or later, and does nothing otherwise.
+func enableH2C(srv *http.Server) {}
@@ -0,0 +1,198 @@
+//go:build go1.24
+
+package netbug
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serveH2C serves d as its standalone server does, returning the base URL
+// and a client that speaks only h2c to it.
+func serveH2C(t *testing.T, d *Debugger) (string, *http.Client) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- d.Serve(ctx, l) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+
+	var p http.Protocols
+	p.SetUnencryptedHTTP2(true)
+	// Responses that aren't flushed send no headers, so time out rather
+	// than hang.
+	tr := &http.Transport{Protocols: &p, ResponseHeaderTimeout: 10 * time.Second}
+	t.Cleanup(tr.CloseIdleConnections)
+	return "http://" + l.Addr().String() + "/", &http.Client{Transport: tr}
+}
+
+// getH2C makes a GET request for url with c, failing t unless it succeeds
+// over HTTP/2.
+func getH2C(t *testing.T, c *http.Client, url string) *http.Response {
+	t.Helper()
+	resp, err := c.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ProtoMajor != 2 {
+		resp.Body.Close()
+		t.Fatalf("GET %s: %s, want HTTP/2", url, resp.Proto)
+	}
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		t.Fatalf("GET %s: %s: %s", url, resp.Status, b)
+	}
+	return resp
+}
+
+// TestH2C checks that the standalone server speaks h2c as well as HTTP/1,
+// serving the same responses over both.
+func TestH2C(t *testing.T) {
+	base, c := serveH2C(t, New())
+	resp := getH2C(t, c, base+"cmdline")
+	h2, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err = http.Get(base + "cmdline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h1, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ProtoMajor != 1 {
+		t.Errorf("plain client got %s, want HTTP/1", resp.Proto)
+	}
+	if !bytes.Equal(h1, h2) {
+		t.Errorf("h2c body %q, HTTP/1 body %q", h2, h1)
+	}
+}
+
+//go:noinline
+func h2cParked(started *sync.WaitGroup, c chan struct{}) {
+	started.Done()
+	<-c
+}
+
+// TestStreamingOverH2C checks that the endpoints that stream their
+// responses work over h2c: captures that run for as long as they're asked
+// to, dumps larger than HTTP/2's initial flow control window, and peer
+// responses, which must be passed on as they're flushed rather than when
+// they're complete.
+func TestStreamingOverH2C(t *testing.T) {
+	first, release := make(chan struct{}), make(chan struct{})
+	var releaseOnce sync.Once
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "first\n")
+		http.NewResponseController(w).Flush()
+		close(first)
+		<-release
+		io.WriteString(w, "second\n")
+	}))
+	defer peer.Close()
+	// The peer can't finish its response, and so close, until released.
+	defer releaseOnce.Do(func() { close(release) })
+
+	base, c := serveH2C(t, New(WithPeers(Peer{Name: "p", URL: peer.URL + "/"})))
+
+	t.Run("profile", func(t *testing.T) {
+		resp := getH2C(t, c, base+"profile?seconds=1")
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Profiles are gzipped protocol buffers.
+		if !bytes.HasPrefix(b, []byte{0x1f, 0x8b}) {
+			t.Errorf("profile isn't gzipped: %q", b[:min(len(b), 16)])
+		}
+	})
+
+	t.Run("trace", func(t *testing.T) {
+		resp := getH2C(t, c, base+"trace?seconds=1")
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(b, []byte("go 1.")) {
+			t.Errorf("trace has no header: %q", b[:min(len(b), 16)])
+		}
+	})
+
+	t.Run("goroutine dump", func(t *testing.T) {
+		const parked = 2000
+		stop := make(chan struct{})
+		defer close(stop)
+		var started sync.WaitGroup
+		started.Add(parked)
+		for i := 0; i < parked; i++ {
+			go h2cParked(&started, stop)
+		}
+		started.Wait()
+		resp := getH2C(t, c, base+"goroutine?debug=2&match=h2cParked")
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) <= 64<<10 {
+			t.Errorf("dump of %d bytes fits in the initial flow control window", len(b))
+		}
+		if n := bytes.Count(b, []byte("\ngoroutine ")) + 1; n < parked {
+			t.Errorf("dump has %d goroutines, want at least %d", n, parked)
+		}
+	})
+
+	t.Run("peer", func(t *testing.T) {
+		resp := getH2C(t, c, base+"peers/p/stream")
+		defer resp.Body.Close()
+		br := bufio.NewReader(resp.Body)
+		got := make(chan string, 1)
+		go func() {
+			line, _ := br.ReadString('\n')
+			got <- line
+		}()
+		<-first
+		select {
+		case line := <-got:
+			if line != "first\n" {
+				t.Errorf("first line %q, want %q", line, "first\n")
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("flushed peer response not passed on")
+		}
+		releaseOnce.Do(func() { close(release) })
+		rest, err := io.ReadAll(br)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(rest), "second\n") {
+			t.Errorf("rest %q, want %q", rest, "second\n")
+		}
+	})
+}
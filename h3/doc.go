@@ -0,0 +1,14 @@
+// Package h3 serves netbug's standalone debug server over HTTP/3, for
+// platforms that only allow QUIC listeners.
+//
+// It uses github.com/quic-go/quic-go, which netbug doesn't otherwise
+// depend on, so it is a module of its own:
+//
+//	$ go get github.com/e-dard/netbug/h3
+//
+// HTTP/3 always uses TLS, so a certificate and key are required:
+//
+//	go func() {
+//		log.Fatal(h3.ListenAndServe(":6060", "cert.pem", "key.pem", netbug.WithToken("open sesame")))
+//	}()
+package h3
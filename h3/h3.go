@@ -0,0 +1,52 @@
+package h3
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+
+	"github.com/e-dard/netbug"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Serve serves d at the root of its own ServeMux over HTTP/3 on the UDP
+// address addr until ctx is done. tlsConfig must have a certificate.
+//
+// Serve doesn't Start d.
+func Serve(ctx context.Context, d *netbug.Debugger, addr string, tlsConfig *tls.Config) error {
+	mux := http.NewServeMux()
+	d.Register("/", mux)
+	srv := &http3.Server{
+		Addr:      addr,
+		Handler:   mux,
+		TLSConfig: http3.ConfigureTLSConfig(tlsConfig),
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	srv.Close()
+	<-errc
+	return nil
+}
+
+// ListenAndServe starts a standalone debug server serving HTTP/3 on the
+// UDP address addr, with the certificate and key in certFile and keyFile,
+// configured with the provided options as with netbug.New. It starts the
+// Debugger's background work, and blocks until the server fails.
+func ListenAndServe(addr, certFile, keyFile string, opts ...netbug.Option) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	d := netbug.New(opts...)
+	if err := d.Start(); err != nil {
+		return err
+	}
+	defer d.Stop()
+	return Serve(context.Background(), d, addr, &tls.Config{Certificates: []tls.Certificate{cert}})
+}
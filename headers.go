@@ -0,0 +1,53 @@
+package netbug
+
+import "net/http"
+
+// defaultSecurityHeaders are the headers set on every response, so that
+// netbug's pages pass security scanners: its pages run no scripts and load
+// nothing but themselves, can't be framed, and neither they nor profiles
+// are cached, sniffed as another content type or leak their URLs, which
+// may contain tokens, to other sites.
+var defaultSecurityHeaders = http.Header{
+	"Content-Security-Policy": {"default-src 'none'; style-src 'unsafe-inline'; form-action 'self'; frame-ancestors 'none'; base-uri 'none'"},
+	"X-Content-Type-Options":  {"nosniff"},
+	"X-Frame-Options":         {"DENY"},
+	"Referrer-Policy":         {"no-referrer"},
+	"Cache-Control":           {"no-store"},
+}
+
+// WithSecurityHeaders sets the headers in h on every response, replacing
+// the defaults of the same name, such as to relax the
+// Content-Security-Policy for a module whose pages load scripts. A header
+// with an empty value isn't set at all. The defaults are:
+//
+//	Content-Security-Policy: default-src 'none'; style-src 'unsafe-inline'; form-action 'self'; frame-ancestors 'none'; base-uri 'none'
+//	X-Content-Type-Options: nosniff
+//	X-Frame-Options: DENY
+//	Referrer-Policy: no-referrer
+//	Cache-Control: no-store
+func WithSecurityHeaders(h http.Header) Option {
+	return func(d *Debugger) {
+		if d.headers == nil {
+			d.headers = defaultSecurityHeaders.Clone()
+		}
+		for k, v := range h {
+			k = http.CanonicalHeaderKey(k)
+			if len(v) == 0 || v[0] == "" {
+				delete(d.headers, k)
+			} else {
+				d.headers[k] = v
+			}
+		}
+	}
+}
+
+// setSecurityHeaders sets d's security headers on w.
+func (d *Debugger) setSecurityHeaders(w http.ResponseWriter) {
+	h := d.headers
+	if h == nil {
+		h = defaultSecurityHeaders
+	}
+	for k, v := range h {
+		w.Header()[k] = append([]string(nil), v...)
+	}
+}
@@ -0,0 +1,110 @@
+package netbug
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+)
+
+// Rough amounts of memory needed to capture profiles, used to refuse
+// captures that could push a process that is already short of memory over
+// its limit.
+const (
+	// heapRecordBytes is per sampled allocation site in the heap
+	// profile, which is copied out of the runtime and then encoded.
+	heapRecordBytes = 1 << 10
+	// goroutineRecordBytes is per goroutine in the goroutine profile,
+	// for which the runtime copies every goroutine's stack.
+	goroutineRecordBytes = 512
+	// goroutineDumpBytes is per goroutine in a full stack dump, which
+	// the runtime writes into a single buffer, doubling it until the
+	// dump fits.
+	goroutineDumpBytes = 1 << 10
+)
+
+// captureCost estimates how much memory capturing the profile called name
+// with the given debug level needs, or returns 0 for profiles that need
+// too little to be worth checking.
+func captureCost(name string, debugLevel int) uint64 {
+	switch name {
+	case "heap", "allocs":
+		n, _ := runtime.MemProfile(nil, true)
+		return uint64(n) * heapRecordBytes
+	case "goroutine":
+		if debugLevel == 2 {
+			return uint64(runtime.NumGoroutine()) * goroutineDumpBytes
+		}
+		return uint64(runtime.NumGoroutine()) * goroutineRecordBytes
+	}
+	return 0
+}
+
+// requestCost estimates how much memory serving r, for the endpoint called
+// name, needs, as captureCost does.
+func requestCost(r *http.Request, name string) uint64 {
+	switch {
+	case name == "goroutine" && hasGoroutineFilter(r):
+		return captureCost("goroutine", 2)
+	case name == "goroutines" && r.FormValue("group") == "1" && r.FormValue("state") == "" && r.FormValue("minwait") == "":
+		return captureCost("goroutine", 1)
+	case name == "goroutines" || strings.HasPrefix(name, "goroutines/") || name == "report":
+		return captureCost("goroutine", 2)
+	case name == "heap" || name == "allocs" || name == "goroutine":
+		debugLevel, _ := strconv.Atoi(r.FormValue("debug"))
+		return captureCost(name, debugLevel)
+	}
+	return 0
+}
+
+// memoryHeadroom returns how many more bytes the process can use before it
+// reaches the tighter of its Go memory limit (GOMEMLIMIT) and the memory
+// limit of its cgroup, and describes that limit. ok is false if neither
+// limit is set.
+func memoryHeadroom() (headroom uint64, limit string, ok bool) {
+	left := func(max, used uint64) uint64 {
+		if used > max {
+			return 0
+		}
+		return max - used
+	}
+	if lim := debug.SetMemoryLimit(-1); lim != math.MaxInt64 {
+		// The memory limit applies to all of the memory mapped by the
+		// runtime, less what it has returned to the OS.
+		s := []metrics.Sample{
+			{Name: "/memory/classes/total:bytes"},
+			{Name: "/memory/classes/heap/released:bytes"},
+		}
+		metrics.Read(s)
+		used := s[0].Value.Uint64() - s[1].Value.Uint64()
+		headroom, limit, ok = left(uint64(lim), used), "GOMEMLIMIT of "+formatBytes(uint64(lim)), true
+	}
+	if used, max, cok := cgroupMemory(); cok {
+		if h := left(max, used); !ok || h < headroom {
+			headroom, limit, ok = h, "cgroup memory limit of "+formatBytes(max), true
+		}
+	}
+	return headroom, limit, ok
+}
+
+// checkHeadroom reports whether a capture needing about cost bytes of
+// memory can go ahead. If not, because the process would come too close to
+// its memory limit, which could get it killed, it responds with 507
+// Insufficient Storage. Asking with force=1 skips the check.
+func checkHeadroom(w http.ResponseWriter, r *http.Request, cost uint64) bool {
+	if cost == 0 || r.FormValue("force") == "1" {
+		return true
+	}
+	headroom, limit, ok := memoryHeadroom()
+	if !ok || cost <= headroom {
+		return true
+	}
+	msg := fmt.Sprintf("this needs about %s of memory, but the process is only %s from its %s; add force=1 to capture it anyway",
+		formatBytes(cost), formatBytes(headroom), limit)
+	http.Error(w, msg, http.StatusInsufficientStorage)
+	return false
+}
@@ -0,0 +1,323 @@
+package netbug
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// filterArtifacts returns the artifacts in as for the profile called
+// profile and with a trigger starting with trigger, so that "watchdog"
+// selects those of every watchdog. Either may be empty to select all.
+func filterArtifacts(as []Artifact, profile, trigger string) []Artifact {
+	if profile == "" && trigger == "" {
+		return as
+	}
+	var kept []Artifact
+	for _, a := range as {
+		if (profile == "" || a.Profile == profile) && strings.HasPrefix(a.Trigger, trigger) {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
+
+// history serves the list of artifacts in d's store, as JSON with
+// format=json, along with the captures its schedules will make next. The
+// profile and trigger parameters filter the list, as with filterArtifacts.
+func (d *Debugger) history(w http.ResponseWriter, r *http.Request) {
+	all, err := d.store.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	profile, trigger := r.FormValue("profile"), r.FormValue("trigger")
+	as := filterArtifacts(all, profile, trigger)
+	upcoming := d.upcoming.list()
+	if responseFormat(w, r) == "json" {
+		if as == nil {
+			as = []Artifact{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(struct {
+			SchemaVersion int               `json:"schema_version"`
+			Artifacts     []Artifact        `json:"artifacts"`
+			Upcoming      []upcomingCapture `json:"upcoming"`
+		}{schemaVersion, as, upcoming}); err != nil {
+			d.logf(slog.LevelWarn, "netbug: %v", err)
+		}
+		return
+	}
+
+	info := struct {
+		Artifacts        []Artifact
+		Upcoming         []upcomingCapture
+		Size             int64
+		Profiles         []string
+		Triggers         []string
+		Profile, Trigger string
+		Token            string
+	}{Artifacts: as, Upcoming: upcoming, Profile: profile, Trigger: trigger, Token: d.linkToken(r)}
+	for _, a := range as {
+		info.Size += a.Size
+	}
+	// Offer every profile and kind of trigger in the store to filter by,
+	// such as "deploy" for "deploy:v1.2.3", whether or not they are
+	// currently shown.
+	profiles, triggers := make(map[string]bool), make(map[string]bool)
+	for _, a := range all {
+		if !profiles[a.Profile] {
+			profiles[a.Profile] = true
+			info.Profiles = append(info.Profiles, a.Profile)
+		}
+		kind, _, _ := strings.Cut(a.Trigger, ":")
+		if !triggers[kind] {
+			triggers[kind] = true
+			info.Triggers = append(info.Triggers, kind)
+		}
+	}
+	if err := historyTmpl.Execute(w, info); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
+
+// download serves the artifact with the given ID from d's store, or
+// reports that it isn't ready yet if it is being captured asynchronously.
+// Profiles in pprof's format can be converted with the format parameter,
+// as when they are served directly.
+func (d *Debugger) download(w http.ResponseWriter, r *http.Request, id string) {
+	if d.servePending(w, id) {
+		return
+	}
+	a, rc, err := d.store.Open(id)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	if f, ok := d.format(r, a.Profile); ok && a.Debug == 0 {
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		d.writeConverted(w, r, f, a.Profile, a.Created, data)
+		return
+	}
+	setContentDigest(w, a.SHA256)
+	if a.Debug > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", d.downloadFilename(a.Profile, a.Debug, a.Created)))
+	}
+	if _, err := io.Copy(w, rc); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
+
+// exportedArtifact describes an artifact in an export of d's store.
+type exportedArtifact struct {
+	Artifact
+	File string `json:"file"` // its name in the archive
+}
+
+// exportHistory serves a zip archive of the artifacts in d's store named
+// by the id parameters, or if there are none, of those selected by the
+// profile and trigger parameters, as the history page lists them. Each
+// artifact is named as it would be downloaded, artifacts.json in the
+// archive describes them all and SHA256SUMS lists the checksums of both,
+// signed if d is configured WithSigningKey. If d is configured
+// WithEncryption, the archive is encrypted.
+func (d *Debugger) exportHistory(w http.ResponseWriter, r *http.Request) {
+	all, err := d.store.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	as := filterArtifacts(all, r.FormValue("profile"), r.FormValue("trigger"))
+	if ids := r.Form["id"]; len(ids) > 0 {
+		selected := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			selected[id] = true
+		}
+		as = nil
+		for _, a := range all {
+			if selected[a.ID] {
+				as = append(as, a)
+			}
+		}
+	}
+	if len(as) == 0 {
+		http.Error(w, "no captured profiles selected", http.StatusNotFound)
+		return
+	}
+
+	dl := Download{Service: serviceName, Host: hostname(), Profile: "history", Time: time.Now(), Ext: ".zip"}
+	var (
+		out io.Writer = w
+		ew  io.WriteCloser
+	)
+	w.Header().Set("Content-Type", "application/zip")
+	if d.encrypt != nil {
+		if ew, err = d.encrypt(w); err != nil {
+			http.Error(w, "encrypting export: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		out, dl.Ext = ew, dl.Ext+d.encryptExt
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", d.filenameFor(dl)))
+	zw := zip.NewWriter(out)
+	manifest := []exportedArtifact{}
+	var sums checksums
+	names := make(map[string]bool)
+	for _, a := range as {
+		name := d.downloadFilename(a.Profile, a.Debug, a.Created)
+		// Profiles captured within the same second get the same name by
+		// default.
+		if names[name] {
+			name = a.ID + "-" + name
+		}
+		names[name] = true
+		sum, err := exportArtifact(zw, d.store, a, name)
+		if err != nil {
+			// The response is under way, so the archive is left
+			// truncated, which unzip reports.
+			d.logf(slog.LevelError, "netbug: exporting %s: %v", a.ID, err)
+			return
+		}
+		if sum != nil {
+			manifest = append(manifest, exportedArtifact{a, name})
+			sums.add(name, sum)
+		}
+	}
+	if err := d.finishExport(zw, manifest, &sums); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+		return
+	}
+	// The ciphertext is only finished once the archive is complete, so
+	// that a truncated export fails to decrypt.
+	if ew != nil {
+		if err := ew.Close(); err != nil {
+			d.logf(slog.LevelWarn, "netbug: %v", err)
+		}
+	}
+}
+
+// finishExport adds manifest, as artifacts.json, and the checksums of
+// everything in zw, as SHA256SUMS, to zw, signing the checksums if d has
+// a signing key, and closes zw.
+func (d *Debugger) finishExport(zw *zip.Writer, manifest []exportedArtifact, sums *checksums) error {
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	sum := sha256.Sum256(b)
+	sums.add("artifacts.json", sum[:])
+	if err := addZipFile(zw, "artifacts.json", b); err != nil {
+		return err
+	}
+	if err := addZipFile(zw, "SHA256SUMS", sums.bytes()); err != nil {
+		return err
+	}
+	if d.signingKey != nil {
+		pub, err := signingKeyPEM(d.signingKey)
+		if err != nil {
+			return err
+		}
+		if err := addZipFile(zw, "SHA256SUMS.sig", ed25519.Sign(d.signingKey, sums.bytes())); err != nil {
+			return err
+		}
+		if err := addZipFile(zw, "signing-key.pem", pub); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// addZipFile adds data to zw as the file called name.
+func addZipFile(zw *zip.Writer, name string, data []byte) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// exportArtifact adds a, from s, to zw as the file called name, returning
+// its SHA-256 checksum. It returns nil if a has been removed from s since
+// it was listed.
+func exportArtifact(zw *zip.Writer, s Store, a Artifact, name string) ([]byte, error) {
+	_, rc, err := s.Open(a.ID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: a.Created})
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), rc); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+var historyTmpl = template.Must(template.New("history").Funcs(pageFuncs).Parse(`<html>
+  <head>
+    <title>Capture History</title>
+    {{pageHead}}
+  </head>
+  <body>
+    {{if .Upcoming}}
+    scheduled captures:<br>
+    <table>
+      <tr><th align=left>next<th align=left>schedule
+    {{range .Upcoming}}
+      <tr><td>{{.Next.Format "2006-01-02 15:04:05 MST"}}<td>{{.Schedule}}
+    {{end}}
+    </table>
+    <br>
+    {{end}}
+    <form method="get" action="history">
+      {{if .Token}}<input type="hidden" name="token" value="{{.Token}}">{{end}}
+      profile: <select name="profile"><option value="">all</option>{{range .Profiles}}<option{{if eq . $.Profile}} selected{{end}}>{{.}}</option>{{end}}</select>
+      trigger: <select name="trigger"><option value="">all</option>{{range .Triggers}}<option{{if eq . $.Trigger}} selected{{end}}>{{.}}</option>{{end}}</select>
+      <input type="submit" value="filter">
+    </form>
+    <form method="get" action="history/export">
+      {{if .Token}}<input type="hidden" name="token" value="{{.Token}}">{{end}}
+      captured profiles, {{len .Artifacts}} totalling {{.Size}} bytes
+      (<a href="history/export?profile={{.Profile}}&trigger={{.Trigger}}{{if .Token}}&token={{.Token}}{{end}}">export all as zip</a>,
+      <a href="history?format=json&profile={{.Profile}}&trigger={{.Trigger}}{{if .Token}}&token={{.Token}}{{end}}">JSON</a>):<br>
+      <table>
+        <tr><th><th align=left>captured<th align=left>profile<th align=left>trigger<th align=right>bytes
+      {{range .Artifacts}}
+        <tr><td><input type="checkbox" name="id" value="{{.ID}}"><td>{{.Created.Format "2006-01-02 15:04:05 MST"}}<td><a href="history/{{.ID}}{{if $.Token}}?token={{$.Token}}{{end}}">{{.Profile}}</a>{{if .Duration}} ({{.Duration}}){{end}}{{if and (eq .Debug 0) (ne .Profile "trace")}} <a href="history/{{.ID}}?format=svg{{if $.Token}}&token={{$.Token}}{{end}}">graph</a>{{end}}<td>{{.Trigger}}<td align=right>{{.Size}}
+      {{else}}
+        <tr><td colspan=5>Nothing has been captured yet.
+      {{end}}
+      </table>
+      {{if .Artifacts}}<input type="submit" value="export selected as zip">{{end}}
+    </form>
+  </body>
+</html>`))
@@ -0,0 +1,363 @@
+package netbug
+
+import (
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"time"
+)
+
+// profileDescriptions describes the profiles, as net/http/pprof's index
+// page does.
+var profileDescriptions = map[string]string{
+	"allocs":       "A sampling of all past memory allocations.",
+	"block":        "Stack traces that led to blocking on synchronization primitives.",
+	"cmdline":      "The command line invocation of the current program.",
+	"goroutine":    "Stack traces of all current goroutines. Use debug=2 to export them in the same format as an unrecovered panic.",
+	"heap":         "A sampling of memory allocations of live objects. Use gc=1 to run a GC before taking the sample.",
+	"mutex":        "Stack traces of holders of contended mutexes.",
+	"profile":      "CPU profile, for the duration given in seconds. Investigate it with go tool pprof.",
+	"symbol":       "Maps program counters to function names, for go tool pprof.",
+	"threadcreate": "Stack traces that led to the creation of new OS threads.",
+	"trace":        "A trace of execution of the current program, for the duration given in seconds. Investigate it with go tool trace.",
+}
+
+// index serves d's index page.
+func (d *Debugger) index(w http.ResponseWriter, r *http.Request) {
+	info := struct {
+		Profiles        []indexProfile
+		Match           string
+		SortByCount     bool
+		NonZero         bool
+		Descriptions    map[string]string
+		Runtime         runtimeSummary
+		Token           string
+		Vulns           bool
+		Peers           bool
+		WarmUp          bool
+		BlockRate       int64
+		MutexRate       int
+		Armed           map[string]time.Time
+		Traceback       string
+		CrashOut        string
+		TracebackLevels []string
+		Reports         []string
+		Modules         []moduleEntry
+		Pages           []page
+		Runbooks        map[string]Runbook
+		Exposed         map[string]bool
+		ReadOnly        bool
+		EnabledUntil    time.Time
+		Metrics         bool
+		RuntimeMetrics  bool
+		Binary          bool
+		Source          bool
+		TraceFormats    []string
+		Branding        Branding
+	}{
+		Descriptions:    profileDescriptions,
+		Match:           r.FormValue("match"),
+		SortByCount:     r.FormValue("sort") == "count",
+		NonZero:         r.FormValue("nonzero") == "1",
+		Runtime:         currentRuntimeSummary(),
+		Token:           d.linkToken(r),
+		Vulns:           d.vulns != nil,
+		Peers:           d.discover != nil,
+		WarmUp:          d.warmUp != nil,
+		BlockRate:       blockProfileRate.Load(),
+		MutexRate:       mutexProfileFraction(),
+		Armed:           map[string]time.Time{"block": armedUntil("block"), "mutex": armedUntil("mutex")},
+		Runbooks:        d.runbooks,
+		TracebackLevels: tracebackLevels,
+		Reports:         d.reportNames(),
+		Modules:         d.moduleEntries(),
+		Pages:           d.pages.list(),
+		Exposed:         make(map[string]bool),
+		ReadOnly:        d.readOnly,
+		Metrics:         d.usage != nil,
+		RuntimeMetrics:  d.runtimeMetrics,
+		Binary:          d.binaryDownload,
+		Source:          d.source != nil,
+		TraceFormats:    d.traceFormatNames(),
+		Branding:        d.branding,
+	}
+	info.Profiles = d.indexProfiles(info.Match, info.NonZero)
+	if info.SortByCount {
+		sort.SliceStable(info.Profiles, func(i, j int) bool { return info.Profiles[i].Count > info.Profiles[j].Count })
+	}
+	for _, p := range []string{"profile", "trace", "cmdline", "symbol", "goroutine"} {
+		info.Exposed[p] = d.exposes(p) && (!d.readOnly || readOnlyAllows("GET", p))
+	}
+	info.Traceback, info.CrashOut = crashState()
+	info.EnabledUntil, _ = d.enabledState()
+	if responseFormat(w, r) == "json" {
+		d.indexJSON(w, info.Profiles)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTmpl.Execute(w, info); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
+
+// indexProfile is a profile listed on the index page.
+type indexProfile struct {
+	Name  string
+	Count int
+}
+
+// indexProfiles returns the profiles d exposes, sorted by name, for the
+// index page, leaving out those whose names don't contain match and, if
+// nonZero, those with no samples, so that services with many custom
+// profiles can find the ones they're after.
+func (d *Debugger) indexProfiles(match string, nonZero bool) []indexProfile {
+	var ps []indexProfile
+	for _, p := range pprof.Profiles() {
+		if !d.exposes(p.Name()) || !strings.Contains(p.Name(), match) {
+			continue
+		}
+		n := p.Count()
+		if nonZero && n == 0 {
+			continue
+		}
+		ps = append(ps, indexProfile{p.Name(), n})
+	}
+	return ps
+}
+
+// indexJSON serves the index page as JSON: the runtime state of the local
+// instance, as served at stats.json, and the profiles and pages listed.
+// Links to the other endpoints are in the endpoint catalog.
+func (d *Debugger) indexJSON(w http.ResponseWriter, profiles []indexProfile) {
+	type jsonProfile struct {
+		Name        string `json:"name"`
+		Count       int    `json:"count"`
+		Description string `json:"description,omitempty"`
+	}
+	type jsonPage struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	v := struct {
+		SchemaVersion int           `json:"schema_version"`
+		Runtime       instanceStats `json:"runtime"`
+		Profiles      []jsonProfile `json:"profiles"`
+		Pages         []jsonPage    `json:"pages"`
+		Reports       []string      `json:"reports"`
+	}{
+		SchemaVersion: schemaVersion,
+		Runtime:       d.currentStats(),
+		Profiles:      []jsonProfile{},
+		Pages:         []jsonPage{},
+		Reports:       d.reportNames(),
+	}
+	for _, p := range profiles {
+		v.Profiles = append(v.Profiles, jsonProfile{p.Name, p.Count, profileDescriptions[p.Name]})
+	}
+	for _, p := range d.pages.list() {
+		v.Pages = append(v.Pages, jsonPage{p.Name, p.Description})
+	}
+	if v.Reports == nil {
+		v.Reports = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
+
+// indexFuncs are the functions used by indexTmpl. pathEscape escapes
+// profile, module and report names for use as a path segment in a link, so
+// that names with unusual characters, such as "?", "#" or ":", link to
+// themselves rather than to a query, fragment or another scheme.
+var indexFuncs = template.FuncMap{"pathEscape": url.PathEscape}
+
+var indexTmpl = template.Must(template.New("index").Funcs(pageFuncs).Funcs(indexFuncs).Parse(`<html>
+  <head>
+    <title>{{with .Branding.Title}}{{.}}{{else}}Debug Information{{end}}</title>
+    {{pageHead}}
+    {{with .Branding.CSS}}<style>{{.}}</style>{{end}}
+  </head>
+  <body>
+    {{with .Branding.Banner}}<div class="netbug-banner">{{.}}</div><br>{{end}}
+    runtime:<br>
+    <table>
+      {{with .Runtime}}
+      <tr><td align=right>Go:<td>{{.GoVersion}} {{.Platform}}
+      <tr><td align=right>uptime:<td>{{.Uptime}}
+      <tr><td align=right>goroutines:<td>{{.Goroutines}}
+      <tr><td align=right>CPUs:<td>{{.NumCPU}} (GOMAXPROCS={{.GOMAXPROCS}})
+      <tr><td align=right>GC:<td>GOGC={{.GOGC}} GOMEMLIMIT={{.GOMEMLIMIT}}
+      <tr><td align=right>heap in use:<td>{{.HeapInUse}}
+      <tr><td align=right>last GC:<td>{{if .LastGC.IsZero}}none yet{{else}}{{.LastGC.Format "15:04:05 MST"}}, paused for {{.LastGCPause}}{{end}}
+      {{end}}
+    </table>
+    <br>
+    profiles:
+    <form style="display:inline">
+      {{with .Token}}<input type="hidden" name="token" value="{{.}}">{{end}}
+      <input type="text" name="match" value="{{.Match}}" size=12 placeholder="name">
+      <label><input type="checkbox" name="sort" value="count"{{if .SortByCount}} checked{{end}}> by count</label>
+      <label><input type="checkbox" name="nonzero" value="1"{{if .NonZero}} checked{{end}}> hide empty</label>
+      <input type="submit" value="filter">
+    </form><br>
+    <table>
+    {{range .Profiles}}
+      <tr><td align=right>{{.Count}}<td><a href="./{{pathEscape .Name}}?debug=1{{with $.Token}}&token={{.}}{{end}}">{{.Name}}</a>
+        <td><a href="./{{pathEscape .Name}}{{with $.Token}}?token={{.}}{{end}}">pprof</a>
+          <a href="./{{pathEscape .Name}}?debug=1{{with $.Token}}&token={{.}}{{end}}">debug=1</a>
+          <a href="./{{pathEscape .Name}}?format=svg{{with $.Token}}&token={{.}}{{end}}">graph</a>
+          {{if eq .Name "goroutine"}}<a href="./{{pathEscape .Name}}?debug=2{{with $.Token}}&token={{.}}{{end}}">debug=2</a>{{end}}
+        <td>{{index $.Descriptions .Name}}{{template "runbook" index $.Runbooks .Name}}
+    {{end}}
+    {{if .Exposed.profile}}<tr><td align=right><td>CPU<td>
+        <form action="profile" style="display:inline">
+          {{with .Token}}<input type="hidden" name="token" value="{{.}}">{{end}}
+          <input type="text" name="seconds" value="30" size=4> seconds
+          <select name="format"><option value="">pprof</option><option value="svg">graph</option><option value="folded">folded stacks</option><option value="speedscope">speedscope</option></select>
+          <input type="submit" value="capture">
+        </form>
+        <td>{{.Descriptions.profile}}{{template "runbook" index $.Runbooks "profile"}}{{end}}
+    {{if .Exposed.trace}}<tr><td align=right><td>trace<td>
+        <form action="trace" style="display:inline">
+          {{with .Token}}<input type="hidden" name="token" value="{{.}}">{{end}}
+          <input type="text" name="seconds" value="5" size=4> seconds
+          {{with .TraceFormats}}<select name="format"><option value="">trace</option>{{range .}}<option>{{.}}</option>{{end}}</select>{{end}}
+          <input type="submit" value="capture">
+        </form>
+        <td>{{.Descriptions.trace}}{{template "runbook" index $.Runbooks "trace"}}{{end}}
+    <tr><td align=right><td><a href="history{{with .Token}}?token={{.}}{{end}}">captured profiles</a><td><td>Profiles captured by schedules, watchdogs and deploys.{{template "runbook" index $.Runbooks "history"}}
+    <tr><td align=right><td><a href="status{{with .Token}}?token={{.}}{{end}}">capture status (JSON)</a><td><td>Captures in progress, and by whom, so as not to start one while another is running.{{template "runbook" index $.Runbooks "status"}}
+    <tr><td align=right><td><a href="jobs{{with .Token}}?token={{.}}{{end}}">capture jobs (JSON)</a><td><td>Profiles captured in the background, started with a POST, so long captures survive flaky connections.{{template "runbook" index $.Runbooks "jobs"}}
+    <tr><td align=right><td><a href="deploy{{with .Token}}?token={{.}}{{end}}">deploy baselines</a><td>{{if .WarmUp}}<a href="deploy/warmup{{with .Token}}?token={{.}}{{end}}">warm-up</a>{{end}}<td>Profiles captured after each deploy, to compare against the last.{{template "runbook" index $.Runbooks "deploy"}}
+    <tr><td align=right><td><a href="journal{{with .Token}}?token={{.}}{{end}}">request journal</a><td><td>Who requested what, and a script to replay it.{{template "runbook" index $.Runbooks "journal"}}
+    </table>
+    {{if not .ReadOnly}}
+    <br>
+    profiling controls:<br>
+    <table>
+      <tr><td align=right>block profile rate:<td>
+        <form method="post" action="control/block{{with .Token}}?token={{.}}{{end}}" style="display:inline">
+          <input type="text" name="rate" value="{{.BlockRate}}" size=8> ns
+          <input type="submit" value="set">
+        </form>
+        {{if eq .BlockRate 0}}(disabled){{else}}(one event sampled per {{.BlockRate}} ns blocked){{end}}
+        {{template "arm" (index .Armed "block")}}
+        <form method="post" action="control/arm?profile=block{{with .Token}}&token={{.}}{{end}}" style="display:inline">
+          <input type="submit" value="enable for 60s">
+        </form>{{template "runbook" index $.Runbooks "control/block"}}
+      <tr><td align=right>mutex profile fraction:<td>
+        <form method="post" action="control/mutex{{with .Token}}?token={{.}}{{end}}" style="display:inline">
+          <input type="text" name="rate" value="{{.MutexRate}}" size=8>
+          <input type="submit" value="set">
+        </form>
+        {{if eq .MutexRate 0}}(disabled){{else}}(1/{{.MutexRate}} of contention events sampled){{end}}
+        {{template "arm" (index .Armed "mutex")}}
+        <form method="post" action="control/arm?profile=mutex{{with .Token}}&token={{.}}{{end}}" style="display:inline">
+          <input type="submit" value="enable for 60s">
+        </form>{{template "runbook" index $.Runbooks "control/mutex"}}
+      <tr><td align=right>memory:<td>
+        <form method="post" action="control/gc{{with .Token}}?token={{.}}{{end}}" style="display:inline">
+          <input type="submit" value="run GC">
+        </form>
+        <form method="post" action="control/freeosmemory{{with .Token}}?token={{.}}{{end}}" style="display:inline">
+          <input type="submit" value="free OS memory">
+        </form>{{template "runbook" index $.Runbooks "control/gc"}}
+      <tr><td align=right>crash diagnostics:<td>
+        <form method="post" action="control/crash{{with .Token}}?token={{.}}{{end}}" style="display:inline">
+          traceback <select name="traceback">{{range $l := .TracebackLevels}}<option{{if eq $l $.Traceback}} selected{{end}}>{{$l}}</option>{{end}}</select>
+          <input type="submit" value="set">
+        </form>
+        <form method="post" action="control/crash{{with .Token}}?token={{.}}{{end}}" style="display:inline">
+          {{if .CrashOut}}copying fatal errors to {{.CrashOut}}
+          <input type="hidden" name="output" value="off"><input type="submit" value="stop">
+          {{else}}<input type="hidden" name="output" value="on"><input type="submit" value="copy fatal errors to a file">{{end}}
+        </form>{{template "runbook" index $.Runbooks "control/crash"}}
+      <tr><td align=right>netbug:<td>
+        <form method="post" action="control/enabled{{with .Token}}?token={{.}}{{end}}" style="display:inline">
+          <input type="hidden" name="enabled" value="false"><input type="submit" value="disable">
+        </form>
+        {{if not .EnabledUntil.IsZero}}enabled until {{.EnabledUntil.Format "15:04:05 MST"}}{{end}}
+        (while disabled, every endpoint responds with 404 until re-enabled by a POST to control/enabled?enabled=true){{template "runbook" index $.Runbooks "control/enabled"}}
+      {{if .Token}}<tr><td align=right>share access:<td>
+        <form method="post" action="control/tokens?token={{.Token}}" style="display:inline">
+          <input type="text" name="minutes" value="30" size=4> minutes
+          <label><input type="checkbox" name="once" value="true"> single use</label>
+          <input type="submit" value="mint token">
+        </form>{{template "runbook" index $.Runbooks "control/tokens"}}{{end}}
+      <tr><td align=right>runtime:<td><a href="control/runtime{{with .Token}}?token={{.}}{{end}}">GOGC, GOMEMLIMIT and GOMAXPROCS</a>{{template "runbook" index $.Runbooks "control/runtime"}}
+    </table>
+    {{end}}
+    <br>
+    debug information:<br>
+    <table>
+      {{if .Exposed.cmdline}}<tr><td align=right><td><a href="cmdline{{with .Token}}?token={{.}}{{end}}">cmdline</a><td>{{.Descriptions.cmdline}}{{template "runbook" index $.Runbooks "cmdline"}}{{end}}
+      {{if .Exposed.symbol}}<tr><td align=right><td><a href="symbol{{with .Token}}?token={{.}}{{end}}">symbol</a><td>{{.Descriptions.symbol}}{{template "runbook" index $.Runbooks "symbol"}}{{end}}
+      <tr><td align=right><td><a href="stats.json{{with .Token}}?token={{.}}{{end}}">runtime stats (JSON)</a><td>{{template "runbook" index $.Runbooks "stats.json"}}
+      <tr><td align=right><td><a href="endpoints.json{{with .Token}}?token={{.}}{{end}}">endpoint catalog (JSON)</a><td>
+      {{if .Metrics}}<tr><td align=right><td><a href="metrics{{with .Token}}?token={{.}}{{end}}">netbug usage metrics (Prometheus)</a><td>{{end}}
+      {{if .RuntimeMetrics}}<tr><td align=right><td><a href="metrics/runtime{{with .Token}}?token={{.}}{{end}}">Go runtime metrics (Prometheus)</a><td>{{template "runbook" index $.Runbooks "metrics/runtime"}}{{end}}
+      <tr><td align=right><td><a href="about{{with .Token}}?token={{.}}{{end}}">about netbug</a><td>
+      <tr><td align=right><td><a href="debug/sbom{{with .Token}}?token={{.}}{{end}}">dependencies (CycloneDX SBOM)</a><td>{{template "runbook" index $.Runbooks "debug/sbom"}}
+      <tr><td align=right><td><a href="debug/licenses{{with .Token}}?token={{.}}{{end}}">dependencies for license review (CSV)</a><td>(<a href="debug/licenses?format=json{{with .Token}}&token={{.}}{{end}}">JSON</a>)
+      {{if .Vulns}}<tr><td align=right><td><a href="debug/vulns{{with .Token}}?token={{.}}{{end}}">known vulnerabilities</a><td>{{template "runbook" index $.Runbooks "debug/vulns"}}{{end}}
+      {{if .Binary}}<tr><td align=right><td><a href="debug/binary{{with .Token}}?token={{.}}{{end}}">executable</a><td>For symbolizing profiles offline with go tool pprof.{{end}}
+      {{if .Source}}<tr><td align=right><td><a href="debug/source?func=main.main{{with .Token}}&token={{.}}{{end}}">source</a><td>Source of a function or file in the binary, with func= or file= and line=.{{end}}
+    {{if .Exposed.goroutine}}<tr><td align=right><td><a href="goroutine?debug=2{{with .Token}}&token={{.}}{{end}}">full goroutine stack dump</a><td>{{template "runbook" index $.Runbooks "goroutine"}}
+    <tr><td align=right><td><a href="goroutines?group=1{{with .Token}}&token={{.}}{{end}}">goroutines grouped by stack</a><td>{{template "runbook" index $.Runbooks "goroutines"}}
+    <tr><td align=right><td><a href="goroutines/leaks{{with .Token}}?token={{.}}{{end}}">goroutine leak analysis</a><td>{{template "runbook" index $.Runbooks "goroutines/leaks"}}{{end}}
+    </table>
+    {{if .Peers}}
+    <br>
+    fleet:<br>
+    <table>
+      <tr><td align=right><td><a href="fleet/{{with .Token}}?token={{.}}{{end}}">fleet overview</a>{{template "runbook" index $.Runbooks "fleet/"}}
+      <tr><td align=right><td><a href="fleet/outliers{{with .Token}}?token={{.}}{{end}}">outlier instances</a>{{template "runbook" index $.Runbooks "fleet/outliers"}}
+      <tr><td align=right><td><a href="fleet/goroutine-diff{{with .Token}}?token={{.}}{{end}}">compare goroutines across instances</a>{{template "runbook" index $.Runbooks "fleet/goroutine-diff"}}
+    </table>
+    {{end}}
+    {{if .Modules}}
+    <br>
+    modules:<br>
+    <table>
+    {{range .Modules}}
+      <tr><td align=right>{{.Module}}:<td><a href="./{{pathEscape .Module}}/{{.Path}}{{with $.Token}}?token={{.}}{{end}}">{{.Title}}</a>
+    {{end}}
+    </table>
+    {{end}}
+    {{if .Pages}}
+    <br>
+    pages:<br>
+    <table>
+    {{range .Pages}}
+      <tr><td align=right><td><a href="pages/{{pathEscape .Name}}{{with $.Token}}?token={{.}}{{end}}">{{.Name}}</a><td>{{.Description}}{{template "runbook" index $.Runbooks (printf "pages/%s" .Name)}}
+    {{end}}
+    </table>
+    {{end}}
+    {{if .Reports}}
+    <br>
+    reports:<br>
+    <table>
+    {{range .Reports}}
+      <tr><td align=right><td><a href="reports/{{pathEscape .}}{{with $.Token}}?token={{.}}{{end}}">{{.}}</a>{{template "runbook" index $.Runbooks (printf "reports/%s" .)}}
+    {{end}}
+    </table>
+    {{end}}
+    {{with .Branding.Links}}
+    <br>
+    links:<br>
+    <table>
+    {{range .}}
+      <tr><td align=right><td><a href="{{.URL}}">{{.Title}}</a>
+    {{end}}
+    </table>
+    {{end}}
+  </body>
+</html>
+{{define "arm"}}{{if not .IsZero}}armed until {{.Format "15:04:05 MST"}}{{end}}{{end}}
+{{define "runbook"}}{{if or .URL .Note}} ({{if .URL}}<a href="{{.URL}}">runbook</a>{{end}}{{if and .URL .Note}}: {{end}}{{.Note}}){{end}}{{end}}`))
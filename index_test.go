@@ -0,0 +1,96 @@
+package netbug
+
+import (
+	"html"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"runtime/pprof"
+	"strings"
+	"testing"
+)
+
+// odd is a name made of characters that mean something in HTML or URLs.
+const odd = `<b>&'"?#:%;x`
+
+// A profile whose name needs escaping, as the names applications and
+// libraries give pprof.NewProfile can.
+var _ = pprof.NewProfile("netbug" + odd)
+
+var hrefs = regexp.MustCompile(`href="([^"]*)"`)
+
+// TestIndexEscaping checks that the index page, registered on prefixes
+// with special characters and listing profiles, pages and reports with
+// them in their names, escapes them all, and that its links to those
+// names, with a token that needs escaping too, reach them.
+func TestIndexEscaping(t *testing.T) {
+	const token = "t&k=n#?"
+	for _, prefix := range []string{"/debug/", "/a&b/", "/<i>/", `/it's"/`, "/dé/"} {
+		t.Run(prefix, func(t *testing.T) {
+			d := New(WithToken(token), WithReport("netbug"+odd, func(w http.ResponseWriter, _ *ReportInput) error {
+				_, err := io.WriteString(w, "report")
+				return err
+			}))
+			d.RegisterPage("netbug"+odd, "A page named "+odd+".", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "page")
+			}))
+			mux := http.NewServeMux()
+			d.Register(prefix, mux)
+
+			base := &url.URL{Scheme: "http", Host: "example.com", Path: prefix, RawQuery: url.Values{"token": {token}}.Encode()}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest("GET", base.String(), nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET %s: %d %s", base, w.Code, w.Body)
+			}
+			body := w.Body.String()
+			for _, raw := range []string{"<b>", "<i>", `it's"`} {
+				if strings.Contains(body, raw) {
+					t.Errorf("index contains %q unescaped", raw)
+				}
+			}
+
+			want := map[string]string{
+				"netbug" + odd:         "netbug" + odd, // debug=1 starts with the profile's name
+				"pages/netbug" + odd:   "page",
+				"reports/netbug" + odd: "report",
+			}
+			followed := map[string]bool{}
+			for _, m := range hrefs.FindAllStringSubmatch(body, -1) {
+				u, err := base.Parse(html.UnescapeString(m[1]))
+				if err != nil {
+					t.Errorf("link %q: %v", m[1], err)
+					continue
+				}
+				name, ok := strings.CutPrefix(u.Path, prefix)
+				if !ok {
+					t.Errorf("link %q resolves to %s, outside %s", m[1], u.Path, prefix)
+					continue
+				}
+				prefixOf, ok := want[name]
+				if !ok || u.Query().Get("format") == "svg" {
+					continue
+				}
+				if u.Query().Get("token") != token {
+					t.Errorf("link %q has token %q, want %q", m[1], u.Query().Get("token"), token)
+				}
+				w := httptest.NewRecorder()
+				mux.ServeHTTP(w, httptest.NewRequest("GET", u.String(), nil))
+				if name == "netbug"+odd && u.Query().Get("debug") != "1" {
+					prefixOf = "" // a gzipped profile
+				}
+				if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), prefixOf) {
+					t.Errorf("link %q: %d %.40q, want 200 starting %q", m[1], w.Code, w.Body, prefixOf)
+				}
+				followed[name] = true
+			}
+			for name := range want {
+				if !followed[name] {
+					t.Errorf("no link to %s", name)
+				}
+			}
+		})
+	}
+}
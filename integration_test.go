@@ -0,0 +1,107 @@
+package netbug
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestIntegration captures profiles and traces from real servers, through
+// authentication, a route prefix and the peer proxy, and checks that go
+// tool pprof and go tool trace can read them, as they can from
+// net/http/pprof. It runs the go command, so it only runs with
+// NETBUG_INTEGRATION=1 set.
+func TestIntegration(t *testing.T) {
+	if os.Getenv("NETBUG_INTEGRATION") != "1" {
+		t.Skip("set NETBUG_INTEGRATION=1 to run go tool pprof and go tool trace against a server")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go command: ", err)
+	}
+
+	peerMux := http.NewServeMux()
+	New(WithToken("peer-secret")).Register("/peer/debug/", peerMux)
+	peer := httptest.NewServer(peerMux)
+	defer peer.Close()
+
+	mux := http.NewServeMux()
+	New(
+		WithToken("secret"),
+		WithPeers(Peer{Name: "peer", URL: peer.URL + "/peer/debug/", Token: "peer-secret"}),
+	).Register("/debug/", mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	// go tool pprof saves what it fetches under PPROF_TMPDIR.
+	env := append(os.Environ(), "PPROF_TMPDIR="+dir)
+	run := func(t *testing.T, args ...string) string {
+		t.Helper()
+		cmd := exec.Command(goTool, append([]string{"tool"}, args...)...)
+		cmd.Env = env
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("go tool %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+		return string(out)
+	}
+
+	for _, base := range []string{
+		srv.URL + "/debug/",
+		srv.URL + "/debug/peers/peer/",
+	} {
+		t.Run(strings.TrimPrefix(base, srv.URL), func(t *testing.T) {
+			resp, err := http.Get(base + "heap")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("heap without a token: %s, want 401", resp.Status)
+			}
+
+			for _, tc := range []struct {
+				path, typ string
+			}{
+				{"profile?seconds=1&token=secret", "Type: cpu"},
+				{"heap?token=secret", "Type: inuse_space"},
+				{"allocs?token=secret", "Type: alloc_space"},
+				{"goroutine?token=secret", "Type: goroutine"},
+			} {
+				out := run(t, "pprof", "-top", base+tc.path)
+				if !strings.Contains(out, tc.typ) {
+					t.Errorf("go tool pprof -top %s: no %q in:\n%s", tc.path, tc.typ, out)
+				}
+			}
+
+			// go tool trace reads files only.
+			resp, err = http.Get(base + "trace?seconds=1&token=secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("trace: %s: %s", resp.Status, b)
+			}
+			if !bytes.HasPrefix(b, []byte("go 1.")) {
+				t.Fatalf("trace has no header: %q", b[:min(len(b), 16)])
+			}
+			path := filepath.Join(t.TempDir(), "trace.out")
+			if err := os.WriteFile(path, b, 0644); err != nil {
+				t.Fatal(err)
+			}
+			run(t, "trace", "-d=parsed", path)
+		})
+	}
+}
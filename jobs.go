@@ -0,0 +1,392 @@
+package netbug
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// jobTrigger is the trigger recorded for profiles captured by jobs.
+const jobTrigger = "job"
+
+const (
+	// maxJobs is the number of finished jobs remembered.
+	maxJobs = 32
+
+	// maxRunningJobs is the number of jobs that can run at once.
+	maxRunningJobs = 4
+
+	// maxJobSeconds is the longest CPU profile or trace a job can
+	// capture.
+	maxJobSeconds = 600
+)
+
+// jobProfile is one of the profiles a job captures.
+type jobProfile struct {
+	// Profile is the name of the profile, as for WriteProfile, such as
+	// "profile" for a CPU profile, or "heap".
+	Profile string `json:"profile"`
+
+	// Seconds is the length of a CPU profile or trace, with the same
+	// defaults as the profile and trace endpoints.
+	Seconds float64 `json:"seconds,omitempty"`
+
+	// Debug is the debug level to write other profiles with.
+	Debug int `json:"debug,omitempty"`
+
+	// id is the ID to keep the profile as, if it must be known before
+	// it is captured, as for asynchronous captures.
+	id string
+}
+
+// job is a set of profiles captured in the background, so that long
+// captures don't depend on a connection staying up for their whole
+// length.
+type job struct {
+	ID        string       `json:"id"`
+	State     string       `json:"state"` // "running", "done", "failed" or "canceled"
+	By        string       `json:"by,omitempty"`
+	Profiles  []jobProfile `json:"profiles"`
+	Started   time.Time    `json:"started"`
+	Finished  *time.Time   `json:"finished,omitempty"`
+	Artifacts []jobResult  `json:"artifacts"`
+	Errors    []string     `json:"errors,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+// jobResult is a profile captured by a job.
+type jobResult struct {
+	Artifact
+	URL string `json:"url"` // relative to the Debugger's prefix
+}
+
+// jobs are the jobs a Debugger is running, or has recently finished.
+type jobs struct {
+	mu   sync.Mutex
+	byID map[string]*job
+
+	// ctx is canceled by stop, canceling the running jobs, which wg
+	// waits for.
+	ctx  context.Context
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// start adds j and runs it with run in the background, with a context
+// that is canceled when j is canceled or the jobs are stopped. It
+// reports false, without starting j, if maxRunningJobs are running. The
+// oldest finished jobs are forgotten if there are too many.
+func (js *jobs) start(j *job, run func(ctx context.Context)) bool {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if js.byID == nil {
+		js.byID = make(map[string]*job)
+	}
+	running := 0
+	for _, o := range js.byID {
+		if o.cancel != nil {
+			running++
+		}
+	}
+	if running >= maxRunningJobs {
+		return false
+	}
+	if js.ctx == nil {
+		js.ctx, js.stop = context.WithCancel(context.Background())
+	}
+	ctx, cancel := context.WithCancel(js.ctx)
+	j.cancel = cancel
+	js.byID[j.ID] = j
+	js.wg.Add(1)
+	go func() {
+		defer js.wg.Done()
+		defer cancel()
+		run(ctx)
+	}()
+	var finished []*job
+	for _, j := range js.byID {
+		if j.Finished != nil {
+			finished = append(finished, j)
+		}
+	}
+	sort.Slice(finished, func(a, b int) bool { return finished[a].Finished.Before(*finished[b].Finished) })
+	for len(finished) > maxJobs {
+		delete(js.byID, finished[0].ID)
+		finished = finished[1:]
+	}
+	return true
+}
+
+// stopAll cancels the running jobs and waits for them to finish, keeping
+// what they captured before they were canceled.
+func (js *jobs) stopAll() {
+	js.mu.Lock()
+	stop := js.stop
+	js.ctx, js.stop = nil, nil
+	js.mu.Unlock()
+	if stop != nil {
+		stop()
+	}
+	js.wg.Wait()
+}
+
+// get returns a copy of the job with the given ID.
+func (js *jobs) get(id string) (job, bool) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	j, ok := js.byID[id]
+	if !ok {
+		return job{}, false
+	}
+	return j.copy(), true
+}
+
+// list returns copies of the jobs, newest first.
+func (js *jobs) list() []job {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	list := make([]job, 0, len(js.byID))
+	for _, j := range js.byID {
+		list = append(list, j.copy())
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].Started.After(list[b].Started) })
+	return list
+}
+
+// update calls f with the job with the given ID, with js locked.
+func (js *jobs) update(id string, f func(j *job)) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if j, ok := js.byID[id]; ok {
+		f(j)
+	}
+}
+
+// copy returns a copy of j that doesn't share its slices.
+func (j *job) copy() job {
+	c := *j
+	c.Artifacts = append([]jobResult{}, j.Artifacts...)
+	c.Errors = append([]string(nil), j.Errors...)
+	return c
+}
+
+// serveJobs lists d's jobs on GET, and starts one on POST, with a JSON
+// body listing the profiles to capture:
+//
+//	{"profiles": [{"profile": "profile", "seconds": 30}, {"profile": "heap"}, {"profile": "goroutine", "debug": 2}]}
+//
+// The profiles are captured one after another, in the background, and
+// kept in d's store. The response is 202 Accepted, with the job's ID and
+// the URL to follow its progress at, jobs/<id>, which lists the URLs to
+// download each profile from once it has been captured. Jobs whose
+// profiles could take the process too close to its memory limit are
+// refused, as with checkHeadroom, unless started with force=1. A CPU
+// profile or trace can be at most maxJobSeconds long, and at most
+// maxRunningJobs jobs can run at once. Stop cancels the jobs that are
+// running.
+func (d *Debugger) serveJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "HEAD":
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(struct {
+			SchemaVersion int   `json:"schema_version"`
+			Jobs          []job `json:"jobs"`
+		}{schemaVersion, d.jobs.list()}); err != nil {
+			d.logf(slog.LevelWarn, "netbug: %v", err)
+		}
+		return
+	case "POST":
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var spec struct {
+		Profiles []jobProfile `json:"profiles"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&spec); err != nil {
+		http.Error(w, "invalid job: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(spec.Profiles) == 0 {
+		http.Error(w, "invalid job: no profiles", http.StatusBadRequest)
+		return
+	}
+	var cost uint64
+	for _, p := range spec.Profiles {
+		if profileOf(p.Profile) != p.Profile || p.Profile == "cmdline" || p.Profile == "symbol" {
+			http.Error(w, fmt.Sprintf("invalid job: unknown profile %q", p.Profile), http.StatusBadRequest)
+			return
+		}
+		if !d.exposes(p.Profile) {
+			http.Error(w, fmt.Sprintf("profile %q isn't exposed", p.Profile), http.StatusForbidden)
+			return
+		}
+		if p.Seconds > maxJobSeconds {
+			http.Error(w, fmt.Sprintf("invalid job: %s can be at most %d seconds", p.Profile, maxJobSeconds), http.StatusBadRequest)
+			return
+		}
+		cost += captureCost(p.Profile, p.Debug)
+	}
+	if !checkHeadroom(w, r, cost) {
+		return
+	}
+
+	j := &job{
+		ID:        newArtifactID(),
+		State:     "running",
+		Profiles:  spec.Profiles,
+		Started:   time.Now(),
+		Artifacts: []jobResult{},
+	}
+	if p, ok := PrincipalFrom(r.Context()); ok {
+		j.By = p.String()
+	}
+	if !d.jobs.start(j, func(ctx context.Context) { d.runJob(ctx, j.ID, j.By, jobTrigger, spec.Profiles) }) {
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, fmt.Sprintf("%d jobs are already running", maxRunningJobs), http.StatusTooManyRequests)
+		return
+	}
+
+	loc := "jobs/" + j.ID
+	if tok := d.linkToken(r); tok != "" {
+		loc += "?token=" + url.QueryEscape(tok)
+	}
+	w.Header().Set("Location", loc)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(struct {
+		ID  string `json:"id"`
+		URL string `json:"url"` // relative to the Debugger's prefix
+	}{j.ID, "jobs/" + j.ID}); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
+
+// runJob captures the profiles for the job with the given ID, started by
+// by, for trigger, until ctx is canceled. A CPU profile or trace that is
+// canceled is kept, cut short.
+func (d *Debugger) runJob(ctx context.Context, id, by, trigger string, profiles []jobProfile) {
+	failed := false
+	for _, p := range profiles {
+		if ctx.Err() != nil {
+			break
+		}
+		dur := time.Duration(p.Seconds * float64(time.Second))
+		if dur <= 0 {
+			dur = 30 * time.Second
+			if p.Profile == "trace" {
+				dur = time.Second
+			}
+		}
+		var buf bytes.Buffer
+		start := time.Now()
+		done := func() {}
+		if p.Profile == "profile" || p.Profile == "trace" {
+			done = d.running.start(runningCapture{Profile: p.Profile, Via: trigger, By: by, Started: start, Ends: start.Add(dur)})
+		}
+		err := writeProfile(ctx, &buf, p.Profile, dur, p.Debug)
+		done()
+		if err != nil && ctx.Err() != nil && buf.Len() > 0 {
+			dur, err = time.Since(start), nil
+		}
+		var a Artifact
+		if err == nil {
+			aid := p.id
+			if aid == "" {
+				aid = newArtifactID()
+			}
+			a, err = d.keep(aid, p.Profile, dur, p.Debug, trigger, buf.Bytes())
+		}
+		d.jobs.update(id, func(j *job) {
+			if err != nil {
+				failed = true
+				j.Errors = append(j.Errors, fmt.Sprintf("%s: %v", p.Profile, err))
+				return
+			}
+			j.Artifacts = append(j.Artifacts, jobResult{a, "history/" + a.ID})
+		})
+	}
+	d.jobs.update(id, func(j *job) {
+		now := time.Now()
+		j.Finished = &now
+		j.cancel = nil
+		switch {
+		case ctx.Err() != nil:
+			j.State = "canceled"
+		case failed:
+			j.State = "failed"
+		default:
+			j.State = "done"
+		}
+	})
+}
+
+// cancel cancels the job with the given ID, reporting whether there is
+// such a job and whether it was running.
+func (js *jobs) cancel(id string) (found, running bool) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	j, ok := js.byID[id]
+	if !ok {
+		return false, false
+	}
+	if j.cancel == nil {
+		return true, false
+	}
+	j.cancel()
+	return true, true
+}
+
+// serveJob serves the status of the job with the given ID as JSON, or on
+// DELETE, cancels it, cutting short the CPU profile or trace it is
+// capturing, such as a 300-second trace started by accident.
+func (d *Debugger) serveJob(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case "GET", "HEAD":
+	case "DELETE":
+		found, running := d.jobs.cancel(id)
+		switch {
+		case !found:
+			http.NotFound(w, r)
+		case !running:
+			http.Error(w, "job has already finished", http.StatusConflict)
+		default:
+			who := r.RemoteAddr
+			if p, ok := PrincipalFrom(r.Context()); ok {
+				who = p.String() + " from " + who
+			}
+			d.logf(slog.LevelInfo, "netbug: job %s canceled by %s", id, who)
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	default:
+		w.Header().Set("Allow", "GET, HEAD, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	j, ok := d.jobs.get(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if j.State == "running" {
+		w.Header().Set("Retry-After", "1")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		SchemaVersion int `json:"schema_version"`
+		job
+	}{schemaVersion, j}); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
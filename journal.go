@@ -0,0 +1,186 @@
+package netbug
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// journalSize is the number of requests kept in a Debugger's journal.
+const journalSize = 1000
+
+// journalEntry is a request made to a Debugger.
+type journalEntry struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`            // relative to the Debugger's prefix
+	Query  string    `json:"query,omitempty"` // without the token
+	Remote string    `json:"remote"`
+	By     string    `json:"by,omitempty"` // the principal that made the request
+}
+
+// journal records the requests made to a Debugger, so that the
+// diagnostics gathered during an incident can be listed in a postmortem
+// and replayed elsewhere.
+type journal struct {
+	mu      sync.Mutex
+	entries []journalEntry // oldest first
+}
+
+// record adds r, whose path relative to the Debugger's prefix is name, to
+// the journal.
+func (j *journal) record(r *http.Request, name string) {
+	// Include parameters POSTed in the body, such as new profiling rates.
+	r.ParseForm()
+	q := make(url.Values, len(r.Form))
+	for k, v := range r.Form {
+		if k != "token" {
+			q[k] = v
+		}
+	}
+	e := journalEntry{
+		Time:   time.Now(),
+		Method: r.Method,
+		Path:   name,
+		Query:  q.Encode(),
+		Remote: r.RemoteAddr,
+	}
+	if p, ok := PrincipalFrom(r.Context()); ok {
+		e.By = p.String()
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.entries) == journalSize {
+		copy(j.entries, j.entries[1:])
+		j.entries = j.entries[:journalSize-1]
+	}
+	j.entries = append(j.entries, e)
+}
+
+// between returns the entries recorded between since and until, either of
+// which may be zero.
+func (j *journal) between(since, until time.Time) []journalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var es []journalEntry
+	for _, e := range j.entries {
+		if (since.IsZero() || !e.Time.Before(since)) && (until.IsZero() || e.Time.Before(until)) {
+			es = append(es, e)
+		}
+	}
+	return es
+}
+
+// serveJournal serves the requests made to d, optionally limited to those
+// made between the since and until parameters (RFC 3339 times). With
+// format=sh it serves a shell script that repeats them with curl, saving
+// each response to a file, so that the diagnostics gathered during an
+// incident can be replayed against another instance, such as one in
+// staging. With format=json it serves the requests as JSON.
+func (d *Debugger) serveJournal(w http.ResponseWriter, r *http.Request) {
+	var since, until time.Time
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &since}, {"until", &until}} {
+		if v := r.FormValue(p.name); v != "" {
+			var err error
+			if *p.t, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s: %v", p.name, err), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	es := d.journal.between(since, until)
+
+	switch responseFormat(w, r) {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(es); err != nil {
+			d.logf(slog.LevelWarn, "netbug: %v", err)
+		}
+		return
+	case "sh":
+		w.Header().Set("Content-Type", "text/x-shellscript; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="netbug-session.sh"`)
+		writeJournalScript(w, es)
+		return
+	}
+
+	info := struct {
+		Entries      []journalEntry
+		Since, Until string
+		Token        string
+	}{Entries: es, Since: r.FormValue("since"), Until: r.FormValue("until"), Token: d.linkToken(r)}
+	if err := journalTmpl.Execute(w, info); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
+
+// shellEscaper escapes the characters that are special inside double
+// quotes in a shell script.
+var shellEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+
+// writeJournalScript writes es to w as a shell script of curl commands.
+func writeJournalScript(w io.Writer, es []journalEntry) {
+	fmt.Fprintf(w, `#!/bin/sh
+# Requests made to netbug on %s.
+#
+# Replay them with:
+#
+#	NETBUG_URL=http://host:port/prefix/ NETBUG_TOKEN=token sh netbug-session.sh
+#
+# Each response is saved to a file in the current directory.
+set -e
+: "${NETBUG_URL:?set NETBUG_URL to the address of the netbug handler, including its prefix}"
+token() { if [ -n "$NETBUG_TOKEN" ]; then printf '%%s' "token=$NETBUG_TOKEN"; fi; }
+`, hostname())
+	for i, e := range es {
+		path := (&url.URL{Path: e.Path}).EscapedPath()
+		out := fmt.Sprintf("%03d-%s.out", i+1, strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' {
+				return r
+			}
+			return '_'
+		}, e.Path))
+		q := e.Query
+		if q != "" {
+			q += "&"
+		}
+		fmt.Fprintf(w, "\n# %s from %s", e.Time.Format(time.RFC3339), e.Remote)
+		if e.By != "" {
+			fmt.Fprintf(w, " by %s", e.By)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "curl -sS -X %s -o %s \"${NETBUG_URL}%s?%s$(token)\"\n",
+			e.Method, out, shellEscaper.Replace(path), shellEscaper.Replace(q))
+	}
+}
+
+var journalTmpl = template.Must(template.New("journal").Funcs(pageFuncs).Parse(`<html>
+  <head>
+    <title>Request Journal</title>
+    {{pageHead}}
+  </head>
+  <body>
+    requests made to netbug, oldest first
+    (<a href="journal?format=sh{{if .Since}}&since={{.Since}}{{end}}{{if .Until}}&until={{.Until}}{{end}}{{if .Token}}&token={{.Token}}{{end}}">replay script</a>,
+    <a href="journal?format=json{{if .Since}}&since={{.Since}}{{end}}{{if .Until}}&until={{.Until}}{{end}}{{if .Token}}&token={{.Token}}{{end}}">JSON</a>):<br>
+    <table>
+      <tr><th align=left>time<th align=left>method<th align=left>request<th align=left>from<th align=left>by
+    {{range .Entries}}
+      <tr><td>{{.Time.Format "2006-01-02 15:04:05 MST"}}<td>{{.Method}}<td>{{.Path}}{{if .Query}}?{{.Query}}{{end}}<td>{{.Remote}}<td>{{.By}}
+    {{else}}
+      <tr><td colspan=5>No requests have been made yet.
+    {{end}}
+    </table>
+  </body>
+</html>`))
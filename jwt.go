@@ -0,0 +1,318 @@
+package netbug
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // for crypto.SHA256
+	_ "crypto/sha512" // for crypto.SHA384 and crypto.SHA512
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwtLeeway is how far the clocks of a JWT's issuer and this process can
+// disagree.
+const jwtLeeway = time.Minute
+
+// jwtHashes is the hash used by each supported JWT signing algorithm.
+var jwtHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// verifyJWT verifies the signature of the JWT raw with the key returned by
+// key, for the key ID and algorithm in the JWT's header, and that it has
+// neither expired nor is yet to become valid, returning its claims.
+func verifyJWT(raw string, key func(kid, alg string) (crypto.PublicKey, error)) (map[string]interface{}, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed JWT")
+	}
+	enc := base64.RawURLEncoding
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	b, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed JWT header: %v", err)
+	}
+	if err := json.Unmarshal(b, &header); err != nil {
+		return nil, fmt.Errorf("malformed JWT header: %v", err)
+	}
+	hash, ok := jwtHashes[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported JWT algorithm %q", header.Alg)
+	}
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed JWT signature: %v", err)
+	}
+	pub, err := key(header.Kid, header.Alg)
+	if err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	digest := h.Sum(nil)
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if header.Alg[0] != 'R' {
+			return nil, fmt.Errorf("%s JWT signed with an RSA key", header.Alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, hash, digest, sig); err != nil {
+			return nil, errors.New("invalid JWT signature")
+		}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if header.Alg[0] != 'E' || len(sig) != 2*size {
+			return nil, errors.New("invalid JWT signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return nil, errors.New("invalid JWT signature")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported JWT key %T", pub)
+	}
+
+	b, err = enc.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed JWT claims: %v", err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return nil, fmt.Errorf("malformed JWT claims: %v", err)
+	}
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); !ok || now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return nil, errors.New("JWT has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("JWT isn't valid yet")
+	}
+	return claims, nil
+}
+
+// hasAudience reports whether the aud claim in claims, which may be a
+// string or a list of them, includes aud.
+func hasAudience(claims map[string]interface{}, aud string) bool {
+	switch v := claims["aud"].(type) {
+	case string:
+		return v == aud
+	case []interface{}:
+		for _, a := range v {
+			if a == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// claimStrings returns the claim called name, which may be a string or a
+// list of them.
+func claimStrings(claims map[string]interface{}, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		var ss []string
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				ss = append(ss, s)
+			}
+		}
+		return ss
+	}
+	return nil
+}
+
+// jwksRefresh is how often a key set may be fetched again to find a key
+// it doesn't have, such as after the issuer rotates its keys.
+const jwksRefresh = time.Minute
+
+// jwks is a JSON Web Key Set fetched from a URL, such as an OpenID
+// provider's jwks_uri.
+type jwks struct {
+	url string
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// key returns the key with ID kid, fetching the key set if it doesn't have
+// it. The key set is fetched without s locked, so that requests with keys
+// it has aren't held up by a slow issuer, and by one caller at a time.
+func (s *jwks) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	k, ok := s.keys[kid]
+	prev := s.fetched
+	refresh := !ok && time.Since(prev) >= jwksRefresh
+	if refresh {
+		s.fetched = time.Now()
+	}
+	s.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+	if !refresh {
+		return nil, fmt.Errorf("unknown JWT key %q", kid)
+	}
+
+	keys, err := fetchJWKS(ctx, s.url)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		// Let the next request try again.
+		s.fetched = prev
+		return nil, err
+	}
+	s.keys, s.fetched = keys, time.Now()
+	if k, ok := s.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown JWT key %q", kid)
+}
+
+// fetchJWKS fetches the RSA and EC keys in the JSON Web Key Set at url,
+// keyed by ID.
+func fetchJWKS(ctx context.Context, url string) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := getJSON(ctx, url, &set); err != nil {
+		return nil, err
+	}
+	enc := base64.RawURLEncoding
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := enc.DecodeString(k.N)
+			e, err2 := enc.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+			curve, ok := curves[k.Crv]
+			x, err1 := enc.DecodeString(k.X)
+			y, err2 := enc.DecodeString(k.Y)
+			if !ok || err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	return keys, nil
+}
+
+// getJSON decodes the JSON at url into v.
+func getJSON(ctx context.Context, url string, v interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// WithJWT accepts JWTs as bearer tokens in the Authorization header, or
+// in the token parameter, such as those a service mesh attaches to
+// requests between services. A JWT is accepted if it is signed with the
+// key returned by key, for the key ID and algorithm in its header, hasn't
+// expired, is for audience, which its aud claim must be or include, and
+// has each of requiredClaims; a required claim that is a list need only
+// include the value. Requests are attributed to a Principal of kind "jwt"
+// whose ID is the JWT's subject, and may access every endpoint.
+//
+// The audience must be given, as otherwise a JWT the issuer signed for
+// any other service would be accepted too; if it is empty, every JWT is
+// refused.
+//
+// RS256, RS384, RS512, ES256, ES384 and ES512 signatures are supported.
+// To fetch keys from an issuer's JSON Web Key Set, use JWKS.
+func WithJWT(key func(kid, alg string) (crypto.PublicKey, error), audience string, requiredClaims map[string]string) Option {
+	return func(d *Debugger) {
+		d.jwt = &jwtAuth{key, audience, requiredClaims}
+	}
+}
+
+// JWKS returns a function for WithJWT that returns keys from the JSON Web
+// Key Set at url, such as an issuer's jwks_uri. The key set is fetched
+// when a key is first needed, and again, at most once a minute, when a
+// JWT is signed with a key it doesn't have, such as after the issuer
+// rotates its keys.
+func JWKS(url string) func(kid, alg string) (crypto.PublicKey, error) {
+	s := &jwks{url: url}
+	return func(kid, alg string) (crypto.PublicKey, error) {
+		return s.key(context.Background(), kid)
+	}
+}
+
+// jwtAuth is the JWT authentication configured by WithJWT.
+type jwtAuth struct {
+	key            func(kid, alg string) (crypto.PublicKey, error)
+	audience       string
+	requiredClaims map[string]string
+}
+
+// authenticate returns the principal for a request made with the JWT
+// tok, reporting whether tok is valid.
+func (a *jwtAuth) authenticate(tok string) (Principal, bool) {
+	if a.audience == "" {
+		return Principal{}, false
+	}
+	claims, err := verifyJWT(tok, a.key)
+	if err != nil || !hasAudience(claims, a.audience) {
+		return Principal{}, false
+	}
+	for name, want := range a.requiredClaims {
+		found := false
+		for _, v := range claimStrings(claims, name) {
+			found = found || v == want
+		}
+		if !found {
+			return Principal{}, false
+		}
+	}
+	sub, _ := claims["sub"].(string)
+	return Principal{Kind: "jwt", ID: sub}, true
+}
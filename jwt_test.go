@@ -0,0 +1,158 @@
+package netbug
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// signJWT returns a JWT with claims, signed with ES256 by key, whose
+// header names the key kid.
+func signJWT(t *testing.T, key *ecdsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	enc := base64.RawURLEncoding
+	h, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": kid, "typ": "JWT"})
+	c, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := enc.EncodeToString(h) + "." + enc.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signed + "." + enc.EncodeToString(sig)
+}
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// TestJWT checks that JWTs are accepted only if they are signed with a
+// known key, current, for the audience and have the required claims.
+func TestJWT(t *testing.T) {
+	key, other := newECKey(t), newECKey(t)
+	keys := func(kid, alg string) (crypto.PublicKey, error) {
+		if kid != "k1" {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+		return &key.PublicKey, nil
+	}
+
+	now := time.Now().Unix()
+	claims := func(edit func(c map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{"sub": "svc", "aud": "netbug", "groups": []string{"dev", "sre"}, "exp": now + 300}
+		if edit != nil {
+			edit(c)
+		}
+		return c
+	}
+	valid := signJWT(t, key, "k1", claims(nil))
+	for _, tc := range []struct {
+		name string
+		tok  string
+		want int
+	}{
+		{"valid", valid, http.StatusOK},
+		{"audience in a list", signJWT(t, key, "k1", claims(func(c map[string]interface{}) { c["aud"] = []string{"other", "netbug"} })), http.StatusOK},
+		{"other audience", signJWT(t, key, "k1", claims(func(c map[string]interface{}) { c["aud"] = "other" })), http.StatusUnauthorized},
+		{"no audience", signJWT(t, key, "k1", claims(func(c map[string]interface{}) { delete(c, "aud") })), http.StatusUnauthorized},
+		{"expired", signJWT(t, key, "k1", claims(func(c map[string]interface{}) { c["exp"] = now - 300 })), http.StatusUnauthorized},
+		{"no expiry", signJWT(t, key, "k1", claims(func(c map[string]interface{}) { delete(c, "exp") })), http.StatusUnauthorized},
+		{"not yet valid", signJWT(t, key, "k1", claims(func(c map[string]interface{}) { c["nbf"] = now + 300 })), http.StatusUnauthorized},
+		{"missing required claim", signJWT(t, key, "k1", claims(func(c map[string]interface{}) { c["groups"] = "dev" })), http.StatusUnauthorized},
+		{"other key", signJWT(t, other, "k1", claims(nil)), http.StatusUnauthorized},
+		{"unknown key", signJWT(t, key, "k2", claims(nil)), http.StatusUnauthorized},
+		{"tampered", valid[:len(valid)-4] + "AAAA", http.StatusUnauthorized},
+		{"malformed", "a.b.c", http.StatusUnauthorized},
+	} {
+		// A new Debugger for each, so that no case is locked out.
+		d := New(WithJWT(keys, "netbug", map[string]string{"groups": "sre"}))
+		if w := serveToken(d, "GET", "heap", tc.tok); w.Code != tc.want {
+			t.Errorf("%s: %d %s, want %d", tc.name, w.Code, w.Body, tc.want)
+		}
+	}
+
+	// Without an audience, no JWT is accepted.
+	d := New(WithJWT(keys, "", nil))
+	if w := serveToken(d, "GET", "heap", valid); w.Code != http.StatusUnauthorized {
+		t.Errorf("valid JWT without an audience configured: %d, want 401", w.Code)
+	}
+}
+
+// TestJWKSFetchUnlocked checks that keys already fetched are returned
+// while the key set is being fetched again for a key it doesn't have.
+func TestJWKSFetchUnlocked(t *testing.T) {
+	key := newECKey(t)
+	enc := base64.RawURLEncoding
+	set, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{{
+		"kty": "EC", "kid": "k1", "crv": "P-256",
+		"x": enc.EncodeToString(key.PublicKey.X.FillBytes(make([]byte, 32))),
+		"y": enc.EncodeToString(key.PublicKey.Y.FillBytes(make([]byte, 32))),
+	}}})
+	fetching, release := make(chan struct{}, 1), make(chan struct{})
+	block := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if block {
+			fetching <- struct{}{}
+			<-release
+		}
+		w.Write(set)
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx := context.Background()
+	s := &jwks{url: srv.URL}
+	if _, err := s.key(ctx, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	s.fetched = time.Now().Add(-jwksRefresh)
+	s.mu.Unlock()
+	block = true
+
+	unknown := make(chan error, 1)
+	go func() {
+		_, err := s.key(ctx, "k2")
+		unknown <- err
+	}()
+	<-fetching
+	got := make(chan error, 1)
+	go func() {
+		_, err := s.key(ctx, "k1")
+		got <- err
+	}()
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Errorf("known key while fetching: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("known key held up by a fetch of the key set")
+	}
+	release <- struct{}{}
+	if err := <-unknown; err == nil || errors.Is(err, context.Canceled) {
+		t.Errorf("unknown key: %v, want an error", err)
+	}
+}
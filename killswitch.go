@@ -0,0 +1,128 @@
+package netbug
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SetEnabled switches d's endpoints on or off, without redeploying, such
+// as during an attack or a compliance review. While disabled, d responds
+// to every request with 404 Not Found, as though it weren't registered at
+// all, except for requests to control/enabled, which can switch it back
+// on. Those are authenticated as usual, so if d has no token, anyone who
+// can reach it can re-enable it. A Debugger is enabled when created,
+// unless configured WithArming.
+//
+// If d is configured WithArming, SetEnabled(true) enables d for its
+// longest window, as EnableFor does.
+func (d *Debugger) SetEnabled(enabled bool) {
+	if enabled && d.armWindow > 0 {
+		d.EnableFor(d.armWindow)
+		return
+	}
+	d.enabledUntil.Store(0)
+	d.disabled.Store(!enabled)
+}
+
+// EnableFor enables d's endpoints for dur, after which they are disabled
+// again, as with SetEnabled(false). If d is configured WithArming, dur is
+// limited to its longest window.
+func (d *Debugger) EnableFor(dur time.Duration) {
+	if d.armWindow > 0 && dur > d.armWindow {
+		dur = d.armWindow
+	}
+	d.enabledUntil.Store(time.Now().Add(dur).UnixNano())
+	d.disabled.Store(false)
+}
+
+// WithArming disables the Debugger's endpoints until they are armed, by a
+// POST to control/enabled or by calling EnableFor, for at most window at
+// a time, after which they disarm themselves. Until then, and once
+// disarmed, every endpoint but control/enabled responds with 404 Not
+// Found. Use WithArming with WithToken, as otherwise anyone who can reach
+// the Debugger can arm it.
+func WithArming(window time.Duration) Option {
+	return func(d *Debugger) {
+		d.armWindow = window
+		d.disabled.Store(true)
+	}
+}
+
+// Enabled reports whether d's endpoints are enabled.
+func (d *Debugger) Enabled() bool {
+	_, ok := d.enabledState()
+	return ok
+}
+
+// enabledState reports whether d's endpoints are enabled, and if so until
+// when, or the zero time if until they are disabled.
+func (d *Debugger) enabledState() (until time.Time, enabled bool) {
+	if d.disabled.Load() {
+		return time.Time{}, false
+	}
+	n := d.enabledUntil.Load()
+	if n == 0 {
+		return time.Time{}, true
+	}
+	until = time.Unix(0, n)
+	return until, time.Now().Before(until)
+}
+
+// controlEnabled handles a POST switching d's endpoints on or off, with
+// the enabled parameter set to true or false. With seconds, they are only
+// enabled for that many seconds, which is required if d is configured
+// WithArming and is then limited to its window.
+func (d *Debugger) controlEnabled(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "use POST to enable or disable netbug", http.StatusMethodNotAllowed)
+		return
+	}
+	enabled, err := strconv.ParseBool(r.FormValue("enabled"))
+	if err != nil {
+		http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+		return
+	}
+	var dur time.Duration
+	if s := r.FormValue("seconds"); s != "" {
+		secs, err := strconv.Atoi(s)
+		if err != nil || secs <= 0 {
+			http.Error(w, fmt.Sprintf("invalid seconds: %q", s), http.StatusBadRequest)
+			return
+		}
+		dur = time.Duration(secs) * time.Second
+	}
+	if enabled && d.armWindow > 0 {
+		if dur == 0 {
+			http.Error(w, "netbug can only be armed for a limited time; set seconds", http.StatusBadRequest)
+			return
+		}
+		if dur > d.armWindow {
+			http.Error(w, fmt.Sprintf("netbug can be armed for at most %v", d.armWindow), http.StatusBadRequest)
+			return
+		}
+	}
+	who := r.RemoteAddr
+	if p, ok := PrincipalFrom(r.Context()); ok {
+		who = p.String() + " from " + who
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	switch {
+	case !enabled:
+		d.SetEnabled(false)
+		d.logf(slog.LevelInfo, "netbug: disabled by %s", who)
+		fmt.Fprintln(w, "netbug disabled")
+	case dur > 0:
+		d.EnableFor(dur)
+		d.logf(slog.LevelInfo, "netbug: enabled for %v by %s", dur, who)
+		fmt.Fprintf(w, "netbug enabled until %s\n", time.Now().Add(dur).Format(time.RFC3339))
+	default:
+		d.SetEnabled(true)
+		d.logf(slog.LevelInfo, "netbug: enabled by %s", who)
+		fmt.Fprintln(w, "netbug enabled")
+	}
+}
@@ -0,0 +1,152 @@
+package netbug
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"runtime/pprof"
+)
+
+// stackGrowth is a goroutine stack whose count grew since the baseline.
+type stackGrowth struct {
+	Baseline int          `json:"baseline"`
+	Current  int          `json:"current"`
+	Growth   int          `json:"growth"`
+	Frames   []stackFrame `json:"frames"`
+}
+
+// leaks compares the current goroutines with a baseline goroutine profile
+// from the store, reporting the stacks that grew. Without a baseline
+// parameter it lists the profiles that can be used as a baseline, and a
+// POST captures a new one.
+func (d *Debugger) leaks(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		a, err := d.capture(r.Context(), "goroutine", 0, 1, "baseline")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		loc := "leaks?baseline=" + a.ID
+		if tok := d.linkToken(r); tok != "" {
+			loc += "&token=" + url.QueryEscape(tok)
+		}
+		redirect(w, loc, http.StatusSeeOther)
+		return
+	}
+
+	info := struct {
+		Token      string
+		Baseline   *Artifact
+		Baselines  []Artifact
+		Grown      []stackGrowth
+		Goroutines int
+	}{Token: d.linkToken(r)}
+
+	as, err := d.store.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, a := range as {
+		if a.Profile == "goroutine" && a.Debug == 1 {
+			info.Baselines = append(info.Baselines, a)
+		}
+	}
+
+	if id := r.FormValue("baseline"); id != "" {
+		base, grown, err := d.leakReport(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		info.Baseline, info.Grown = &base, grown
+		for _, g := range grown {
+			info.Goroutines += g.Growth
+		}
+	}
+
+	if responseFormat(w, r) == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(struct {
+			Baseline *Artifact     `json:"baseline"`
+			Grown    []stackGrowth `json:"grown"`
+		}{info.Baseline, info.Grown}); err != nil {
+			d.logf(slog.LevelWarn, "netbug: %v", err)
+		}
+		return
+	}
+	if err := leaksTmpl.Execute(w, info); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
+
+// leakReport returns the stacks that have more goroutines now than in the
+// baseline artifact with the given ID, most grown first.
+func (d *Debugger) leakReport(ctx context.Context, id string) (Artifact, []stackGrowth, error) {
+	a, rc, err := d.store.Open(id)
+	if err != nil {
+		return Artifact{}, nil, err
+	}
+	defer rc.Close()
+	if a.Profile != "goroutine" || a.Debug != 1 {
+		return Artifact{}, nil, fmt.Errorf("netbug: artifact %s is not a goroutine profile written with debug=1", id)
+	}
+	base, err := parseGoroutineProfile(rc)
+	if err != nil {
+		return Artifact{}, nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		return Artifact{}, nil, err
+	}
+	cur, err := parseGoroutineProfile(&buf)
+	if err != nil {
+		return Artifact{}, nil, err
+	}
+
+	var grown []stackGrowth
+	for _, sd := range diffStacks(base, cur) {
+		if sd.B > sd.A {
+			grown = append(grown, stackGrowth{Baseline: sd.A, Current: sd.B, Growth: sd.B - sd.A, Frames: sd.Frames})
+		}
+	}
+	return a, grown, nil
+}
+
+var leaksTmpl = template.Must(template.New("leaks").Funcs(pageFuncs).Parse(`<html>
+  <head>
+    <title>Goroutine Leaks</title>
+    {{pageHead}}
+  </head>
+  <body>
+    <form method="post" action="leaks{{if .Token}}?token={{.Token}}{{end}}">
+      <input type="submit" value="capture a new baseline">
+    </form>
+    baselines:<br>
+    <table>
+    {{range .Baselines}}
+      <tr><td><a href="leaks?baseline={{.ID}}{{if $.Token}}&token={{$.Token}}{{end}}">{{.Created.Format "2006-01-02 15:04:05 MST"}}</a><td>{{.Trigger}}
+    {{else}}
+      <tr><td>No goroutine profiles have been captured yet.
+    {{end}}
+    </table>
+    {{with .Baseline}}
+    <br>
+    {{$.Goroutines}} more goroutines in {{len $.Grown}} stacks than at {{.Created.Format "2006-01-02 15:04:05 MST"}}:<br>
+    <table>
+      <tr><th align=right>baseline<th align=right>now<th align=right>growth<th align=left>stack
+    {{range $.Grown}}
+      <tr><td align=right valign=top>{{.Baseline}}<td align=right valign=top>{{.Current}}<td align=right valign=top>+{{.Growth}}<td><details><summary>{{range $i, $f := .Frames}}{{if eq $i 0}}{{$f.Func}}{{end}}{{end}}</summary><pre>{{range .Frames}}{{.Func}}
+	{{.File}}:{{.Line}}
+{{end}}</pre></details>
+    {{end}}
+    </table>
+    {{end}}
+  </body>
+</html>`))
@@ -0,0 +1,96 @@
+package netbug
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+// licenseModule is a row in the license report.
+type licenseModule struct {
+	Path     string `json:"path"`
+	Version  string `json:"version"`
+	Replaces string `json:"replaces,omitempty"`
+	Sum      string `json:"sum,omitempty"`
+}
+
+// licenseGroup is a set of modules that are likely to share a license,
+// because they're published by the same owner.
+type licenseGroup struct {
+	Name    string          `json:"name"`
+	Modules []licenseModule `json:"modules"`
+}
+
+// licenses serves the modules compiled into the binary, grouped by owner,
+// for license review. The report is CSV unless JSON is asked for, with
+// the format parameter or the Accept header.
+func (d *Debugger) licenses(w http.ResponseWriter, r *http.Request) {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		http.Error(w, "Build information not available.", http.StatusNotFound)
+		return
+	}
+
+	byGroup := make(map[string][]licenseModule)
+	for _, dep := range bi.Deps {
+		m := resolve(dep)
+		lm := licenseModule{Path: m.Path, Version: m.Version, Sum: m.Sum}
+		if m != dep {
+			lm.Replaces = dep.Path + "@" + dep.Version
+		}
+		g := licenseGroupName(m.Path)
+		byGroup[g] = append(byGroup[g], lm)
+	}
+	groups := make([]licenseGroup, 0, len(byGroup))
+	for name, mods := range byGroup {
+		sort.Slice(mods, func(i, j int) bool { return mods[i].Path < mods[j].Path })
+		groups = append(groups, licenseGroup{Name: name, Modules: mods})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+
+	if responseFormat(w, r) == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		report := struct {
+			Main   string         `json:"main"`
+			Groups []licenseGroup `json:"groups"`
+		}{bi.Main.Path, groups}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			d.logf(slog.LevelWarn, "netbug: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="licenses.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"group", "module", "version", "replaces", "sum"})
+	for _, g := range groups {
+		for _, m := range g.Modules {
+			cw.Write([]string{g.Name, m.Path, m.Version, m.Replaces, m.Sum})
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
+
+// licenseGroupName returns the owner of the module at path: the account
+// for modules on well known code hosts, such as "github.com/e-dard", and
+// otherwise the host (or vanity import prefix) the module is served from.
+func licenseGroupName(path string) string {
+	parts := strings.Split(path, "/")
+	switch parts[0] {
+	case "github.com", "gitlab.com", "bitbucket.org", "golang.org", "go.googlesource.com":
+		if len(parts) >= 2 {
+			return parts[0] + "/" + parts[1]
+		}
+	}
+	return parts[0]
+}
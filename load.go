@@ -0,0 +1,46 @@
+package netbug
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+)
+
+// WithLoadGenerator configures a function that generates load on the
+// process, for profiling instances that aren't receiving any traffic, such
+// as those in a staging environment. It is only called when a capture is
+// requested with the with_load=1 parameter, and runs for as long as the
+// capture does, with its context cancelled once the capture is done.
+//
+// gen should exercise the code paths worth profiling, for example by
+// calling the application's own handlers, until ctx is done.
+func WithLoadGenerator(gen func(ctx context.Context) error) Option {
+	return func(d *Debugger) {
+		d.loadGenerator = gen
+	}
+}
+
+// withLoad calls h, running d's load generator alongside it if r asks for
+// load with the with_load parameter.
+func (d *Debugger) withLoad(w http.ResponseWriter, r *http.Request, h http.HandlerFunc) {
+	if r.FormValue("with_load") != "1" {
+		h(w, r)
+		return
+	}
+	if d.loadGenerator == nil {
+		http.Error(w, "no load generator is configured", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := d.loadGenerator(ctx); err != nil && ctx.Err() == nil {
+			d.logf(slog.LevelError, "netbug: load generator: %v", err)
+		}
+	}()
+	h(w, r)
+	cancel()
+	<-done
+}
@@ -0,0 +1,171 @@
+package netbug
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// freeAuthFailures is how many times in a row a client can fail to
+	// authenticate before it is locked out.
+	freeAuthFailures = 5
+
+	// maxLockout is the longest a client is locked out for.
+	maxLockout = 15 * time.Minute
+
+	// authFailureMemory is how long a client's failures are remembered
+	// after its last one.
+	authFailureMemory = time.Hour
+
+	// maxAuthClients is how many clients' failures are remembered at
+	// once. Beyond it, the client whose last failure was longest ago is
+	// forgotten.
+	maxAuthClients = 10000
+)
+
+// An AuthFailure describes a request to a Debugger that failed to
+// authenticate.
+type AuthFailure struct {
+	// RemoteAddr is the IP address the request came from.
+	RemoteAddr string
+
+	// Path is the endpoint requested, relative to the Debugger's prefix.
+	Path string
+
+	// Failures is how many requests from RemoteAddr, or for IPv6 its
+	// /64, have failed to authenticate with a wrong token in a row,
+	// including this one, or 0 if this one had no token.
+	Failures int
+
+	// LockedUntil is when RemoteAddr may try again, or the zero time if
+	// it isn't locked out.
+	LockedUntil time.Time
+}
+
+// WithAuthFailureHook calls hook whenever a request fails to authenticate,
+// so that repeated failures can be alerted on. hook is called
+// synchronously, so it shouldn't block.
+func WithAuthFailureHook(hook func(AuthFailure)) Option {
+	return func(d *Debugger) {
+		d.onAuthFailure = hook
+	}
+}
+
+// authFailures tracks the clients that have failed to authenticate, so
+// that tokens can't be brute-forced. After freeAuthFailures failures in a
+// row, a client is locked out for a second, doubling with each further
+// failure up to maxLockout. Clients are keyed by clientKey, so that one
+// with an IPv6 network can't dodge the lockout by changing address.
+type authFailures struct {
+	mu      sync.Mutex
+	clients map[string]*authClient
+}
+
+// authClient is the failures of a client, identified by IP address.
+type authClient struct {
+	failures int
+	last     time.Time
+	until    time.Time // locked out until
+}
+
+// clientIP returns the IP address r came from. Headers set by proxies,
+// such as X-Forwarded-For, are ignored, as clients can set them
+// themselves.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientKey returns the key the failures of the client at ip are kept
+// under: ip itself for IPv4, or its /64 for IPv6, since a host is
+// typically given a whole /64 to pick addresses from.
+func clientKey(ip string) string {
+	a := net.ParseIP(ip)
+	if a == nil || a.To4() != nil {
+		return ip
+	}
+	return a.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// lockedOut returns when the client at ip may try again, reporting
+// whether it is locked out now.
+func (a *authFailures) lockedOut(ip string) (time.Time, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c, ok := a.clients[clientKey(ip)]
+	if !ok || !time.Now().Before(c.until) {
+		return time.Time{}, false
+	}
+	return c.until, true
+}
+
+// fail records a failure by the client at ip, returning how many it has
+// made in a row and when it may try again.
+func (a *authFailures) fail(ip string) (failures int, until time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	if a.clients == nil {
+		a.clients = make(map[string]*authClient)
+	}
+	for k, c := range a.clients {
+		if now.Sub(c.last) > authFailureMemory {
+			delete(a.clients, k)
+		}
+	}
+	key := clientKey(ip)
+	c, ok := a.clients[key]
+	if !ok {
+		if len(a.clients) >= maxAuthClients {
+			a.forgetOldest()
+		}
+		c = &authClient{}
+		a.clients[key] = c
+	}
+	c.failures++
+	c.last = now
+	if n := c.failures - freeAuthFailures; n > 0 {
+		lockout := maxLockout
+		if n < 20 && time.Second<<(n-1) < maxLockout {
+			lockout = time.Second << (n - 1)
+		}
+		c.until = now.Add(lockout)
+	}
+	return c.failures, c.until
+}
+
+// forgetOldest forgets the client whose last failure was longest ago.
+func (a *authFailures) forgetOldest() {
+	var (
+		oldest string
+		last   time.Time
+	)
+	for k, c := range a.clients {
+		if oldest == "" || c.last.Before(last) {
+			oldest, last = k, c.last
+		}
+	}
+	delete(a.clients, oldest)
+}
+
+// succeed forgets the failures of the client at ip.
+func (a *authFailures) succeed(ip string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.clients, clientKey(ip))
+}
+
+// refuseLockedOut responds to a request from a client that is locked out
+// until until.
+func refuseLockedOut(w http.ResponseWriter, until time.Time) {
+	secs := int(math.Ceil(time.Until(until).Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	http.Error(w, "too many failed attempts; try again later", http.StatusTooManyRequests)
+}
@@ -0,0 +1,77 @@
+package netbug
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// serveFrom serves a GET for target, relative to d's root, from the client
+// at remote, with the bearer token tok, if any.
+func serveFrom(d http.Handler, remote, target, tok string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/"+target, nil)
+	r.RemoteAddr = remote
+	if tok != "" {
+		r.Header.Set("Authorization", "Bearer "+tok)
+	}
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, r)
+	return w
+}
+
+// TestLockout checks that a client is locked out after freeAuthFailures
+// wrong tokens in a row, and only then: requests without a token don't
+// count, a right token starts the count again, and other clients aren't
+// affected. An IPv6 client is locked out with the rest of its /64.
+func TestLockout(t *testing.T) {
+	var failures []AuthFailure
+	d := New(WithToken("secret"), WithAuthFailureHook(func(f AuthFailure) { failures = append(failures, f) }))
+	const client = "192.0.2.7:1234"
+
+	for i := 0; i < 2*freeAuthFailures; i++ {
+		if w := serveFrom(d, client, "cmdline", ""); w.Code != http.StatusUnauthorized {
+			t.Fatalf("without a token: %d, want 401", w.Code)
+		}
+	}
+	for i := 0; i < freeAuthFailures; i++ {
+		if w := serveFrom(d, client, "cmdline", "wrong"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong token %d: %d, want 401", i+1, w.Code)
+		}
+	}
+	if w := serveFrom(d, client, "cmdline", "secret"); w.Code != http.StatusOK {
+		t.Fatalf("right token after %d wrong: %d, want 200", freeAuthFailures, w.Code)
+	}
+	for i := 0; i <= freeAuthFailures; i++ {
+		if w := serveFrom(d, client, "cmdline", "wrong"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong token %d after the right one: %d, want 401", i+1, w.Code)
+		}
+	}
+	w := serveFrom(d, client, "cmdline", "secret")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("right token when locked out: %d, Retry-After %q, want 429 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := serveFrom(d, "192.0.2.8:1234", "cmdline", "secret"); w.Code != http.StatusOK {
+		t.Errorf("another client: %d, want 200", w.Code)
+	}
+
+	if n := len(failures); n != 4*freeAuthFailures+1 {
+		t.Fatalf("hook called %d times, want %d", n, 4*freeAuthFailures+1)
+	}
+	if f := failures[0]; f.Failures != 0 || !f.LockedUntil.IsZero() {
+		t.Errorf("hook without a token: %+v, want no failures counted", f)
+	}
+	if f := failures[len(failures)-1]; f.RemoteAddr != "192.0.2.7" || f.Path != "cmdline" || f.Failures != freeAuthFailures+1 || f.LockedUntil.IsZero() {
+		t.Errorf("hook when locking out: %+v", f)
+	}
+
+	d = New(WithToken("secret"))
+	for i := 0; i <= freeAuthFailures; i++ {
+		serveFrom(d, "[2001:db8:1:2::1]:1234", "cmdline", "wrong")
+	}
+	if w := serveFrom(d, "[2001:db8:1:2::ffff]:1234", "cmdline", "secret"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("same /64: %d, want 429", w.Code)
+	}
+	if w := serveFrom(d, "[2001:db8:1:3::1]:1234", "cmdline", "secret"); w.Code != http.StatusOK {
+		t.Errorf("another /64: %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,153 @@
+package netbug
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"runtime/pprof"
+	"time"
+)
+
+// WithLogger logs what the Debugger does to l, so that failures inside
+// the profiling handlers aren't invisible:
+//
+//   - profile captures, such as CPU profiles, traces and delta profiles,
+//     at info level when they start and finish, with how long they took;
+//   - requests that fail with a server error, such as a profile that
+//     couldn't be written, at error level, with the error;
+//   - requests that fail to authenticate, or are refused because the
+//     client is locked out, at warn level, and those that authenticate at
+//     debug level.
+//
+// Unlike WithAuditLog, which logs every request, WithLogger logs only
+// these events, along with everything else netbug would otherwise log
+// with the standard logger, such as failures of captures made in the
+// background, what watchdogs find and changes to the runtime's settings.
+func WithLogger(l *slog.Logger) Option {
+	return func(d *Debugger) {
+		d.logger = l
+	}
+}
+
+// logf logs a message, formatted as with fmt.Sprintf, at level to the
+// logger set with WithLogger or, without one, to the standard logger.
+func (d *Debugger) logf(level slog.Level, format string, args ...interface{}) {
+	if d.logger == nil {
+		log.Printf(format, args...)
+		return
+	}
+	d.logger.Log(context.Background(), level, fmt.Sprintf(format, args...))
+}
+
+// maxLoggedError is the most of a server error's response logged.
+const maxLoggedError = 512
+
+// isCapture reports whether the request r for name captures a profile
+// over time, rather than taking a snapshot.
+func isCapture(r *http.Request, name string) bool {
+	switch name {
+	case "profile", "trace":
+		return true
+	}
+	return pprof.Lookup(name) != nil && r.URL.Query().Get("seconds") != ""
+}
+
+// logCaptureStart logs the start of the capture of name requested by r,
+// if it is one, once r has been authenticated.
+func (d *Debugger) logCaptureStart(r *http.Request, name string) {
+	if d.logger == nil || !isCapture(r, name) {
+		return
+	}
+	if a, ok := r.Context().Value(auditKey{}).(*auditRecord); ok {
+		a.capture = true
+	}
+	by := ""
+	if p, ok := PrincipalFrom(r.Context()); ok {
+		by = p.String()
+	}
+	d.logger.LogAttrs(r.Context(), slog.LevelInfo, "netbug capture started",
+		requestIDAttr(r),
+		slog.String("profile", name),
+		slog.String("seconds", r.URL.Query().Get("seconds")),
+		slog.String("by", by),
+		slog.String("remote", r.RemoteAddr),
+	)
+}
+
+// logResult logs the result of the request r for name, if it started a
+// capture or failed with a server error.
+func (d *Debugger) logResult(r *http.Request, name string, a *auditRecord, aw *auditWriter, status int, dur time.Duration) {
+	by := ""
+	if a.principal != nil {
+		by = a.principal.String()
+	}
+	if status >= 500 {
+		d.logger.LogAttrs(r.Context(), slog.LevelError, "netbug request failed",
+			requestIDAttr(r),
+			slog.String("by", by),
+			slog.String("path", name),
+			slog.Int("status", status),
+			slog.String("error", string(aw.errBody)),
+		)
+	}
+	if a.capture {
+		d.logger.LogAttrs(r.Context(), slog.LevelInfo, "netbug capture finished",
+			requestIDAttr(r),
+			slog.String("profile", name),
+			slog.String("by", by),
+			slog.Int("status", status),
+			slog.Duration("duration", dur),
+			slog.Int64("bytes", aw.bytes),
+		)
+	}
+}
+
+// logAuth logs the authentication of the request r for name, from the
+// client at ip: whether it authenticated as p, and if not, how many times
+// it has failed to and until when it is locked out.
+func (d *Debugger) logAuth(r *http.Request, name, ip string, p Principal, ok bool, failures int, until time.Time) {
+	if d.logger == nil {
+		return
+	}
+	if ok {
+		d.logger.LogAttrs(r.Context(), slog.LevelDebug, "netbug authenticated",
+			requestIDAttr(r),
+			slog.String("by", p.String()),
+			slog.String("remote", ip),
+			slog.String("path", name),
+		)
+		return
+	}
+	attrs := []slog.Attr{
+		requestIDAttr(r),
+		slog.String("remote", ip),
+		slog.String("path", name),
+		slog.Int("failures", failures),
+	}
+	if !until.IsZero() {
+		attrs = append(attrs, slog.Time("locked_until", until))
+	}
+	d.logger.LogAttrs(r.Context(), slog.LevelWarn, "netbug authentication failed", attrs...)
+}
+
+// logLockedOut logs that the request r for name was refused because the
+// client at ip is locked out until until.
+func (d *Debugger) logLockedOut(r *http.Request, name, ip string, until time.Time) {
+	if d.logger == nil {
+		return
+	}
+	d.logger.LogAttrs(r.Context(), slog.LevelWarn, "netbug request refused while locked out",
+		requestIDAttr(r),
+		slog.String("remote", ip),
+		slog.String("path", name),
+		slog.Time("locked_until", until),
+	)
+}
+
+// requestIDAttr returns the ID of r, for logging.
+func requestIDAttr(r *http.Request) slog.Attr {
+	id, _ := RequestIDFrom(r.Context())
+	return slog.String("request_id", id)
+}
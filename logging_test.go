@@ -0,0 +1,67 @@
+package netbug
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// failingWriter is a ResponseRecorder whose body can't be written, as
+// when the client has gone away.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("client went away")
+}
+
+// TestLoggerReplacesStandardLogger checks that what netbug logs goes to
+// the standard logger without WithLogger, and only to the logger set with
+// it otherwise.
+func TestLoggerReplacesStandardLogger(t *testing.T) {
+	var std bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&std)
+	defer log.SetOutput(prev)
+
+	want := []string{
+		"netbug: disabled by",
+		"netbug: enabled by",
+		"netbug: client went away",
+	}
+	for _, withLogger := range []bool{false, true} {
+		std.Reset()
+		var logged bytes.Buffer
+		var opts []Option
+		if withLogger {
+			opts = append(opts, WithLogger(slog.New(slog.NewTextHandler(&logged, nil))))
+		}
+		d := New(opts...)
+		for _, target := range []string{"control/enabled?enabled=false", "control/enabled?enabled=true"} {
+			if w := serveToken(d, "POST", target, ""); w.Code != 200 {
+				t.Fatalf("POST /%s: %d %s", target, w.Code, w.Body)
+			}
+		}
+		for _, target := range []string{"stats.json", "goroutine?debug=2"} {
+			d.ServeHTTP(failingWriter{httptest.NewRecorder()}, httptest.NewRequest("GET", "/"+target, nil))
+		}
+
+		got, other := std.String(), logged.String()
+		if withLogger {
+			got, other = other, got
+		}
+		for _, s := range want {
+			if !strings.Contains(got, s) {
+				t.Errorf("WithLogger %t: %q not logged in:\n%s", withLogger, s, got)
+			}
+		}
+		if other != "" {
+			t.Errorf("WithLogger %t: logged to the wrong logger:\n%s", withLogger, other)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package netbug
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// WithSigningKey signs the SHA-256 manifest in exports of captured
+// profiles with key, so that profiles attached to an incident ticket can
+// be shown not to have been tampered with. The archive contains the
+// manifest, SHA256SUMS, its signature, SHA256SUMS.sig, and the public
+// key, signing-key.pem, which should be checked against the one kept
+// with the key. With OpenSSL:
+//
+//	openssl pkeyutl -verify -pubin -inkey signing-key.pem -rawin -in SHA256SUMS -sigfile SHA256SUMS.sig
+//	sha256sum -c SHA256SUMS
+func WithSigningKey(key ed25519.PrivateKey) Option {
+	return func(d *Debugger) {
+		d.signingKey = key
+	}
+}
+
+// checksums is a manifest of files' SHA-256 checksums, in the format of
+// sha256sum.
+type checksums struct {
+	b strings.Builder
+}
+
+// add records that the file called name has the given SHA-256 checksum.
+func (c *checksums) add(name string, sum []byte) {
+	fmt.Fprintf(&c.b, "%x  %s\n", sum, name)
+}
+
+// bytes returns the manifest.
+func (c *checksums) bytes() []byte {
+	return []byte(c.b.String())
+}
+
+// signingKeyPEM returns the public half of key, PEM encoded.
+func signingKeyPEM(key ed25519.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// sha256Hex returns the SHA-256 checksum of data, hex encoded, as
+// recorded in Artifact.SHA256.
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// setContentDigest sets w's Content-Digest header (RFC 9530) to the
+// hex-encoded SHA-256 checksum sum, if it is valid.
+func setContentDigest(w http.ResponseWriter, sum string) {
+	b, err := hex.DecodeString(sum)
+	if err != nil || len(b) != sha256.Size {
+		return
+	}
+	w.Header().Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(b)+":")
+}
@@ -0,0 +1,103 @@
+package netbug
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+// WithLabels adds labels to every artifact the Debugger captures, such as
+// the environment or region the service runs in, so that tooling
+// downstream can tell captures from different deployments apart. They are
+// stored alongside each artifact, with its host, service, version and
+// trigger, and embedded as comments in profiles in the protocol buffer
+// format, which go tool pprof -comments shows.
+func WithLabels(labels map[string]string) Option {
+	return func(d *Debugger) {
+		if d.labels == nil {
+			d.labels = make(map[string]string, len(labels))
+		}
+		for k, v := range labels {
+			d.labels[k] = v
+		}
+	}
+}
+
+// annotate records where a was captured, and d's labels, in a.
+func (d *Debugger) annotate(a *Artifact) {
+	a.Host, a.Service, a.Version = hostname(), serviceName, buildVersion()
+	if len(d.labels) > 0 {
+		a.Labels = make(map[string]string, len(d.labels))
+		for k, v := range d.labels {
+			a.Labels[k] = v
+		}
+	}
+}
+
+// comments returns a's metadata as comments to embed in its profile, in
+// the form "netbug.key=value".
+func (a Artifact) comments() []string {
+	cs := []string{
+		"netbug.host=" + a.Host,
+		"netbug.service=" + a.Service,
+		"netbug.trigger=" + a.Trigger,
+	}
+	if a.Version != "" {
+		cs = append(cs, "netbug.version="+a.Version)
+	}
+	keys := make([]string, 0, len(a.Labels))
+	for k := range a.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		cs = append(cs, "netbug.label."+k+"="+a.Labels[k])
+	}
+	return cs
+}
+
+// addProfileComments returns data, a gzipped pprof profile, with comments
+// added to its comments. Rather than decoding the whole profile, the
+// strings already in its string table are counted and the comments are
+// appended to the encoded message, as repeated fields appended to a
+// protocol buffer are added to those already in it.
+func addProfileComments(data []byte, comments []string) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	p, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	var strs uint64
+	err = protoFields(p, func(field, _ uint64, _ []byte) error {
+		if field == profileStringTable {
+			strs++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, c := range comments {
+		p = binary.AppendUvarint(p, profileStringTable<<3|2)
+		p = binary.AppendUvarint(p, uint64(len(c)))
+		p = append(p, c...)
+		p = binary.AppendUvarint(p, profileComment<<3|0)
+		p = binary.AppendUvarint(p, strs+uint64(i))
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(p); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
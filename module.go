@@ -0,0 +1,143 @@
+package netbug
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// A Module extends a Debugger with diagnostics from another package, such
+// as a company-internal subsystem or a vendor integration. A module's
+// routes are served under <prefix><name>/, behind the same authentication
+// as netbug's own, and are recorded in the request journal, listed in the
+// endpoint catalog and linked from the index page. Its background work is
+// started and stopped along with the Debugger's.
+type Module interface {
+	// Name is the path the module's routes are served under. It mustn't
+	// contain a slash, or be the first element of one of netbug's own
+	// paths, such as "fleet" or "control".
+	Name() string
+
+	// Routes returns the module's routes.
+	Routes() []Route
+
+	// Start starts the module's background work, when the Debugger's
+	// Start is called.
+	Start() error
+
+	// Stop stops the module's background work, when the Debugger's Stop
+	// is called, or if starting another module fails.
+	Stop()
+
+	// IndexEntries returns the links to show on the index page.
+	IndexEntries() []IndexEntry
+}
+
+// A Route is one of a Module's endpoints.
+type Route struct {
+	// Path is the route's path relative to <prefix><name>/, such as
+	// "status". Requests for paths under it are also routed to it if it
+	// ends with a slash.
+	Path string
+
+	// Methods are the methods the route accepts, such as "GET", for the
+	// endpoint catalog. The handler is responsible for rejecting others.
+	Methods []string
+
+	// Description describes the route in the endpoint catalog.
+	Description string
+
+	// Handler handles the route's requests. Their paths are relative to
+	// <prefix><name>/, as netbug's links are relative.
+	Handler http.Handler
+}
+
+// An IndexEntry is a link on the index page.
+type IndexEntry struct {
+	// Path is the link's target relative to <prefix><name>/.
+	Path string
+
+	// Title is the link's text.
+	Title string
+}
+
+// WithModules adds modules to the Debugger, in order.
+func WithModules(ms ...Module) Option {
+	return func(d *Debugger) {
+		d.modules = append(d.modules, ms...)
+	}
+}
+
+// startModules starts d's modules in order, stopping those already started
+// if one fails.
+func (d *Debugger) startModules() error {
+	for i, m := range d.modules {
+		if err := m.Start(); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				d.modules[j].Stop()
+			}
+			return fmt.Errorf("netbug: starting module %s: %v", m.Name(), err)
+		}
+	}
+	return nil
+}
+
+// stopModules stops d's modules in reverse order.
+func (d *Debugger) stopModules() {
+	for i := len(d.modules) - 1; i >= 0; i-- {
+		d.modules[i].Stop()
+	}
+}
+
+// serveModule serves the request for name from one of d's modules,
+// reporting whether name is under one of their paths.
+func (d *Debugger) serveModule(w http.ResponseWriter, r *http.Request, name string) bool {
+	first, rest, ok := strings.Cut(name, "/")
+	if !ok {
+		return false
+	}
+	for _, m := range d.modules {
+		if m.Name() != first {
+			continue
+		}
+		for _, rt := range m.Routes() {
+			if rest == rt.Path || strings.HasSuffix(rt.Path, "/") && strings.HasPrefix(rest, rt.Path) {
+				rt.Handler.ServeHTTP(w, withPath(r, rest))
+				return true
+			}
+		}
+		http.NotFound(w, r)
+		return true
+	}
+	return false
+}
+
+// withPath returns a shallow copy of r for path, such as a path relative
+// to a module's routes.
+func withPath(r *http.Request, path string) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = path
+	r2.URL.RawPath = ""
+	return r2
+}
+
+// moduleEntry is a link to a module's page on the index page.
+type moduleEntry struct {
+	Module string
+	IndexEntry
+}
+
+// moduleEntries returns the links to d's modules' pages.
+func (d *Debugger) moduleEntries() []moduleEntry {
+	var es []moduleEntry
+	for _, m := range d.modules {
+		for _, e := range m.IndexEntries() {
+			es = append(es, moduleEntry{m.Name(), e})
+		}
+	}
+	return es
+}
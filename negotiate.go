@@ -0,0 +1,50 @@
+package netbug
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// negotiatedTypes are the formats an informational endpoint can be served
+// in when asked for by the Accept header rather than the format parameter,
+// keyed by media type.
+var negotiatedTypes = map[string]string{
+	"application/json": "json",
+	"text/plain":       "text",
+	"text/html":        "",
+}
+
+// responseFormat returns the format to serve r in: the format parameter if
+// it has one, or else "json" or "text" if its Accept header prefers JSON
+// or plain text to HTML, so that the same URL serves HTML to browsers and
+// JSON to automation. It returns "" for HTML. Vary is set on w, as the
+// response depends on the Accept header.
+func responseFormat(w http.ResponseWriter, r *http.Request) string {
+	if f := r.FormValue("format"); f != "" {
+		return f
+	}
+	w.Header().Add("Vary", "Accept")
+	format, best := "", 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		f, ok := negotiatedTypes[mt]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+		if q > best {
+			format, best = f, q
+		}
+	}
+	return format
+}
@@ -0,0 +1,65 @@
+package netbug
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// noVulns is a vulnSource that finds nothing.
+type noVulns struct{}
+
+func (noVulns) check(context.Context, []vulnModule) ([]vulnFinding, error) { return nil, nil }
+func (noVulns) String() string                                             { return "none" }
+
+// TestNegotiatedFormats checks that the endpoints with a JSON form serve
+// it when asked for it with the Accept header, as with the format
+// parameter, and otherwise don't.
+func TestNegotiatedFormats(t *testing.T) {
+	d := New()
+	d.vulns = &vulnChecker{source: noVulns{}, logf: d.logf}
+	for _, target := range []string{
+		"debug/licenses",
+		"debug/vulns",
+		"fleet/",
+		"fleet/outliers",
+		"fleet/goroutine-diff?a=self&b=self",
+	} {
+		for _, tc := range []struct {
+			query, accept string
+			json          bool
+		}{
+			{"", "", false},
+			{"", "text/html,application/json;q=0.9", false},
+			{"", "application/json", true},
+			{"format=json", "", true},
+		} {
+			u := target
+			if tc.query != "" && strings.Contains(u, "?") {
+				u += "&" + tc.query
+			} else if tc.query != "" {
+				u += "?" + tc.query
+			}
+			r := httptest.NewRequest("GET", "/"+u, nil)
+			if tc.accept != "" {
+				r.Header.Set("Accept", tc.accept)
+			}
+			w := httptest.NewRecorder()
+			d.ServeHTTP(w, r)
+			if w.Code != 200 {
+				t.Errorf("GET /%s, Accept %q: %d %s", u, tc.accept, w.Code, w.Body)
+				continue
+			}
+			ct := w.Header().Get("Content-Type")
+			if got := strings.HasPrefix(ct, "application/json"); got != tc.json {
+				t.Errorf("GET /%s, Accept %q: Content-Type %q, want JSON %t", u, tc.accept, ct, tc.json)
+				continue
+			}
+			if tc.json && !json.Valid(w.Body.Bytes()) {
+				t.Errorf("GET /%s, Accept %q: invalid JSON: %s", u, tc.accept, w.Body)
+			}
+		}
+	}
+}
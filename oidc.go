@@ -0,0 +1,482 @@
+package netbug
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDC configures signing in to a Debugger's pages with an OpenID Connect
+// provider, such as Okta, Google or Keycloak, for environments where
+// users sign in with SSO rather than sharing a token.
+type OIDC struct {
+	// Issuer is the provider's issuer URL, such as
+	// "https://accounts.google.com". Its configuration is discovered from
+	// <Issuer>/.well-known/openid-configuration.
+	Issuer string
+
+	// ClientID and ClientSecret are the Debugger's credentials with the
+	// provider. Both are required.
+	ClientID     string
+	ClientSecret string
+
+	// SessionKey signs the Debugger's session cookies, and must be at
+	// least 32 random bytes. Give every replica behind a load balancer
+	// the same key so that each accepts the others' sessions. If
+	// SessionKey is nil, a random key is generated, and users stay
+	// signed in only to this process, and only until it restarts.
+	SessionKey []byte
+
+	// RedirectURL is the address of the Debugger's oidc/callback
+	// endpoint, such as "https://host/debug/oidc/callback", which must be
+	// registered with the provider.
+	RedirectURL string
+
+	// Scopes are the scopes to request. The default is "openid", "email"
+	// and "profile"; some providers need another, such as "groups", to
+	// include the user's groups.
+	Scopes []string
+
+	// GroupsClaim is the ID token claim listing the user's groups. The
+	// default is "groups".
+	GroupsClaim string
+
+	// Groups maps the groups whose members may sign in to the endpoints
+	// they may access, as patterns as with WithScopedToken. A group with
+	// no patterns may access every endpoint. Users in more than one group
+	// may access the endpoints of each.
+	Groups map[string][]string
+
+	// AllowedUsers and AllowedDomains are the email addresses, and the
+	// domains of the addresses, of users who may access every endpoint,
+	// whatever their groups. Only addresses the provider has verified,
+	// with the email_verified claim, are allowed.
+	//
+	// At least one of Groups, AllowedUsers and AllowedDomains must be
+	// set: no one may sign in otherwise.
+	AllowedUsers   []string
+	AllowedDomains []string
+
+	// SessionTTL is how long a user stays signed in. The default is 8
+	// hours.
+	SessionTTL time.Duration
+}
+
+// WithOIDC lets users sign in to the Debugger's pages with the OpenID
+// Connect provider configured by cfg. Browsers requesting a page without
+// a token or session are redirected to the provider, and come back to
+// oidc/callback with a session cookie. Requests are attributed to a
+// Principal of kind "oidc" whose ID is the user's email address, or
+// subject if the provider doesn't give a verified one. If cfg is
+// misconfigured, no one may sign in, and Start returns an error.
+//
+// Tokens set by WithToken and WithScopedToken are still accepted, in the
+// token parameter or as a bearer token in the Authorization header, for
+// tools such as go tool pprof.
+func WithOIDC(cfg OIDC) Option {
+	return func(d *Debugger) {
+		if cfg.Scopes == nil {
+			cfg.Scopes = []string{"openid", "email", "profile"}
+		}
+		if cfg.GroupsClaim == "" {
+			cfg.GroupsClaim = "groups"
+		}
+		if cfg.SessionTTL == 0 {
+			cfg.SessionTTL = 8 * time.Hour
+		}
+		if cfg.SessionKey == nil {
+			cfg.SessionKey = make([]byte, 32)
+			if _, err := rand.Read(cfg.SessionKey); err != nil {
+				cfg.SessionKey = nil
+			}
+		}
+		d.oidc = &oidcProvider{cfg: cfg, logf: d.logf}
+	}
+}
+
+// minSessionKey is the shortest OIDC.SessionKey allowed.
+const minSessionKey = 32
+
+// validate returns an error if o is misconfigured, in which case no one
+// may sign in.
+func (o *oidcProvider) validate() error {
+	switch {
+	case o.cfg.ClientSecret == "":
+		return errors.New("netbug: OIDC requires a ClientSecret")
+	case len(o.cfg.SessionKey) < minSessionKey:
+		return fmt.Errorf("netbug: OIDC SessionKey must be at least %d bytes", minSessionKey)
+	case o.cfg.Groups == nil && o.cfg.AllowedUsers == nil && o.cfg.AllowedDomains == nil:
+		return errors.New("netbug: OIDC requires Groups, AllowedUsers or AllowedDomains")
+	}
+	return nil
+}
+
+const (
+	// oidcSessionCookie is the name of the cookie holding a signed-in
+	// user's session.
+	oidcSessionCookie = "netbug_session"
+
+	// oidcStateCookie is the name of the cookie holding the state of a
+	// sign-in in progress.
+	oidcStateCookie = "netbug_oidc"
+
+	// oidcSignInTimeout is how long a user has to sign in with the
+	// provider.
+	oidcSignInTimeout = 10 * time.Minute
+)
+
+// oidcProvider is the OpenID Connect provider configured by WithOIDC.
+type oidcProvider struct {
+	cfg  OIDC
+	logf func(level slog.Level, format string, args ...interface{})
+
+	mu        sync.Mutex
+	authURL   string // empty until discovered
+	tokenURL  string
+	keys      *jwks
+	discovery time.Time // of the last attempt
+}
+
+// oidcSession is a signed-in user's session, as stored in their cookie.
+type oidcSession struct {
+	User    string   `json:"user"`
+	All     bool     `json:"all,omitempty"` // may access every endpoint
+	Scopes  []string `json:"scopes,omitempty"`
+	Expires int64    `json:"exp"`
+}
+
+// oidcState is the state of a sign-in in progress, as stored in the
+// user's cookie.
+type oidcState struct {
+	State   string `json:"state"`
+	Nonce   string `json:"nonce"`
+	Return  string `json:"return"` // the page to return to, relative to the Debugger's prefix
+	Expires int64  `json:"exp"`
+}
+
+// discover fetches the provider's configuration, if it hasn't been
+// already.
+func (o *oidcProvider) discover(ctx context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.authURL != "" {
+		return nil
+	}
+	if time.Since(o.discovery) < jwksRefresh {
+		return errors.New("OpenID provider unavailable")
+	}
+	o.discovery = time.Now()
+	var cfg struct {
+		Issuer   string `json:"issuer"`
+		AuthURL  string `json:"authorization_endpoint"`
+		TokenURL string `json:"token_endpoint"`
+		JWKSURL  string `json:"jwks_uri"`
+	}
+	if err := getJSON(ctx, strings.TrimSuffix(o.cfg.Issuer, "/")+"/.well-known/openid-configuration", &cfg); err != nil {
+		return err
+	}
+	if cfg.Issuer != o.cfg.Issuer {
+		return fmt.Errorf("OpenID provider's issuer is %q, not %q", cfg.Issuer, o.cfg.Issuer)
+	}
+	o.authURL, o.tokenURL, o.keys = cfg.AuthURL, cfg.TokenURL, &jwks{url: cfg.JWKSURL}
+	return nil
+}
+
+// seal returns v encoded and signed, for storing in a cookie.
+func (o *oidcProvider) seal(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + o.mac(payload), nil
+}
+
+// open decodes into v the value sealed in s, reporting whether its
+// signature is valid.
+func (o *oidcProvider) open(s string, v interface{}) bool {
+	payload, mac, ok := strings.Cut(s, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(o.mac(payload))) {
+		return false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	return err == nil && json.Unmarshal(b, v) == nil
+}
+
+// mac returns the signature of payload.
+func (o *oidcProvider) mac(payload string) string {
+	m := hmac.New(sha256.New, o.cfg.SessionKey)
+	m.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// session returns the principal for r's session, reporting whether r has
+// a valid session. If scoped, the principal may only access the endpoints
+// matching scopes.
+func (o *oidcProvider) session(r *http.Request) (p Principal, scopes []string, scoped, ok bool) {
+	c, err := r.Cookie(oidcSessionCookie)
+	if err != nil {
+		return Principal{}, nil, false, false
+	}
+	var s oidcSession
+	if !o.open(c.Value, &s) || time.Now().Unix() > s.Expires {
+		return Principal{}, nil, false, false
+	}
+	return Principal{Kind: "oidc", ID: s.User}, s.Scopes, !s.All, true
+}
+
+// wantsSignIn reports whether r, which has no token or session, is from a
+// browser that should be sent to the provider to sign in.
+func wantsSignIn(r *http.Request) bool {
+	return r.Method == "GET" && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// mountPath returns the path d is registered at, such as "/debug/", from
+// r, whose path relative to it is name.
+func mountPath(r *http.Request, name string) string {
+	p := r.URL.Path
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		p = u.Path
+	}
+	if prefix, ok := strings.CutSuffix(p, name); ok && strings.HasSuffix(prefix, "/") {
+		return prefix
+	}
+	return "/"
+}
+
+// randomString returns a random string, for states and nonces.
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// signIn redirects r, for the page at name, to the provider to sign in.
+// secure is whether r was made over TLS, for the cookie it sets.
+func (o *oidcProvider) signIn(w http.ResponseWriter, r *http.Request, name string, secure bool) {
+	if err := o.validate(); err != nil {
+		o.logf(slog.LevelError, "netbug: %v", err)
+		http.Error(w, "sign-in unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if err := o.discover(r.Context()); err != nil {
+		o.logf(slog.LevelError, "netbug: OpenID Connect discovery: %v", err)
+		http.Error(w, "sign-in unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	state, err1 := randomString()
+	nonce, err2 := randomString()
+	if err1 != nil || err2 != nil {
+		http.Error(w, "sign-in unavailable", http.StatusInternalServerError)
+		return
+	}
+	ret := name
+	if r.URL.RawQuery != "" {
+		ret += "?" + r.URL.RawQuery
+	}
+	sealed, err := o.seal(oidcState{state, nonce, ret, time.Now().Add(oidcSignInTimeout).Unix()})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    sealed,
+		Path:     mountPath(r, name),
+		MaxAge:   int(oidcSignInTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {o.cfg.ClientID},
+		"redirect_uri":  {o.cfg.RedirectURL},
+		"scope":         {strings.Join(o.cfg.Scopes, " ")},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	o.mu.Lock()
+	authURL := o.authURL
+	o.mu.Unlock()
+	sep := "?"
+	if strings.Contains(authURL, "?") {
+		sep = "&"
+	}
+	w.Header().Set("Location", authURL+sep+q.Encode())
+	w.WriteHeader(http.StatusFound)
+}
+
+// callback handles a user returning from the provider, exchanging the
+// authorization code for an ID token and, if the user may sign in,
+// setting their session cookie and redirecting them to the page they
+// first asked for. secure is whether r was made over TLS, for the cookie
+// it sets.
+func (o *oidcProvider) callback(w http.ResponseWriter, r *http.Request, secure bool) {
+	if err := o.validate(); err != nil {
+		o.logf(slog.LevelError, "netbug: %v", err)
+		http.Error(w, "sign-in unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	c, err := r.Cookie(oidcStateCookie)
+	var st oidcState
+	if err != nil || !o.open(c.Value, &st) || time.Now().Unix() > st.Expires {
+		http.Error(w, "sign-in expired; try again", http.StatusBadRequest)
+		return
+	}
+	if r.FormValue("state") != st.State {
+		http.Error(w, "sign-in state mismatch; try again", http.StatusBadRequest)
+		return
+	}
+	if e := r.FormValue("error"); e != "" {
+		http.Error(w, "sign-in failed: "+e, http.StatusForbidden)
+		return
+	}
+	claims, err := o.exchange(r.Context(), r.FormValue("code"), st.Nonce)
+	if err != nil {
+		o.logf(slog.LevelWarn, "netbug: OpenID Connect sign-in from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "sign-in failed", http.StatusForbidden)
+		return
+	}
+
+	// An address the provider hasn't verified could be anyone's, so it
+	// neither names the user nor matches AllowedUsers or AllowedDomains.
+	user, _ := claims["email"].(string)
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		user = ""
+	}
+	s := oidcSession{User: user, All: user != "" && o.allowed(user), Expires: time.Now().Add(o.cfg.SessionTTL).Unix()}
+	if s.User == "" {
+		s.User, _ = claims["sub"].(string)
+	}
+	user = s.User
+	member := s.All
+	for _, g := range claimStrings(claims, o.cfg.GroupsClaim) {
+		patterns, ok := o.cfg.Groups[g]
+		if !ok {
+			continue
+		}
+		member = true
+		if len(patterns) == 0 {
+			s.All = true
+		}
+		s.Scopes = append(s.Scopes, patterns...)
+	}
+	if !member {
+		o.logf(slog.LevelWarn, "netbug: OpenID Connect sign-in refused for %s, who isn't an allowed user or in an allowed group", user)
+		http.Error(w, "you aren't allowed to sign in", http.StatusForbidden)
+		return
+	}
+	if s.All {
+		s.Scopes = nil
+	}
+	sealed, err := o.seal(s)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	path := mountPath(r, "oidc/callback")
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: path, MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcSessionCookie,
+		Value:    sealed,
+		Path:     path,
+		MaxAge:   int(o.cfg.SessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	o.logf(slog.LevelInfo, "netbug: %s signed in with OpenID Connect from %s", user, r.RemoteAddr)
+	redirect(w, "../"+st.Return, http.StatusFound)
+}
+
+// allowed reports whether email, a verified address, is one of
+// AllowedUsers or in one of AllowedDomains.
+func (o *oidcProvider) allowed(email string) bool {
+	for _, u := range o.cfg.AllowedUsers {
+		if strings.EqualFold(u, email) {
+			return true
+		}
+	}
+	i := strings.LastIndexByte(email, '@')
+	if i < 0 {
+		return false
+	}
+	for _, dom := range o.cfg.AllowedDomains {
+		if strings.EqualFold(dom, email[i+1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// exchange exchanges the authorization code for an ID token, returning its
+// claims once verified.
+func (o *oidcProvider) exchange(ctx context.Context, code, nonce string) (map[string]interface{}, error) {
+	if err := o.discover(ctx); err != nil {
+		return nil, err
+	}
+	o.mu.Lock()
+	tokenURL, keys := o.tokenURL, o.keys
+	o.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {o.cfg.RedirectURL},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(o.cfg.ClientID), url.QueryEscape(o.cfg.ClientSecret))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("exchanging code: %s", resp.Status)
+	}
+	var tok struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return nil, err
+	}
+
+	claims, err := verifyJWT(tok.IDToken, func(kid, alg string) (crypto.PublicKey, error) {
+		return keys.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if iss, _ := claims["iss"].(string); iss != o.cfg.Issuer {
+		return nil, fmt.Errorf("ID token issued by %q", iss)
+	}
+	if !hasAudience(claims, o.cfg.ClientID) {
+		return nil, errors.New("ID token not issued for this client")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("ID token nonce mismatch")
+	}
+	return claims, nil
+}
@@ -0,0 +1,226 @@
+package netbug
+
+import (
+	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeProvider is an OpenID Connect provider whose token endpoint issues
+// the ID token it is given. ID tokens are signed with key, which is in
+// its key set unless it is replaced.
+type fakeProvider struct {
+	*httptest.Server
+	key *ecdsa.PrivateKey
+
+	mu      sync.Mutex
+	idToken string
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+	key := newECKey(t)
+	p := &fakeProvider{key: key}
+	enc := base64.RawURLEncoding
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "EC", "kid": "k1", "crv": "P-256",
+			"x": enc.EncodeToString(key.PublicKey.X.FillBytes(make([]byte, 32))),
+			"y": enc.EncodeToString(key.PublicKey.Y.FillBytes(make([]byte, 32))),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "netbug" || secret != "client-secret" {
+			http.Error(w, "wrong client credentials", http.StatusUnauthorized)
+			return
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"id_token": p.idToken})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// config returns the configuration of a Debugger signing in with p.
+func (p *fakeProvider) config() OIDC {
+	return OIDC{
+		Issuer:         p.URL,
+		ClientID:       "netbug",
+		ClientSecret:   "client-secret",
+		RedirectURL:    "http://example.com/oidc/callback",
+		Groups:         map[string][]string{"sre": nil, "dev": {"heap"}},
+		AllowedUsers:   []string{"alice@example.com"},
+		AllowedDomains: []string{"corp.example.com"},
+	}
+}
+
+// signIn signs in to d, returning the response to the request for the
+// callback and the cookies it sets. The provider issues an ID token with
+// claims, to which the nonce d asked for and the standard claims are
+// added unless claims sets them.
+func (p *fakeProvider) signIn(t *testing.T, d *Debugger, claims map[string]interface{}) (*httptest.ResponseRecorder, []*http.Cookie) {
+	t.Helper()
+	r := httptest.NewRequest("GET", "/heap", nil)
+	r.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, r)
+	if w.Code != http.StatusFound {
+		t.Fatalf("GET /heap from a browser: %d %s, want 302", w.Code, w.Body)
+	}
+	loc, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := loc.Query()
+
+	c := map[string]interface{}{
+		"iss":   p.URL,
+		"aud":   "netbug",
+		"sub":   "user-1",
+		"nonce": q.Get("nonce"),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}
+	for k, v := range claims {
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+	}
+	p.mu.Lock()
+	p.idToken = signJWT(t, p.key, "k1", c)
+	p.mu.Unlock()
+
+	r = httptest.NewRequest("GET", "/oidc/callback?"+url.Values{"state": {q.Get("state")}, "code": {"code"}}.Encode(), nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	d.ServeHTTP(w, r)
+	return w, w.Result().Cookies()
+}
+
+// serveSession serves a GET for target, relative to d's root, with the
+// session cookie among cookies.
+func serveSession(d http.Handler, target string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/"+target, nil)
+	for _, c := range cookies {
+		if c.Name == oidcSessionCookie {
+			r.AddCookie(c)
+		}
+	}
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, r)
+	return w
+}
+
+// TestOIDC checks that users the configuration allows can sign in, and
+// reach the endpoints their groups allow, and that sign-ins with ID
+// tokens that aren't valid for the Debugger, or by users it doesn't
+// allow, are refused.
+func TestOIDC(t *testing.T) {
+	p := newFakeProvider(t)
+	other := newECKey(t)
+
+	for _, tc := range []struct {
+		name      string
+		claims    map[string]interface{}
+		heap, all bool // whether heap and cmdline may be served
+	}{
+		{"allowed user", map[string]interface{}{"email": "alice@example.com", "email_verified": true}, true, true},
+		{"allowed domain", map[string]interface{}{"email": "bob@corp.example.com", "email_verified": true}, true, true},
+		{"group with every endpoint", map[string]interface{}{"groups": []string{"sre"}}, true, true},
+		{"group with some endpoints", map[string]interface{}{"groups": []string{"other", "dev"}}, true, false},
+	} {
+		d := New(WithOIDC(p.config()))
+		w, cookies := p.signIn(t, d, tc.claims)
+		if w.Code != http.StatusFound || w.Header().Get("Location") != "../heap" {
+			t.Errorf("%s: callback %d %s to %q, want 302 to ../heap", tc.name, w.Code, w.Body, w.Header().Get("Location"))
+			continue
+		}
+		for target, allowed := range map[string]bool{"heap": tc.heap, "cmdline": tc.all} {
+			want := http.StatusForbidden
+			if allowed {
+				want = http.StatusOK
+			}
+			if w := serveSession(d, target, cookies); w.Code != want {
+				t.Errorf("%s: GET /%s: %d, want %d", tc.name, target, w.Code, want)
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		claims map[string]interface{}
+	}{
+		{"unverified address", map[string]interface{}{"email": "alice@example.com", "email_verified": false}},
+		{"not allowed", map[string]interface{}{"email": "mallory@example.org", "email_verified": true, "groups": []string{"other"}}},
+		{"other issuer", map[string]interface{}{"email": "alice@example.com", "email_verified": true, "iss": "https://evil.example"}},
+		{"other client", map[string]interface{}{"email": "alice@example.com", "email_verified": true, "aud": "other"}},
+		{"other nonce", map[string]interface{}{"email": "alice@example.com", "email_verified": true, "nonce": "replayed"}},
+		{"expired", map[string]interface{}{"email": "alice@example.com", "email_verified": true, "exp": time.Now().Add(-time.Minute).Unix()}},
+	} {
+		d := New(WithOIDC(p.config()))
+		w, cookies := p.signIn(t, d, tc.claims)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: callback %d %s, want 403", tc.name, w.Code, w.Body)
+		}
+		if w := serveSession(d, "heap", cookies); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: GET /heap after the callback: %d, want 401", tc.name, w.Code)
+		}
+	}
+
+	// A session sealed with another key, or with a sign-in whose state
+	// doesn't match, is refused.
+	d := New(WithOIDC(p.config()))
+	cfg := p.config()
+	cfg.SessionKey = make([]byte, minSessionKey)
+	_, cookies := p.signIn(t, New(WithOIDC(cfg)), map[string]interface{}{"email": "alice@example.com", "email_verified": true})
+	if w := serveSession(d, "heap", cookies); w.Code != http.StatusUnauthorized {
+		t.Errorf("session signed with another key: %d, want 401", w.Code)
+	}
+	r := httptest.NewRequest("GET", "/oidc/callback?state=forged&code=code", nil)
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("callback without a sign-in: %d, want 400", w.Code)
+	}
+
+	// An ID token signed with a key not in the provider's key set is
+	// refused.
+	signer := p.key
+	p.key = other
+	w, _ = p.signIn(t, d, map[string]interface{}{"email": "alice@example.com", "email_verified": true})
+	p.key = signer
+	if w.Code != http.StatusForbidden {
+		t.Errorf("ID token signed with another key: %d, want 403", w.Code)
+	}
+
+	// Without an allowlist, no one may sign in.
+	cfg = p.config()
+	cfg.Groups, cfg.AllowedUsers, cfg.AllowedDomains = nil, nil, nil
+	r = httptest.NewRequest("GET", "/heap", nil)
+	r.Header.Set("Accept", "text/html")
+	w = httptest.NewRecorder()
+	New(WithOIDC(cfg)).ServeHTTP(w, r)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("sign-in without an allowlist: %d, want 503", w.Code)
+	}
+}
@@ -0,0 +1,120 @@
+package netbug
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// An Option configures a Debugger.
+type Option func(*Debugger)
+
+// WithToken requires all requests to provide token as a URL parameter
+// called token, as a bearer token or as the password for basic
+// authentication, as with AuthHandler.
+//
+// After 5 failed attempts in a row from an IP address, further requests
+// from it are refused with 429 Too Many Requests, for a second and then
+// twice as long with each further failure, up to 15 minutes. Behind a
+// proxy, every client shares the proxy's address, so repeated failures
+// by one lock out the others too.
+func WithToken(token string) Option {
+	return func(d *Debugger) {
+		d.token = token
+	}
+}
+
+// WithScopedToken accepts token, besides the one set by WithToken, but
+// only for the endpoints matching the provided patterns, so that teams and
+// automation can each be given no more access than they need. Patterns
+// are paths relative to the Debugger's prefix, as with path.Match, such as
+// "heap", "goroutine" or "control/*". Scoped tokens can always load the
+// index page, but can't mint capability tokens. Requests for other
+// endpoints are refused with 403 Forbidden, so with no patterns, token
+// can load the index page and nothing else.
+//
+// WithScopedToken can be used more than once, and without WithToken;
+// either way, every request then needs a token.
+func WithScopedToken(token string, patterns ...string) Option {
+	return func(d *Debugger) {
+		if token == "" {
+			return
+		}
+		if d.scopedTokens == nil {
+			d.scopedTokens = make(map[string][]string)
+		}
+		d.scopedTokens[token] = append(d.scopedTokens[token], patterns...)
+	}
+}
+
+// requiresAuth reports whether d requires requests to authenticate, with
+// a token or by signing in.
+func (d *Debugger) requiresAuth() bool {
+	return d.token != "" || len(d.scopedTokens) > 0 || d.oidc != nil || d.jwt != nil
+}
+
+// inScope reports whether the endpoint at name matches one of patterns.
+func inScope(patterns []string, name string) bool {
+	if name == "" {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.TrimPrefix(p, "/"), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// WithPublicEndpoints exempts the endpoints at the provided paths,
+// relative to the Debugger's prefix (e.g. "stats.json"), from
+// authentication, so that load balancers and scrapers can use them
+// without a token while everything else stays locked down. The exemption
+// only applies to GET and HEAD requests, and only to exact paths.
+func WithPublicEndpoints(paths ...string) Option {
+	return func(d *Debugger) {
+		if d.public == nil {
+			d.public = make(map[string]bool)
+		}
+		for _, p := range paths {
+			d.public[strings.TrimPrefix(p, "/")] = true
+		}
+	}
+}
+
+// isPublic reports whether r, for the endpoint at name, is exempt from
+// authentication.
+func (d *Debugger) isPublic(r *http.Request, name string) bool {
+	return d.public[name] && (r.Method == "GET" || r.Method == "HEAD")
+}
+
+// ReadOnly disables every endpoint that changes the process's state or
+// adds overhead to it, such as the CPU profile, execution traces, running
+// the GC and the profiling controls, while keeping instantaneous snapshots,
+// such as the heap and goroutine profiles and stats.json, available. The
+// disabled endpoints respond with 403 Forbidden, and are left off the
+// index page and the endpoint catalog.
+//
+// Enabling and disabling the Debugger itself, with a POST to
+// control/enabled, is still allowed, as it changes nothing about the
+// process, so that a Debugger can be both read-only and armed with
+// WithArming.
+func ReadOnly() Option {
+	return func(d *Debugger) {
+		d.readOnly = true
+	}
+}
+
+// readOnlyAllows reports whether a read-only Debugger serves requests with
+// method for the endpoint at name. Only GET and HEAD requests are served,
+// besides symbol lookups, which go tool pprof makes with POST, and
+// enabling or disabling the Debugger.
+func readOnlyAllows(method, name string) bool {
+	switch name {
+	case "profile", "trace":
+		return false
+	case "symbol", "control/enabled":
+		return true
+	}
+	return method == "GET" || method == "HEAD"
+}
@@ -0,0 +1,12 @@
+// Package otelnetbug adds netbug's requests to OpenTelemetry traces, so
+// that a profile capture shows up alongside the application's own spans,
+// such as to see whether a 30-second CPU profile coincided with a latency
+// spike:
+//
+//	d := netbug.New(netbug.WithToken("open sesame"), otelnetbug.WithTracing(nil))
+//
+// It uses go.opentelemetry.io/otel, which netbug doesn't otherwise depend
+// on, so it is a module of its own:
+//
+//	$ go get github.com/e-dard/netbug/otelnetbug
+package otelnetbug
@@ -0,0 +1,57 @@
+package otelnetbug
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/e-dard/netbug"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the tracer the spans are created with.
+const instrumentationName = "github.com/e-dard/netbug/otelnetbug"
+
+// WithTracing creates a span for each request made to the Debugger, with
+// tp, or if tp is nil, the global TracerProvider. Spans are named after
+// the endpoint, such as "netbug profile", and record its seconds
+// parameter, for profiles and traces that run for a while, and the
+// response's status code and size. Trace context in the request's headers
+// is used as the span's parent, as is a span already in the request's
+// context, such as one started by middleware.
+func WithTracing(tp trace.TracerProvider) netbug.Option {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	tracer := tp.Tracer(instrumentationName)
+	return netbug.WithRequestObserver(func(r *http.Request, endpoint string) (context.Context, func(int, int64)) {
+		ctx := r.Context()
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
+		}
+		attrs := []attribute.KeyValue{
+			attribute.String("netbug.endpoint", endpoint),
+			attribute.String("http.request.method", r.Method),
+		}
+		if secs, err := strconv.Atoi(r.URL.Query().Get("seconds")); err == nil {
+			attrs = append(attrs, attribute.Int("netbug.seconds", secs))
+		}
+		ctx, span := tracer.Start(ctx, "netbug "+endpoint,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attrs...))
+		return ctx, func(status int, bytes int64) {
+			span.SetAttributes(
+				attribute.Int("http.response.status_code", status),
+				attribute.Int64("http.response.body.size", bytes),
+			)
+			if status >= 500 {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+			span.End()
+		}
+	})
+}
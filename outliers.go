@@ -0,0 +1,230 @@
+package netbug
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OutlierDetection configures periodic comparison of the runtime state of
+// the local instance and its peers, flagging instances that stand out
+// from the rest of the fleet. This helps decide where to start capturing
+// profiles when one of many replicas misbehaves.
+type OutlierDetection struct {
+	// Interval is how often instances are compared. It defaults to one
+	// minute.
+	Interval time.Duration
+
+	// Factor is how many times the fleet's median value a metric must
+	// reach for an instance to be flagged. It defaults to 3.
+	Factor float64
+}
+
+// WithOutlierDetection compares the instances in the fleet while the
+// Debugger is started, showing the result at <prefix>fleet/outliers.
+// It has no effect unless peers are configured.
+func WithOutlierDetection(o OutlierDetection) Option {
+	if o.Interval <= 0 {
+		o.Interval = time.Minute
+	}
+	if o.Factor <= 0 {
+		o.Factor = 3
+	}
+	return func(d *Debugger) {
+		d.outlierDetection = &o
+	}
+}
+
+// outlierMetrics are the stats compared between instances.
+var outlierMetrics = []struct {
+	name  string
+	value func(instanceStats) float64
+}{
+	{"goroutines", func(s instanceStats) float64 { return float64(s.Goroutines) }},
+	{"heap in use", func(s instanceStats) float64 { return float64(s.HeapInUse) }},
+	{"RSS", func(s instanceStats) float64 { return float64(s.RSS) }},
+}
+
+// fleetInstance is the state of one instance in the fleet.
+type fleetInstance struct {
+	Name     string             `json:"name"`
+	Stats    *instanceStats     `json:"stats,omitempty"`
+	Error    string             `json:"error,omitempty"`
+	Outliers map[string]float64 `json:"outliers,omitempty"` // metric name to multiple of the median
+}
+
+// fleetSnapshot is the state of every instance in the fleet at a point
+// in time.
+type fleetSnapshot struct {
+	Taken     time.Time       `json:"taken"`
+	Instances []fleetInstance `json:"instances"`
+}
+
+// fleetState holds the latest snapshot taken by outlier detection.
+type fleetState struct {
+	mu   sync.Mutex
+	last *fleetSnapshot
+}
+
+// snapshotFleet fetches the stats of the local instance and all of its
+// peers.
+func (d *Debugger) snapshotFleet(ctx context.Context) (*fleetSnapshot, error) {
+	peers, err := d.peers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &fleetSnapshot{Taken: time.Now(), Instances: make([]fleetInstance, len(peers)+1)}
+	self := d.currentStats()
+	snap.Instances[0] = fleetInstance{Name: selfPeer, Stats: &self}
+
+	var wg sync.WaitGroup
+	for i, p := range peers {
+		i, p := i, p
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			inst := fleetInstance{Name: p.Name}
+			b, err := d.fetchPeer(ctx, p, "stats.json", nil)
+			if err == nil {
+				var s instanceStats
+				if err = json.Unmarshal(b, &s); err == nil {
+					inst.Stats = &s
+				}
+			}
+			if err != nil {
+				inst.Error = err.Error()
+			}
+			snap.Instances[i+1] = inst
+		}()
+	}
+	wg.Wait()
+	return snap, nil
+}
+
+// flagOutliers records, for each instance in snap, the metrics whose
+// value is at least factor times the median across the fleet. At least
+// three instances are needed for the median to be meaningful.
+func flagOutliers(snap *fleetSnapshot, factor float64) {
+	for _, m := range outlierMetrics {
+		var vals []float64
+		for _, inst := range snap.Instances {
+			if inst.Stats != nil {
+				vals = append(vals, m.value(*inst.Stats))
+			}
+		}
+		if len(vals) < 3 {
+			return
+		}
+		sort.Float64s(vals)
+		median := vals[len(vals)/2]
+		if len(vals)%2 == 0 {
+			median = (vals[len(vals)/2-1] + vals[len(vals)/2]) / 2
+		}
+		if median <= 0 {
+			continue
+		}
+
+		for i := range snap.Instances {
+			inst := &snap.Instances[i]
+			if inst.Stats == nil {
+				continue
+			}
+			if ratio := m.value(*inst.Stats) / median; ratio >= factor {
+				if inst.Outliers == nil {
+					inst.Outliers = make(map[string]float64)
+				}
+				inst.Outliers[m.name] = ratio
+			}
+		}
+	}
+}
+
+// runOutlierDetection compares the instances in the fleet every interval
+// until ctx is done.
+func (d *Debugger) runOutlierDetection(ctx context.Context, o *OutlierDetection) {
+	for {
+		snap, err := d.snapshotFleet(ctx)
+		if err != nil {
+			d.logf(slog.LevelError, "netbug: outlier detection: %v", err)
+		} else {
+			flagOutliers(snap, o.Factor)
+			for _, inst := range snap.Instances {
+				for metric, ratio := range inst.Outliers {
+					d.logf(slog.LevelWarn, "netbug: outlier detection: %s has %.1fx the median %s", inst.Name, ratio, metric)
+				}
+			}
+			d.fleet.mu.Lock()
+			d.fleet.last = snap
+			d.fleet.mu.Unlock()
+		}
+
+		if sleep(ctx, o.Interval) != nil {
+			return
+		}
+	}
+}
+
+// outliers serves the instances in the fleet, flagging outliers. If
+// outlier detection isn't running, the fleet is compared on demand.
+func (d *Debugger) outliers(w http.ResponseWriter, r *http.Request) {
+	d.fleet.mu.Lock()
+	snap := d.fleet.last
+	d.fleet.mu.Unlock()
+
+	factor := 3.0
+	if d.outlierDetection != nil {
+		factor = d.outlierDetection.Factor
+	}
+	if snap == nil {
+		var err error
+		if snap, err = d.snapshotFleet(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		flagOutliers(snap, factor)
+	}
+
+	if responseFormat(w, r) == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(snap); err != nil {
+			d.logf(slog.LevelWarn, "netbug: %v", err)
+		}
+		return
+	}
+	info := struct {
+		*fleetSnapshot
+		Factor float64
+	}{snap, factor}
+	if err := outliersTmpl.Execute(w, info); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
+
+var outliersTmpl = template.Must(template.New("outliers").Funcs(pageFuncs).Parse(`<html>
+  <head>
+    <title>Fleet Outliers</title>
+    {{pageHead}}
+  </head>
+  <body>
+    instances compared at {{.Taken.Format "2006-01-02 15:04:05 MST"}}; values at least {{.Factor}}x the median are flagged.<br>
+    <br>
+    <table>
+      <tr><th align=left>instance<th align=right>goroutines<th align=right>heap in use<th align=right>RSS<th align=left>outliers
+    {{range .Instances}}
+      <tr><td>{{.Name}}
+      {{if .Stats}}
+        <td align=right>{{.Stats.Goroutines}}<td align=right>{{.Stats.HeapInUse}}<td align=right>{{.Stats.RSS}}
+        <td>{{range $metric, $ratio := .Outliers}}<b>{{$metric}} {{printf "%.1f" $ratio}}x</b> {{end}}
+      {{else}}
+        <td colspan=4>{{.Error}}
+      {{end}}
+    {{end}}
+    </table>
+  </body>
+</html>`))
@@ -0,0 +1,30 @@
+package netbug
+
+import (
+	"embed"
+	"html/template"
+)
+
+// static is the stylesheet shared by netbug's pages, embedded so that
+// they work in networks without access to a CDN.
+//
+//go:embed static/netbug.css
+var static embed.FS
+
+// pageHead is the markup added to the head of each of netbug's pages: a
+// viewport for phones, and the shared stylesheet, which follows the
+// browser's light or dark theme. The stylesheet is inlined, as the
+// default Content-Security-Policy doesn't allow pages to load anything,
+// and so that it doesn't need authenticating.
+var pageHead = func() template.HTML {
+	css, err := static.ReadFile("static/netbug.css")
+	if err != nil {
+		panic(err)
+	}
+	return template.HTML(`<meta name="viewport" content="width=device-width, initial-scale=1">` + "\n    <style>\n" + string(css) + "    </style>")
+}()
+
+// pageFuncs are the functions available to every page's template.
+var pageFuncs = template.FuncMap{
+	"pageHead": func() template.HTML { return pageHead },
+}
@@ -0,0 +1,72 @@
+package netbug
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RegisterPage adds one of the application's own debug pages, such as its
+// connection pools, cache stats or feature flags, served at
+// <prefix>pages/<name> behind the same authentication as netbug's own
+// pages. It is listed, with description, in the index page's pages
+// section and in the endpoint catalog. Requests for paths under
+// <prefix>pages/<name>/ are also routed to h, and their paths are
+// relative to <prefix>pages/, as netbug's links are relative.
+//
+// Registering a page with the same name as another replaces it. Pages can
+// be registered while the Debugger is serving requests.
+func (d *Debugger) RegisterPage(name, description string, h http.Handler) {
+	d.pages.mu.Lock()
+	defer d.pages.mu.Unlock()
+	if d.pages.byName == nil {
+		d.pages.byName = make(map[string]page)
+	}
+	d.pages.byName[name] = page{name, description, h}
+}
+
+// page is an application's debug page, registered with RegisterPage.
+type page struct {
+	Name        string
+	Description string
+	handler     http.Handler
+}
+
+// pages are the debug pages registered with a Debugger.
+type pages struct {
+	mu     sync.RWMutex
+	byName map[string]page
+}
+
+// lookup returns the page serving path, relative to <prefix>pages/.
+func (ps *pages) lookup(path string) (page, bool) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	name, _, _ := strings.Cut(path, "/")
+	p, ok := ps.byName[name]
+	return p, ok
+}
+
+// list returns the pages, sorted by name.
+func (ps *pages) list() []page {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	list := make([]page, 0, len(ps.byName))
+	for _, p := range ps.byName {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// servePage serves the request for path, relative to <prefix>pages/, from
+// one of d's registered pages.
+func (d *Debugger) servePage(w http.ResponseWriter, r *http.Request, path string) {
+	p, ok := d.pages.lookup(path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	p.handler.ServeHTTP(w, withPath(r, path))
+}
@@ -0,0 +1,57 @@
+package netbug
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// An ErrorReporter is told about panics in the Debugger's handlers, such
+// as to send them to Sentry. It is called with the request that panicked,
+// the ID given to it in the response and the logs, the value it panicked
+// with and the stack of the goroutine that panicked.
+type ErrorReporter func(r *http.Request, id string, recovered interface{}, stack []byte)
+
+// WithErrorReporter calls report with every panic in the Debugger's
+// handlers, as well as logging it.
+func WithErrorReporter(report ErrorReporter) Option {
+	return func(d *Debugger) {
+		d.reportError = report
+	}
+}
+
+// recoverPanic recovers from a panic in the handler serving r, so that a
+// bug in a debug page doesn't kill the connection without a word. The
+// panic is logged with its stack, to d's logger if it has one, and passed
+// to d's error reporter, and the client is sent a 500 response with an ID
+// to find it in the logs with. It must be deferred.
+func (d *Debugger) recoverPanic(w http.ResponseWriter, r *http.Request) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if v == http.ErrAbortHandler {
+		panic(v) // the handler meant to abort the response
+	}
+	stack := debug.Stack()
+	id, ok := RequestIDFrom(r.Context())
+	if !ok {
+		id = requestID()
+	}
+	if d.logger != nil {
+		d.logger.LogAttrs(r.Context(), slog.LevelError, "netbug handler panicked",
+			slog.String("request_id", id),
+			slog.String("path", r.URL.Path),
+			slog.String("panic", fmt.Sprint(v)),
+			slog.String("stack", string(stack)),
+		)
+	} else {
+		log.Printf("netbug: request %s for %s panicked: %v\n%s", id, r.URL.Path, v, stack)
+	}
+	if d.reportError != nil {
+		d.reportError(r, id, v, stack)
+	}
+	http.Error(w, "internal error; request ID "+id, http.StatusInternalServerError)
+}
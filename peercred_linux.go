@@ -0,0 +1,34 @@
+package netbug
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// peerCredentialsSupported reports whether peerCredentials is supported.
+const peerCredentialsSupported = true
+
+// peerCredentials returns the user and group IDs of the process at the
+// other end of the Unix domain socket connection c.
+func peerCredentials(c net.Conn) (uid, gid uint32, err error) {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return 0, 0, fmt.Errorf("netbug: %T isn't a Unix domain socket connection", c)
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	var cred *syscall.Ucred
+	cerr := raw.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if cerr != nil {
+		return 0, 0, cerr
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return cred.Uid, cred.Gid, nil
+}
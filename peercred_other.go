@@ -0,0 +1,17 @@
+//go:build !linux
+
+package netbug
+
+import (
+	"errors"
+	"net"
+)
+
+// peerCredentialsSupported reports whether peerCredentials is supported.
+const peerCredentialsSupported = false
+
+// peerCredentials returns the user and group IDs of the process at the
+// other end of the Unix domain socket connection c.
+func peerCredentials(c net.Conn) (uid, gid uint32, err error) {
+	return 0, 0, errors.New("netbug: peer credentials are not available on this platform")
+}
@@ -0,0 +1,181 @@
+package netbug
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// peerTimeout bounds requests netbug makes to peers on its own behalf,
+// rather than when proxying.
+const peerTimeout = 30 * time.Second
+
+// A Peer is another instance of the service, running its own netbug
+// handler, that the Debugger can proxy requests to and compare itself
+// with.
+type Peer struct {
+	// Name identifies the peer in routes and pages, e.g. its hostname.
+	// It must not contain a slash.
+	Name string
+
+	// URL is the address of the peer's netbug handler, including the
+	// prefix it's registered on, e.g. "http://10.0.0.2:8080/myroute/".
+	URL string
+
+	// Token is the token the peer's handler requires. If empty, the
+	// Debugger's own token is used for the requests it makes to the peer
+	// itself, such as to compare profiles, and proxied requests carry
+	// the token the caller made them with, so that the peer applies the
+	// caller's scope.
+	Token string
+}
+
+// WithPeers configures a fixed set of peers. Requests to
+// <prefix>peers/<name>/ are proxied to the peer called name. The caller's
+// scope and ReadOnly apply to the endpoint on the peer, so a token scoped
+// to "peers/*/heap" needs "heap" too.
+func WithPeers(peers ...Peer) Option {
+	return WithPeerDiscovery(func(context.Context) ([]Peer, error) {
+		return peers, nil
+	})
+}
+
+// WithPeerDiscovery configures a function that is called to discover the
+// current set of peers whenever they are needed, for services whose
+// instances come and go.
+func WithPeerDiscovery(discover func(ctx context.Context) ([]Peer, error)) Option {
+	return func(d *Debugger) {
+		d.discover = discover
+	}
+}
+
+// peers returns the currently known peers.
+func (d *Debugger) peers(ctx context.Context) ([]Peer, error) {
+	if d.discover == nil {
+		return nil, nil
+	}
+	return d.discover(ctx)
+}
+
+// peer returns the peer called name.
+func (d *Debugger) peer(ctx context.Context, name string) (Peer, error) {
+	peers, err := d.peers(ctx)
+	if err != nil {
+		return Peer{}, err
+	}
+	for _, p := range peers {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return Peer{}, fmt.Errorf("netbug: unknown peer %q", name)
+}
+
+// peerEndpoint returns the endpoint that a request for the endpoint at
+// name is for on the peer it is proxied to, or name itself if it isn't
+// proxied.
+func peerEndpoint(name string) string {
+	if rest, ok := strings.CutPrefix(name, "peers/"); ok {
+		_, path, _ := strings.Cut(rest, "/")
+		return path
+	}
+	return name
+}
+
+// peerURL returns the URL of path on the peer's handler, with tok, if
+// any, set in query in place of the token it has.
+func peerURL(p Peer, path string, query url.Values, tok string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSuffix(p.URL, "/") + "/" + path)
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Del("token")
+	if tok != "" {
+		q.Set("token", tok)
+	}
+	u.RawQuery = q.Encode()
+	return u, nil
+}
+
+func (p Peer) token(def string) string {
+	if p.Token != "" {
+		return p.Token
+	}
+	return def
+}
+
+// fetchPeer returns the body of path on the peer's handler.
+func (d *Debugger) fetchPeer(ctx context.Context, p Peer, path string, query url.Values) ([]byte, error) {
+	u, err := peerURL(p, path, query, p.token(d.token))
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, peerTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if id, ok := RequestIDFrom(ctx); ok {
+		req.Header.Set("X-Request-Id", id)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("netbug: peer %s: GET %s: %s", p.Name, path, resp.Status)
+	}
+	return b, nil
+}
+
+// proxy serves path from the peer called name. Because the links on
+// netbug's pages are relative, the peer's pages work when proxied.
+func (d *Debugger) proxy(w http.ResponseWriter, r *http.Request, name, path string) {
+	p, err := d.peer(r.Context(), name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	// The request carries the caller's own token, or the peer's if it
+	// has one, but never the Debugger's, which would let callers do on
+	// the peer what they aren't allowed to here.
+	tok := p.Token
+	if tok == "" {
+		tok = requestToken(r)
+	}
+	u, err := peerURL(p, path, r.URL.Query(), tok)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL = u
+			pr.Out.Host = u.Host
+			pr.Out.Header.Del("Authorization")
+			pr.Out.Header.Del("Cookie")
+			if id, ok := RequestIDFrom(r.Context()); ok {
+				pr.Out.Header.Set("X-Request-Id", id)
+			}
+		},
+		// Pass each write on as it arrives, rather than buffering, so
+		// that long captures stream through over HTTP/2 as over HTTP/1.
+		FlushInterval: -1,
+	}
+	rp.ServeHTTP(w, r)
+}
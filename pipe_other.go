@@ -0,0 +1,15 @@
+//go:build !windows || !go1.25
+
+package netbug
+
+import (
+	"context"
+	"errors"
+)
+
+// ListenAndServePipe listens on the Windows named pipe name, such as
+// `\\.\pipe\myapp-debug`, and serves d as Serve does. It requires Windows
+// and Go 1.25 or later.
+func (d *Debugger) ListenAndServePipe(ctx context.Context, name, sddl string) error {
+	return errors.New("netbug: named pipes are not available on this platform")
+}
@@ -0,0 +1,215 @@
+//go:build go1.25
+
+package netbug
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modkernel32 = syscall.NewLazyDLL("kernel32.dll")
+	modadvapi32 = syscall.NewLazyDLL("advapi32.dll")
+
+	procCreateEventW        = modkernel32.NewProc("CreateEventW")
+	procCreateNamedPipeW    = modkernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe    = modkernel32.NewProc("ConnectNamedPipe")
+	procDisconnectNamedPipe = modkernel32.NewProc("DisconnectNamedPipe")
+	procGetOverlappedResult = modkernel32.NewProc("GetOverlappedResult")
+	procConvertSDDL         = modadvapi32.NewProc("ConvertStringSecurityDescriptorToSecurityDescriptorW")
+)
+
+const (
+	pipeAccessDuplex          = 0x3
+	fileFlagFirstPipeInstance = 0x80000
+	pipeRejectRemoteClients   = 0x8
+	pipeUnlimitedInstances    = 255
+	pipeBufferSize            = 64 << 10
+	sddlRevision1             = 1
+
+	errorPipeConnected syscall.Errno = 535
+)
+
+// pipeAddr is the address of a named pipe.
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipeConn is a connection to a named pipe. Opened for overlapped I/O,
+// its os.File supports concurrent reads and writes, and deadlines.
+type pipeConn struct {
+	*os.File
+	addr pipeAddr
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.addr }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.addr }
+
+func (c *pipeConn) Close() error {
+	procDisconnectNamedPipe.Call(c.Fd())
+	return c.File.Close()
+}
+
+// pipeListener is a net.Listener for a named pipe, creating an instance of
+// the pipe for each client.
+type pipeListener struct {
+	name string
+	sa   *syscall.SecurityAttributes
+
+	mu      sync.Mutex
+	next    syscall.Handle // the instance waiting for a client
+	closed  bool
+	pending bool // Accept is waiting on next
+}
+
+// listenPipe creates the named pipe name, with the security descriptor
+// described by sddl.
+func listenPipe(name, sddl string) (*pipeListener, error) {
+	s, err := syscall.UTF16PtrFromString(sddl)
+	if err != nil {
+		return nil, err
+	}
+	var sd uintptr
+	if r, _, err := procConvertSDDL.Call(uintptr(unsafe.Pointer(s)), sddlRevision1, uintptr(unsafe.Pointer(&sd)), 0); r == 0 {
+		return nil, fmt.Errorf("netbug: invalid security descriptor %q: %v", sddl, err)
+	}
+	l := &pipeListener{
+		name: name,
+		sa: &syscall.SecurityAttributes{
+			Length:             uint32(unsafe.Sizeof(syscall.SecurityAttributes{})),
+			SecurityDescriptor: sd,
+		},
+	}
+	// Creating the first instance fails if another process owns the name.
+	if l.next, err = l.create(true); err != nil {
+		syscall.LocalFree(syscall.Handle(sd))
+		return nil, err
+	}
+	return l, nil
+}
+
+// create creates an instance of the pipe.
+func (l *pipeListener) create(first bool) (syscall.Handle, error) {
+	name, err := syscall.UTF16PtrFromString(l.name)
+	if err != nil {
+		return syscall.InvalidHandle, err
+	}
+	mode := uint32(pipeAccessDuplex | syscall.FILE_FLAG_OVERLAPPED)
+	if first {
+		mode |= fileFlagFirstPipeInstance
+	}
+	h, _, err := procCreateNamedPipeW.Call(uintptr(unsafe.Pointer(name)), uintptr(mode),
+		pipeRejectRemoteClients, pipeUnlimitedInstances, pipeBufferSize, pipeBufferSize, 0,
+		uintptr(unsafe.Pointer(l.sa)))
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		return syscall.InvalidHandle, fmt.Errorf("netbug: creating pipe %s: %v", l.name, err)
+	}
+	return syscall.Handle(h), nil
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil, net.ErrClosed
+	}
+	if l.next == syscall.InvalidHandle {
+		h, err := l.create(false)
+		if err != nil {
+			l.mu.Unlock()
+			return nil, err
+		}
+		l.next = h
+	}
+	h := l.next
+	l.pending = true
+	l.mu.Unlock()
+
+	err := connectPipe(h)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending = false
+	if l.closed {
+		syscall.CloseHandle(h)
+		return nil, net.ErrClosed
+	}
+	l.next = syscall.InvalidHandle
+	if err != nil {
+		syscall.CloseHandle(h)
+		return nil, err
+	}
+	return &pipeConn{os.NewFile(uintptr(h), l.name), pipeAddr(l.name)}, nil
+}
+
+// connectPipe waits for a client to connect to the pipe instance h.
+func connectPipe(h syscall.Handle) error {
+	ev, _, err := procCreateEventW.Call(0, 1, 0, 0)
+	if ev == 0 {
+		return err
+	}
+	defer syscall.CloseHandle(syscall.Handle(ev))
+	// The kernel writes to o after ConnectNamedPipe returns, so it is
+	// allocated on the heap, where it won't move, rather than the stack.
+	o := &syscall.Overlapped{HEvent: syscall.Handle(ev)}
+	r, _, err := procConnectNamedPipe.Call(uintptr(h), uintptr(unsafe.Pointer(o)))
+	if r != 0 || err == errorPipeConnected {
+		return nil
+	}
+	if err != syscall.ERROR_IO_PENDING {
+		return err
+	}
+	var n uint32
+	if r, _, err := procGetOverlappedResult.Call(uintptr(h), uintptr(unsafe.Pointer(o)), uintptr(unsafe.Pointer(&n)), 1); r == 0 {
+		return err
+	}
+	return nil
+}
+
+func (l *pipeListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	if l.next != syscall.InvalidHandle {
+		if l.pending {
+			// Accept closes the handle once the wait is cancelled.
+			syscall.CancelIoEx(l.next, nil)
+		} else {
+			syscall.CloseHandle(l.next)
+		}
+	}
+	syscall.LocalFree(syscall.Handle(l.sa.SecurityDescriptor))
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr(l.name) }
+
+// defaultPipeSDDL allows the pipe's owner, administrators and the system
+// full access, and nobody else.
+const defaultPipeSDDL = "D:P(A;;GA;;;OW)(A;;GA;;;BA)(A;;GA;;;SY)"
+
+// ListenAndServePipe listens on the Windows named pipe name, such as
+// `\\.\pipe\myapp-debug`, and serves d as Serve does, so that Windows
+// services can offer the debug endpoints without a TCP port. Access is
+// controlled by the pipe's security descriptor, given in SDDL; if sddl is
+// empty, only the process's own user, administrators and the system can
+// connect. Remote clients are always rejected.
+func (d *Debugger) ListenAndServePipe(ctx context.Context, name, sddl string) error {
+	if sddl == "" {
+		sddl = defaultPipeSDDL
+	}
+	l, err := listenPipe(name, sddl)
+	if err != nil {
+		return err
+	}
+	return d.Serve(ctx, l)
+}
@@ -0,0 +1,314 @@
+package netbug
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// errBadProfile is returned when a profile can't be decoded.
+var errBadProfile = errors.New("netbug: malformed profile")
+
+// Fields of the pprof Profile message and the messages within it, from
+// https://github.com/google/pprof/blob/main/proto/profile.proto.
+const (
+	profileSampleType        = 1
+	profileSample            = 2
+	profileLocation          = 4
+	profileFunction          = 5
+	profileStringTable       = 6
+	profileTimeNanos         = 9
+	profileDurationNanos     = 10
+	profilePeriodType        = 11
+	profilePeriod            = 12
+	profileComment           = 13
+	profileDefaultSampleType = 14
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID   = 1
+	locationLine = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID       = 1
+	functionName     = 2
+	functionFilename = 4
+)
+
+// profileData is a decoded pprof profile, with its samples' stacks
+// resolved to functions, for converting to other formats.
+type profileData struct {
+	SampleTypes       []valueType
+	DefaultSampleType string
+	Samples           []stackSample
+	PeriodType        valueType
+	Period            int64
+	TimeNanos         int64
+	DurationNanos     int64
+}
+
+// valueType is the type of a value in a profile, such as "alloc_space" in
+// "bytes".
+type valueType struct {
+	Type, Unit string
+}
+
+// stackSample is a sample in a profile.
+type stackSample struct {
+	Stack  []profileFrame // innermost first, including inlined calls
+	Values []int64        // one for each of the profile's sample types
+}
+
+// profileFrame is a frame of a sample's stack.
+type profileFrame struct {
+	Func string
+	File string
+	Line int64
+}
+
+// sampleIndex returns the index of the sample type called name in p, or if
+// name is empty, of its default sample type, which is the last unless it
+// says otherwise, as with go tool pprof's -sample_index.
+func (p *profileData) sampleIndex(name string) (int, error) {
+	if len(p.SampleTypes) == 0 {
+		return 0, fmt.Errorf("netbug: profile has no sample types")
+	}
+	if name == "" {
+		name = p.DefaultSampleType
+	}
+	if name == "" {
+		return len(p.SampleTypes) - 1, nil
+	}
+	for i, t := range p.SampleTypes {
+		if t.Type == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("netbug: profile has no sample type %q", name)
+}
+
+// protoFields calls fn with each field of the encoded protocol buffer
+// message b: its number, and its value if a varint or fixed-width, or its
+// contents if length-delimited.
+func protoFields(b []byte, fn func(field uint64, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errBadProfile
+		}
+		b = b[n:]
+		var (
+			v    uint64
+			data []byte
+		)
+		switch tag & 7 { // wire type
+		case 0: // varint
+			if v, n = binary.Uvarint(b); n <= 0 {
+				return errBadProfile
+			}
+			b = b[n:]
+		case 1: // 64-bit
+			if len(b) < 8 {
+				return errBadProfile
+			}
+			v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case 2: // length-delimited
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return errBadProfile
+			}
+			data, b = b[n:n+int(l)], b[n+int(l):]
+		case 5: // 32-bit
+			if len(b) < 4 {
+				return errBadProfile
+			}
+			v, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		default:
+			return errBadProfile
+		}
+		if err := fn(tag>>3, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendPacked appends the repeated varint field whose value or, if it is
+// packed, contents are v or data to vs.
+func appendPacked(vs []uint64, v uint64, data []byte) ([]uint64, error) {
+	if data == nil {
+		return append(vs, v), nil
+	}
+	for len(data) > 0 {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errBadProfile
+		}
+		vs, data = append(vs, v), data[n:]
+	}
+	return vs, nil
+}
+
+// parseProfile decodes data, a pprof profile, gzipped or not. Only what is
+// needed to draw its samples' stacks is decoded: mappings, labels and
+// addresses are skipped.
+func parseProfile(data []byte) (*profileData, error) {
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			return nil, err
+		}
+	}
+
+	// Samples refer to locations, which refer to functions, which refer
+	// to strings, all of which may come in any order, so they are
+	// resolved once everything has been read.
+	type line struct{ fn, line uint64 }
+	type function struct{ name, file uint64 }
+	type sample struct {
+		locs   []uint64
+		values []uint64
+	}
+	var (
+		strs        []string
+		sampleTypes [][2]uint64
+		periodType  [2]uint64
+		defaultType uint64
+		samples     []sample
+		locs        = make(map[uint64][]line)
+		funcs       = make(map[uint64]function)
+		p           = new(profileData)
+	)
+	readValueType := func(data []byte) ([2]uint64, error) {
+		var t [2]uint64
+		err := protoFields(data, func(field, v uint64, _ []byte) error {
+			switch field {
+			case valueTypeType:
+				t[0] = v
+			case valueTypeUnit:
+				t[1] = v
+			}
+			return nil
+		})
+		return t, err
+	}
+	err := protoFields(data, func(field, v uint64, data []byte) error {
+		var err error
+		switch field {
+		case profileSampleType:
+			var t [2]uint64
+			t, err = readValueType(data)
+			sampleTypes = append(sampleTypes, t)
+		case profileSample:
+			var s sample
+			err = protoFields(data, func(field, v uint64, data []byte) (err error) {
+				switch field {
+				case sampleLocationID:
+					s.locs, err = appendPacked(s.locs, v, data)
+				case sampleValue:
+					s.values, err = appendPacked(s.values, v, data)
+				}
+				return err
+			})
+			samples = append(samples, s)
+		case profileLocation:
+			var (
+				id    uint64
+				lines []line
+			)
+			err = protoFields(data, func(field, v uint64, data []byte) error {
+				switch field {
+				case locationID:
+					id = v
+				case locationLine:
+					lines = append(lines, line{})
+					return protoFields(data, func(field, v uint64, _ []byte) error {
+						switch field {
+						case lineFunctionID:
+							lines[len(lines)-1].fn = v
+						case lineLine:
+							lines[len(lines)-1].line = v
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+			locs[id] = lines
+		case profileFunction:
+			var (
+				id uint64
+				f  function
+			)
+			err = protoFields(data, func(field, v uint64, _ []byte) error {
+				switch field {
+				case functionID:
+					id = v
+				case functionName:
+					f.name = v
+				case functionFilename:
+					f.file = v
+				}
+				return nil
+			})
+			funcs[id] = f
+		case profileStringTable:
+			strs = append(strs, string(data))
+		case profileTimeNanos:
+			p.TimeNanos = int64(v)
+		case profileDurationNanos:
+			p.DurationNanos = int64(v)
+		case profilePeriodType:
+			periodType, err = readValueType(data)
+		case profilePeriod:
+			p.Period = int64(v)
+		case profileDefaultSampleType:
+			defaultType = v
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	str := func(i uint64) string {
+		if i < uint64(len(strs)) {
+			return strs[i]
+		}
+		return ""
+	}
+	for _, t := range sampleTypes {
+		p.SampleTypes = append(p.SampleTypes, valueType{str(t[0]), str(t[1])})
+	}
+	p.PeriodType = valueType{str(periodType[0]), str(periodType[1])}
+	p.DefaultSampleType = str(defaultType)
+	p.Samples = make([]stackSample, 0, len(samples))
+	for _, s := range samples {
+		ps := stackSample{Values: make([]int64, len(s.values))}
+		for i, v := range s.values {
+			ps.Values[i] = int64(v)
+		}
+		for _, id := range s.locs {
+			// A location's lines are innermost first, the last being
+			// the function the others were inlined into.
+			for _, l := range locs[id] {
+				f := funcs[l.fn]
+				ps.Stack = append(ps.Stack, profileFrame{Func: str(f.name), File: str(f.file), Line: int64(l.line)})
+			}
+		}
+		p.Samples = append(p.Samples, ps)
+	}
+	return p, nil
+}
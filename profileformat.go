@@ -0,0 +1,123 @@
+package netbug
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// profileFormat is a format, other than pprof's own, that profiles can be
+// served in, asked for with the format parameter.
+type profileFormat struct {
+	contentType string
+
+	// ext is the extension of the file the profile is downloaded as, or
+	// "" if it is viewed in the browser.
+	ext string
+
+	// write writes p, the profile called name, in the format. params are
+	// the request's parameters, such as sample_index.
+	write func(w io.Writer, p *profileData, name string, params url.Values) error
+
+	// convert, if set instead of write, converts execution traces to
+	// the format.
+	convert TraceConverter
+}
+
+// profileFormats are the formats profiles can be served in, keyed by the
+// value of the format parameter.
+var profileFormats = map[string]profileFormat{
+	"svg":        {contentType: "image/svg+xml", write: writeGraphSVG},
+	"dot":        {contentType: "text/vnd.graphviz", ext: ".dot", write: writeGraphDOT},
+	"folded":     {contentType: "text/plain; charset=utf-8", write: writeFolded},
+	"speedscope": {contentType: "application/json", ext: ".speedscope.json", write: writeSpeedscope},
+}
+
+// format returns the format r asks for the profile called name to be
+// served in with the format parameter, if it is one of profileFormats or,
+// for execution traces, of those d is configured WithTraceFormat.
+func (d *Debugger) format(r *http.Request, name string) (profileFormat, bool) {
+	if name == "trace" {
+		f, ok := d.traceFormats[r.FormValue("format")]
+		return f, ok
+	}
+	f, ok := profileFormats[r.FormValue("format")]
+	return f, ok
+}
+
+// serveConverted serves the profile called name, as served by h, in the
+// format r asks for, if any, and returns whether it did.
+func (d *Debugger) serveConverted(w http.ResponseWriter, r *http.Request, name string, h http.HandlerFunc) bool {
+	f, ok := d.format(r, name)
+	if !ok {
+		return false
+	}
+	// Have h write the profile in pprof's format, whatever the debug
+	// parameter says.
+	q := r.URL.Query()
+	q.Del("debug")
+	pr := r.Clone(r.Context())
+	pr.URL.RawQuery = q.Encode()
+	pr.Form, pr.PostForm = nil, nil
+
+	buf := &profileBuffer{header: make(http.Header)}
+	d.withLoad(buf, pr, h)
+	if buf.code != 0 && buf.code != http.StatusOK {
+		// Pass on the error h served.
+		w.Header().Set("Content-Type", buf.header.Get("Content-Type"))
+		w.WriteHeader(buf.code)
+		w.Write(buf.Bytes())
+		return true
+	}
+	d.writeConverted(w, r, f, name, time.Now(), buf.Bytes())
+	return true
+}
+
+// writeConverted serves data, the profile called name captured at t, in
+// the format f.
+func (d *Debugger) writeConverted(w http.ResponseWriter, r *http.Request, f profileFormat, name string, t time.Time, data []byte) {
+	var out bytes.Buffer
+	if f.convert != nil {
+		if err := f.convert(&out, bytes.NewReader(data)); err != nil {
+			http.Error(w, fmt.Sprintf("converting %s: %v", name, err), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		p, err := parseProfile(data)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("decoding %s: %v", name, err), http.StatusInternalServerError)
+			return
+		}
+		if err := f.write(&out, p, name, r.Form); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", f.contentType)
+	if f.ext != "" {
+		dl := Download{Service: serviceName, Host: hostname(), Profile: name, Time: t, Ext: f.ext}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", d.filenameFor(dl)))
+	}
+	w.Write(out.Bytes())
+}
+
+// profileBuffer is an http.ResponseWriter that keeps the response in
+// memory, for converting profiles served by net/http/pprof.
+type profileBuffer struct {
+	bytes.Buffer
+	header http.Header
+	code   int
+}
+
+func (b *profileBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *profileBuffer) WriteHeader(code int) {
+	if b.code == 0 {
+		b.code = code
+	}
+}
@@ -0,0 +1,74 @@
+package netbug
+
+import (
+	"runtime/pprof"
+	"strings"
+)
+
+// WithProfiles exposes only the provided profiles, such as "heap" and
+// "goroutine", so that security teams can allow the ones they are
+// comfortable with in production and nothing else. Profiles are named as
+// their endpoints are: the runtime/pprof profiles by their names, "profile"
+// for the CPU profile, "trace" for execution traces, and "cmdline" and
+// "symbol" for the command line and symbol lookup. Any other profile
+// responds with 404 Not Found, and is left off the index page and the
+// endpoint catalog. The goroutine pages, such as goroutines?group=1, are
+// exposed along with "goroutine".
+//
+// WithProfiles only restricts the endpoints serving profiles. Profiles
+// captured by schedules and watchdogs can still be downloaded from the
+// history, so don't configure those for profiles that shouldn't be
+// exposed.
+func WithProfiles(names ...string) Option {
+	return func(d *Debugger) {
+		if d.profiles == nil {
+			d.profiles = make(map[string]bool)
+		}
+		for _, name := range names {
+			d.profiles[name] = true
+		}
+	}
+}
+
+// WithoutProfiles hides the provided profiles, such as "cmdline" and
+// "symbol", exposing every other. Profiles are named as with WithProfiles,
+// and WithoutProfiles takes precedence over it.
+func WithoutProfiles(names ...string) Option {
+	return func(d *Debugger) {
+		if d.noProfiles == nil {
+			d.noProfiles = make(map[string]bool)
+		}
+		for _, name := range names {
+			d.noProfiles[name] = true
+		}
+	}
+}
+
+// profileOf returns the profile served by the endpoint at name, or "" if
+// it doesn't serve one.
+func profileOf(name string) string {
+	switch {
+	case name == "profile" || name == "trace" || name == "cmdline" || name == "symbol":
+		return name
+	case name == "goroutines" || strings.HasPrefix(name, "goroutines/"):
+		return "goroutine"
+	case pprof.Lookup(name) != nil:
+		return name
+	}
+	return ""
+}
+
+// exposes reports whether d exposes the profile called name.
+func (d *Debugger) exposes(name string) bool {
+	if d.noProfiles[name] {
+		return false
+	}
+	return d.profiles == nil || d.profiles[name]
+}
+
+// exposesEndpoint reports whether d exposes the endpoint at name, which is
+// false if it serves a profile d doesn't expose.
+func (d *Debugger) exposesEndpoint(name string) bool {
+	p := profileOf(name)
+	return p == "" || d.exposes(p)
+}
@@ -0,0 +1,39 @@
+package netbug
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestReadOnlyArming checks that a read-only Debugger can still be armed
+// and disarmed, and that arming it enables only what read-only allows.
+func TestReadOnlyArming(t *testing.T) {
+	d := New(ReadOnly(), WithArming(time.Minute))
+	for _, tc := range []struct {
+		method, target string
+		want           int
+	}{
+		{"GET", "heap", http.StatusNotFound},
+		{"POST", "control/enabled?enabled=true&seconds=30", http.StatusOK},
+		{"GET", "heap", http.StatusOK},
+		{"GET", "profile?seconds=1", http.StatusForbidden},
+		{"POST", "control/gc", http.StatusForbidden},
+		{"POST", "control/enabled?enabled=false", http.StatusOK},
+		{"GET", "heap", http.StatusNotFound},
+	} {
+		if w := serveToken(d, tc.method, tc.target, ""); w.Code != tc.want {
+			t.Errorf("%s /%s: %d %s, want %d", tc.method, tc.target, w.Code, w.Body, tc.want)
+		}
+	}
+
+	listed := false
+	for _, e := range d.endpoints() {
+		if e.Path == "control/enabled" {
+			listed = len(e.Methods) == 1 && e.Methods[0] == "POST"
+		}
+	}
+	if !listed {
+		t.Error("control/enabled isn't listed for POST in the catalog of a read-only Debugger")
+	}
+}
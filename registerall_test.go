@@ -0,0 +1,114 @@
+package netbug
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// handlerMux is a Mux that isn't an http.ServeMux, such as another
+// router, serving every request with the last handler registered.
+type handlerMux struct {
+	prefix string
+	h      http.Handler
+}
+
+func (m *handlerMux) Handle(pattern string, h http.Handler) {
+	m.prefix, m.h = pattern, h
+}
+
+func (m *handlerMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.h.ServeHTTP(w, r)
+}
+
+// TestRegisterAllSharesState checks that the handlers RegisterAll
+// registers on different muxes share the Debugger's state: its store of
+// captured profiles, its journal and its lockout of clients that fail to
+// authenticate.
+func TestRegisterAllSharesState(t *testing.T) {
+	const prefix = "/debug/"
+	internal, external := http.NewServeMux(), &handlerMux{}
+	d := New(WithToken("secret"))
+	d.RegisterAll(prefix, internal, external)
+	if external.prefix != prefix {
+		t.Fatalf("registered on %q, want %q", external.prefix, prefix)
+	}
+
+	do := func(h http.Handler, method, target, remote string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, prefix+target, nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("store", func(t *testing.T) {
+		r := httptest.NewRequest("POST", prefix+"jobs?token=secret", strings.NewReader(`{"profiles": [{"profile": "heap"}]}`))
+		w := httptest.NewRecorder()
+		internal.ServeHTTP(w, r)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("starting job: %d %s", w.Code, w.Body)
+		}
+		var started struct{ ID string }
+		if err := json.Unmarshal(w.Body.Bytes(), &started); err != nil {
+			t.Fatal(err)
+		}
+
+		// The job, and the profile it captures, are visible on the other
+		// mux.
+		var j job
+		for deadline := time.Now().Add(10 * time.Second); ; {
+			w := do(external, "GET", "jobs/"+started.ID+"?token=secret", "192.0.2.1:1")
+			if w.Code != http.StatusOK {
+				t.Fatalf("job status on other mux: %d %s", w.Code, w.Body)
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &j); err != nil {
+				t.Fatal(err)
+			}
+			if j.State != "running" || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if j.State != "done" || len(j.Artifacts) != 1 {
+			t.Fatalf("job %s with %d artifacts, want done with 1: %v", j.State, len(j.Artifacts), j.Errors)
+		}
+		if w := do(external, "GET", j.Artifacts[0].URL+"?token=secret", "192.0.2.1:1"); w.Code != http.StatusOK {
+			t.Errorf("downloading %s on other mux: %d", j.Artifacts[0].URL, w.Code)
+		}
+	})
+
+	t.Run("journal", func(t *testing.T) {
+		do(internal, "GET", "cmdline?token=secret&marker=shared", "192.0.2.1:1")
+		w := do(external, "GET", "journal?token=secret&format=json", "192.0.2.1:1")
+		var es []journalEntry
+		if err := json.Unmarshal(w.Body.Bytes(), &es); err != nil {
+			t.Fatalf("journal: %v: %s", err, w.Body)
+		}
+		found := false
+		for _, e := range es {
+			found = found || e.Path == "cmdline" && strings.Contains(e.Query, "marker=shared")
+		}
+		if !found {
+			t.Errorf("request on one mux missing from the journal on the other: %+v", es)
+		}
+	})
+
+	t.Run("lockout", func(t *testing.T) {
+		const remote = "198.51.100.7:1"
+		for i := 0; i <= freeAuthFailures; i++ {
+			do(internal, "GET", "cmdline?token=wrong", remote)
+		}
+		w := do(external, "GET", "cmdline?token=secret", remote)
+		if w.Code != http.StatusTooManyRequests {
+			t.Errorf("client locked out on one mux: %d on the other, want %d", w.Code, http.StatusTooManyRequests)
+		}
+		if w := do(external, "GET", "cmdline?token=secret", "198.51.100.8:1"); w.Code != http.StatusOK {
+			t.Errorf("other client: %d, want %d", w.Code, http.StatusOK)
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package netbug
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"runtime/metrics"
+	"sort"
+)
+
+// A Report generates a custom report from the data in in, such as an
+// analysis specific to an organisation's services, which is served at
+// <prefix>reports/<name>. It should set the response's Content-Type. If it
+// returns an error before writing to w, the error is served instead.
+type Report func(w http.ResponseWriter, in *ReportInput) error
+
+// ReportInput is the data a Report is given. It is made of the values
+// produced by decoding JSON, so that it can be handed to reports written
+// in scripting languages as it is.
+type ReportInput struct {
+	// Stats is the instance's runtime state, as served at stats.json.
+	Stats map[string]interface{} `json:"stats"`
+
+	// Goroutines is every goroutine's state and stack.
+	Goroutines []ReportGoroutine `json:"goroutines"`
+
+	// Metrics is the scalar runtime/metrics, keyed by name, such as
+	// "/sched/goroutines:goroutines".
+	Metrics map[string]float64 `json:"metrics"`
+
+	// Params is the query parameters of the request for the report.
+	Params url.Values `json:"params"`
+}
+
+// ReportGoroutine is a goroutine, as given to a Report.
+type ReportGoroutine struct {
+	ID          int64    `json:"id"`
+	State       string   `json:"state"`
+	WaitSeconds float64  `json:"wait_seconds"`
+	Stack       []string `json:"stack"` // functions, innermost first
+}
+
+// WithReport serves r at <prefix>reports/<name>.
+func WithReport(name string, r Report) Option {
+	return func(d *Debugger) {
+		if d.reports == nil {
+			d.reports = make(map[string]Report)
+		}
+		d.reports[name] = r
+	}
+}
+
+// reportNames returns the names of d's reports, sorted.
+func (d *Debugger) reportNames() []string {
+	var names []string
+	for name := range d.reports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// reportInput gathers the data given to reports.
+func (d *Debugger) reportInput(r *http.Request) (*ReportInput, error) {
+	in := &ReportInput{Metrics: make(map[string]float64), Params: r.URL.Query()}
+	in.Params.Del("token")
+
+	b, err := json.Marshal(d.currentStats())
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &in.Stats); err != nil {
+		return nil, err
+	}
+
+	err = eachGoroutine(func(g goroutine) error {
+		rg := ReportGoroutine{ID: g.ID, State: g.State, WaitSeconds: g.Wait.Seconds(), Stack: []string{}}
+		for _, f := range g.Frames {
+			rg.Stack = append(rg.Stack, f.Func)
+		}
+		in.Goroutines = append(in.Goroutines, rg)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	descs := metrics.All()
+	samples := make([]metrics.Sample, len(descs))
+	for i, desc := range descs {
+		samples[i].Name = desc.Name
+	}
+	metrics.Read(samples)
+	for _, s := range samples {
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			in.Metrics[s.Name] = float64(s.Value.Uint64())
+		case metrics.KindFloat64:
+			in.Metrics[s.Name] = s.Value.Float64()
+		}
+	}
+	return in, nil
+}
+
+// serveReport serves the report called name, or with no name, the names
+// of d's reports as JSON.
+func (d *Debugger) serveReport(w http.ResponseWriter, r *http.Request, name string) {
+	if name == "" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(d.reportNames()); err != nil {
+			d.logf(slog.LevelWarn, "netbug: %v", err)
+		}
+		return
+	}
+	report, ok := d.reports[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	in, err := d.reportInput(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw := &responseRecorder{ResponseWriter: w}
+	if err := report(rw, in); err != nil {
+		if rw.wrote {
+			d.logf(slog.LevelError, "netbug: report %s: %v", name, err)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// responseRecorder records whether anything has been written to its
+// ResponseWriter.
+type responseRecorder struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (w *responseRecorder) WriteHeader(code int) {
+	w.wrote = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
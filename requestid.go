@@ -0,0 +1,61 @@
+package netbug
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// maxRequestID is the longest request ID accepted from a client.
+const maxRequestID = 128
+
+type requestIDKey struct{}
+
+// RequestIDFrom returns the ID of the request ctx belongs to, which the
+// Debugger adds to the request's context before handling it. The ID is
+// taken from the request's X-Request-Id header, such as one set by a load
+// balancer, or generated if it hasn't one, and is sent back in the
+// response's X-Request-Id header, included in the audit log and error
+// responses, and passed on to peers, so that requests can be correlated
+// with other logs.
+func RequestIDFrom(ctx context.Context) (id string, ok bool) {
+	id, ok = ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
+
+// withRequestID returns r with its ID in its context, setting the ID in
+// w's X-Request-Id header.
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get("X-Request-Id")
+	if !validRequestID(id) {
+		id = requestID()
+	}
+	w.Header().Set("X-Request-Id", id)
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+// validRequestID reports whether id, from a client, is safe to log and
+// send back: not too long, and only letters, digits and punctuation
+// commonly used in IDs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':' || c == '/' || c == '+' || c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns a random ID for a request.
+func requestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,91 @@
+package netbug
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// Retention limits the artifacts kept in a Debugger's Store, so that a
+// long-running service doesn't slowly fill its disk, or memory, with
+// profiles. The oldest artifacts are deleted first. Zero fields impose no
+// limit.
+type Retention struct {
+	// MaxCount is the most artifacts kept.
+	MaxCount int
+
+	// MaxBytes is the most bytes kept, across all artifacts. The newest
+	// artifact is kept however big it is.
+	MaxBytes int64
+
+	// MaxAge is how long artifacts are kept for.
+	MaxAge time.Duration
+
+	// Interval is how often artifacts are checked for having passed
+	// MaxAge while the Debugger is started. It defaults to one minute.
+	// The other limits are applied whenever an artifact is captured.
+	Interval time.Duration
+}
+
+// WithRetention deletes artifacts from the Debugger's Store once they
+// exceed the limits in r, whichever Store it uses. Files written by
+// WithCaptureDir are rotated separately.
+func WithRetention(r Retention) Option {
+	if r.Interval <= 0 {
+		r.Interval = time.Minute
+	}
+	return func(d *Debugger) {
+		d.retention = &r
+	}
+}
+
+// prune deletes the artifacts in d's store that exceed its retention
+// limits, if it has any, logging any failure.
+func (d *Debugger) prune() {
+	r := d.retention
+	if r == nil {
+		return
+	}
+	as, err := d.store.List()
+	if err != nil {
+		d.logf(slog.LevelError, "netbug: pruning artifacts: %v", err)
+		return
+	}
+	var (
+		count int
+		size  int64
+	)
+	for _, a := range as { // newest first
+		count++
+		size += a.Size
+		if (r.MaxCount <= 0 || count <= r.MaxCount) &&
+			(r.MaxBytes <= 0 || size <= r.MaxBytes || count == 1) &&
+			(r.MaxAge <= 0 || time.Since(a.Created) <= r.MaxAge) {
+			continue
+		}
+		if err := d.store.Delete(a.ID); err != nil && !errors.Is(err, ErrNotFound) {
+			d.logf(slog.LevelError, "netbug: pruning artifact %s: %v", a.ID, err)
+			continue
+		}
+		// Deleted artifacts don't count towards the limits.
+		count--
+		size -= a.Size
+	}
+}
+
+// runRetention prunes d's store every r.Interval until ctx is done, so
+// that artifacts are deleted once they pass r.MaxAge even when nothing
+// new is being captured.
+func (d *Debugger) runRetention(ctx context.Context, r *Retention) {
+	t := time.NewTicker(r.Interval)
+	defer t.Stop()
+	for {
+		d.prune()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
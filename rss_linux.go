@@ -0,0 +1,25 @@
+package netbug
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// residentSetSize returns the resident set size of the process in bytes.
+func residentSetSize() (uint64, error) {
+	b, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, err
+	}
+	f := bytes.Fields(b)
+	if len(f) < 2 {
+		return 0, fmt.Errorf("netbug: unexpected /proc/self/statm contents %q", b)
+	}
+	pages, err := strconv.ParseUint(string(f[1]), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return pages * uint64(os.Getpagesize()), nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package netbug
+
+import "errors"
+
+// residentSetSize returns the resident set size of the process in bytes.
+func residentSetSize() (uint64, error) {
+	return 0, errors.New("netbug: resident set size is not available on this platform")
+}
@@ -0,0 +1,113 @@
+package netbug
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"runtime/metrics"
+	"sort"
+	"strings"
+)
+
+// WithRuntimeMetrics serves every metric in runtime/metrics at
+// <prefix>metrics/runtime, in the Prometheus text format, so that the Go
+// runtime's health can be scraped without the application importing a
+// Prometheus client. Metrics are named after their runtime/metrics names,
+// such as go_gc_heap_allocs_bytes_total for /gc/heap/allocs:bytes;
+// cumulative metrics are counters, with a _total suffix, and
+// distributions are histograms whose sums are estimated from their
+// buckets.
+//
+// The endpoint is authenticated like the others; use
+// WithPublicEndpoints("metrics/runtime") to let a scraper without a token
+// read it.
+func WithRuntimeMetrics() Option {
+	return func(d *Debugger) {
+		d.runtimeMetrics = true
+	}
+}
+
+// promName returns the Prometheus name of the runtime/metrics metric
+// called name, which is a counter if cumulative.
+func promName(name string, cumulative bool) string {
+	path, unit, _ := strings.Cut(name, ":")
+	n := "go" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, path+"_"+unit)
+	if cumulative {
+		n += "_total"
+	}
+	return n
+}
+
+// serveRuntimeMetrics serves runtime/metrics in the Prometheus text
+// format.
+func serveRuntimeMetrics(w http.ResponseWriter, r *http.Request) {
+	descs := metrics.All()
+	sort.Slice(descs, func(i, j int) bool { return descs[i].Name < descs[j].Name })
+	samples := make([]metrics.Sample, len(descs))
+	for i, d := range descs {
+		samples[i].Name = d.Name
+	}
+	metrics.Read(samples)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	help := strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	for i, d := range descs {
+		v := samples[i].Value
+		cumulative := d.Cumulative && v.Kind() != metrics.KindFloat64Histogram
+		name := promName(d.Name, cumulative)
+		typ := "gauge"
+		switch {
+		case v.Kind() == metrics.KindFloat64Histogram:
+			typ = "histogram"
+		case cumulative:
+			typ = "counter"
+		}
+		switch v.Kind() {
+		case metrics.KindUint64, metrics.KindFloat64, metrics.KindFloat64Histogram:
+		default:
+			continue // KindBad, for metrics this runtime doesn't support
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n", name, help.Replace(d.Description))
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+		switch v.Kind() {
+		case metrics.KindUint64:
+			fmt.Fprintf(w, "%s %d\n", name, v.Uint64())
+		case metrics.KindFloat64:
+			fmt.Fprintf(w, "%s %g\n", name, v.Float64())
+		case metrics.KindFloat64Histogram:
+			writeHistogram(w, name, v.Float64Histogram())
+		}
+	}
+}
+
+// writeHistogram writes h as the Prometheus histogram name. Its sum is
+// estimated by assuming each sample is at the middle of its bucket, or at
+// the finite edge of a bucket with an infinite one.
+func writeHistogram(w http.ResponseWriter, name string, h *metrics.Float64Histogram) {
+	var count uint64
+	var sum float64
+	for i, n := range h.Counts {
+		lo, hi := h.Buckets[i], h.Buckets[i+1]
+		count += n
+		switch {
+		case math.IsInf(lo, -1) && math.IsInf(hi, 1):
+		case math.IsInf(lo, -1):
+			sum += hi * float64(n)
+		case math.IsInf(hi, 1):
+			sum += lo * float64(n)
+		default:
+			sum += (lo + hi) / 2 * float64(n)
+		}
+		if !math.IsInf(hi, 1) {
+			fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, hi, count)
+		}
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, count)
+	fmt.Fprintf(w, "%s_sum %g\n", name, sum)
+	fmt.Fprintf(w, "%s_count %d\n", name, count)
+}
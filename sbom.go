@@ -0,0 +1,127 @@
+package netbug
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// cdxBOM is the subset of the CycloneDX JSON format that netbug is able to
+// populate from the build information embedded in a Go binary.
+type cdxBOM struct {
+	BOMFormat   string         `json:"bomFormat"`
+	SpecVersion string         `json:"specVersion"`
+	Version     int            `json:"version"`
+	Metadata    cdxMetadata    `json:"metadata"`
+	Components  []cdxComponent `json:"components"`
+}
+
+type cdxMetadata struct {
+	Timestamp  string        `json:"timestamp"`
+	Component  cdxComponent  `json:"component"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxComponent struct {
+	Type       string        `json:"type"`
+	BOMRef     string        `json:"bom-ref,omitempty"`
+	Name       string        `json:"name"`
+	Version    string        `json:"version,omitempty"`
+	PURL       string        `json:"purl,omitempty"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// sbom serves a CycloneDX-style list of the modules compiled into the
+// running binary.
+func (d *Debugger) sbom(w http.ResponseWriter, r *http.Request) {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		http.Error(w, "Build information not available.", http.StatusNotFound)
+		return
+	}
+
+	bom := cdxBOM{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.5",
+		Version:     1,
+		Metadata: cdxMetadata{
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Component: cdxComponent{
+				Type:    "application",
+				BOMRef:  purl(bi.Main.Path, bi.Main.Version),
+				Name:    bi.Main.Path,
+				Version: bi.Main.Version,
+				PURL:    purl(bi.Main.Path, bi.Main.Version),
+			},
+			Properties: []cdxProperty{{Name: "go.version", Value: bi.GoVersion}},
+		},
+		Components: []cdxComponent{},
+	}
+	for _, s := range bi.Settings {
+		bom.Metadata.Properties = append(bom.Metadata.Properties, cdxProperty{
+			Name:  "go.build." + s.Key,
+			Value: s.Value,
+		})
+	}
+
+	for _, dep := range bi.Deps {
+		m := resolve(dep)
+		var props []cdxProperty
+		if m != dep {
+			props = append(props, cdxProperty{
+				Name:  "go.replaces",
+				Value: dep.Path + "@" + dep.Version,
+			})
+		}
+		// The go.sum hash is a SHA-256 of a listing of the module's
+		// files, not of any artifact, so it isn't one of CycloneDX's
+		// hashes, which a consumer would compare against a download.
+		if m.Sum != "" {
+			props = append(props, cdxProperty{Name: "go.sum", Value: m.Sum})
+		}
+		c := cdxComponent{
+			Type:       "library",
+			BOMRef:     purl(m.Path, m.Version),
+			Name:       m.Path,
+			Version:    m.Version,
+			PURL:       purl(m.Path, m.Version),
+			Properties: props,
+		}
+		bom.Components = append(bom.Components, c)
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.cyclonedx+json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(bom); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
+
+// resolve returns the module that replaced dep, or dep itself if it
+// wasn't replaced.
+func resolve(dep *debug.Module) *debug.Module {
+	if dep.Replace != nil {
+		return dep.Replace
+	}
+	return dep
+}
+
+// purl returns the package URL for a Go module.
+func purl(path, version string) string {
+	if path == "" {
+		return ""
+	}
+	p := "pkg:golang/" + path
+	if version != "" && version != "(devel)" {
+		p += "@" + version
+	}
+	return p
+}
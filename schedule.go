@@ -0,0 +1,219 @@
+package netbug
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Schedule describes a profile that a started Debugger captures
+// periodically, keeping the result in its Store.
+type Schedule struct {
+	// Profile is the name of the profile to capture: "profile" (or "cpu")
+	// for a CPU profile, "trace" for an execution trace, or the name of a
+	// runtime/pprof profile such as "heap" or "goroutine".
+	Profile string
+
+	// Duration is how long CPU profiles and traces run for. If zero, CPU
+	// profiles run for 30 seconds and traces for 1 second, as with
+	// /net/http/pprof.
+	Duration time.Duration
+
+	// Debug is the debug level runtime/pprof profiles are written with,
+	// e.g. 2 for a full goroutine stack dump.
+	Debug int
+
+	// Every captures the profile at a fixed interval.
+	Every time.Duration
+
+	// At captures the profile once a day at the given local time of day,
+	// in the form "15:04". It is ignored if Every is set.
+	At string
+}
+
+// String returns s in the form accepted by ParseSchedule.
+func (s Schedule) String() string {
+	var b strings.Builder
+	b.WriteString(s.Profile)
+	if s.Duration > 0 {
+		fmt.Fprintf(&b, " for %v", s.Duration)
+	}
+	if s.Debug > 0 {
+		fmt.Fprintf(&b, " debug=%d", s.Debug)
+	}
+	if s.Every > 0 {
+		fmt.Fprintf(&b, " every %v", s.Every)
+	} else {
+		fmt.Fprintf(&b, " at %s", s.At)
+	}
+	return b.String()
+}
+
+// ParseSchedule parses a schedule from its textual form, which is the name
+// of a profile followed by when to capture it, for example:
+//
+//	heap every 10m
+//	cpu for 10s every 1h
+//	goroutine debug=2 at 03:00
+//
+// This makes it easy to take schedules from configuration files or
+// flags.
+func ParseSchedule(s string) (Schedule, error) {
+	f := strings.Fields(s)
+	if len(f) == 0 {
+		return Schedule{}, errors.New("netbug: empty schedule")
+	}
+	sch := Schedule{Profile: f[0]}
+	bad := func(msg string) (Schedule, error) {
+		return Schedule{}, fmt.Errorf("netbug: invalid schedule %q: %s", s, msg)
+	}
+	for i := 1; i < len(f); i++ {
+		if strings.HasPrefix(f[i], "debug=") {
+			n, err := strconv.Atoi(strings.TrimPrefix(f[i], "debug="))
+			if err != nil {
+				return bad("invalid debug level")
+			}
+			sch.Debug = n
+			continue
+		}
+		if i+1 == len(f) {
+			return bad(fmt.Sprintf("missing value after %q", f[i]))
+		}
+		var err error
+		switch f[i] {
+		case "for":
+			sch.Duration, err = time.ParseDuration(f[i+1])
+		case "every":
+			sch.Every, err = time.ParseDuration(f[i+1])
+		case "at":
+			sch.At = f[i+1]
+		default:
+			return bad(fmt.Sprintf("unexpected %q", f[i]))
+		}
+		if err != nil {
+			return bad(err.Error())
+		}
+		i++
+	}
+	if err := sch.validate(); err != nil {
+		return bad(err.Error())
+	}
+	return sch, nil
+}
+
+func (s Schedule) validate() error {
+	if s.Profile == "" {
+		return errors.New("no profile")
+	}
+	if s.Every < 0 || s.Duration < 0 {
+		return errors.New("negative duration")
+	}
+	if s.Every == 0 {
+		if s.At == "" {
+			return errors.New("one of every or at is required")
+		}
+		if _, err := time.Parse("15:04", s.At); err != nil {
+			return fmt.Errorf("invalid time of day %q", s.At)
+		}
+	}
+	return nil
+}
+
+// WithSchedule captures profiles according to the provided schedules
+// while the Debugger is started. Invalid schedules cause Start to return
+// an error.
+func WithSchedule(schedules ...Schedule) Option {
+	return func(d *Debugger) {
+		d.schedules = append(d.schedules, schedules...)
+	}
+}
+
+// next returns when s should next capture a profile after now.
+func (s Schedule) next(now time.Time) time.Time {
+	if s.Every > 0 {
+		return now.Add(s.Every)
+	}
+	at, _ := time.Parse("15:04", s.At)
+	t := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	if !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// runSchedule captures profiles according to s until ctx is done.
+func (d *Debugger) runSchedule(ctx context.Context, s Schedule) {
+	name, dur := s.Profile, s.Duration
+	if name == "cpu" {
+		name = "profile"
+	}
+	if dur == 0 {
+		dur = 30 * time.Second
+		if name == "trace" {
+			dur = time.Second
+		}
+	}
+
+	defer d.upcoming.set(s.String(), time.Time{})
+	for {
+		next := s.next(time.Now())
+		d.upcoming.set(s.String(), next)
+		if err := sleep(ctx, time.Until(next)); err != nil {
+			return
+		}
+		if _, err := d.capture(ctx, name, dur, s.Debug, "schedule"); err != nil && ctx.Err() == nil {
+			d.logf(slog.LevelError, "netbug: scheduled capture %q: %v", s, err)
+		}
+	}
+}
+
+// upcomingCapture is the next capture a schedule makes.
+type upcomingCapture struct {
+	Schedule string    `json:"schedule"`
+	Next     time.Time `json:"next"`
+}
+
+// upcomingCaptures records when each of a started Debugger's schedules
+// next captures a profile.
+type upcomingCaptures struct {
+	mu   sync.Mutex
+	next map[string]time.Time // by Schedule.String
+}
+
+// set records that the schedule s next captures a profile at t, or if t
+// is zero, that it has stopped.
+func (u *upcomingCaptures) set(s string, t time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if t.IsZero() {
+		delete(u.next, s)
+		return
+	}
+	if u.next == nil {
+		u.next = make(map[string]time.Time)
+	}
+	u.next[s] = t
+}
+
+// list returns the upcoming captures, soonest first.
+func (u *upcomingCaptures) list() []upcomingCapture {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	cs := []upcomingCapture{}
+	for s, t := range u.next {
+		cs = append(cs, upcomingCapture{s, t})
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		if !cs[i].Next.Equal(cs[j].Next) {
+			return cs[i].Next.Before(cs[j].Next)
+		}
+		return cs[i].Schedule < cs[j].Schedule
+	})
+	return cs
+}
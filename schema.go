@@ -0,0 +1,10 @@
+package netbug
+
+// schemaVersion is the version of the JSON served at stats.json,
+// endpoints.json, the index page, goroutines?group=1 and history when
+// asked for with format=json or the Accept header, reported in their
+// schema_version fields, so that automation can detect changes that would
+// break it. It is incremented whenever a field is removed, renamed or
+// changes meaning. Fields may be added without incrementing it, so
+// consumers should ignore fields they don't know.
+const schemaVersion = 1
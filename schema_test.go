@@ -0,0 +1,203 @@
+package netbug
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sync"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// schemaOf returns the shape of the decoded JSON value v: the same
+// objects, with every other value replaced by the name of its type, and
+// each array by one element with the fields of all of its elements. The
+// shape of an output changes only when its schema does, not with the
+// state of the process serving it.
+func schemaOf(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		s := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			s[k] = schemaOf(e)
+		}
+		return s
+	case []interface{}:
+		if len(v) == 0 {
+			return []interface{}{}
+		}
+		var merged interface{}
+		for _, e := range v {
+			merged = mergeSchemas(merged, schemaOf(e))
+		}
+		return []interface{}{merged}
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "bool"
+	case nil:
+		return "null"
+	}
+	panic("unexpected JSON value")
+}
+
+// mergeSchemas returns the shape with the fields of both a and b, for
+// arrays whose elements omit different empty fields.
+func mergeSchemas(a, b interface{}) interface{} {
+	am, ok := a.(map[string]interface{})
+	bm, ok2 := b.(map[string]interface{})
+	if !ok || !ok2 {
+		if a == nil {
+			return b
+		}
+		return a
+	}
+	for k, v := range bm {
+		am[k] = mergeSchemas(am[k], v)
+	}
+	return am
+}
+
+// checkGolden fails t unless the shape of the JSON in body matches the
+// golden file testdata/schema/name, or with -update, rewrites it.
+func checkGolden(t *testing.T, name string, body []byte) {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		t.Fatalf("%s: %v: %s", name, err, body)
+	}
+	// Fields that depend on how the test binary was built, or on the
+	// platform, rather than on netbug.
+	if m, ok := v.(map[string]interface{}); ok {
+		for _, stats := range []interface{}{m, m["runtime"]} {
+			if s, ok := stats.(map[string]interface{}); ok {
+				for _, k := range []string{"version", "revision", "modified", "rss_bytes"} {
+					delete(s, k)
+				}
+			}
+		}
+	}
+	got, err := json.MarshalIndent(schemaOf(v), "", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, '\n')
+
+	path := filepath.Join("testdata", "schema", name)
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("schema of %s changed; if schemaVersion needn't change, or has, run go test -update:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+//go:noinline
+func schemaParked(started *sync.WaitGroup, c chan struct{}) {
+	started.Done()
+	<-c
+}
+
+// TestSchemas checks the schemas of the JSON outputs that carry a
+// schema_version against the golden files in testdata/schema, so that a
+// change that would break their consumers is noticed.
+func TestSchemas(t *testing.T) {
+	store := NewMemoryStore(8)
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	// An artifact with every field set, so that all of them are listed.
+	if err := store.Put(Artifact{
+		ID:       "1",
+		Profile:  "profile",
+		Debug:    1,
+		Duration: 10 * time.Second,
+		Trigger:  "schedule",
+		Host:     "host",
+		Service:  "service",
+		Version:  "v1.0.0",
+		Labels:   map[string]string{"region": "eu"},
+		Created:  created,
+		Size:     3,
+		SHA256:   "abc",
+	}, []byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	// As with a runbook, page and report, which are otherwise omitted.
+	d := New(
+		WithStore(store),
+		WithRunbooks(map[string]Runbook{"heap": {URL: "https://example.com/heap", Note: "note"}}),
+		WithReport("report", func(http.ResponseWriter, *ReportInput) error { return nil }),
+	)
+	d.RegisterPage("page", "A page.", http.NotFoundHandler())
+	d.upcoming.set("heap every 1m0s", created.Add(time.Minute))
+
+	// Labelled goroutines, so that groups' labels are listed.
+	stop := make(chan struct{})
+	defer close(stop)
+	var started sync.WaitGroup
+	started.Add(1)
+	pprof.Do(context.Background(), pprof.Labels("schema", "test"), func(context.Context) {
+		go schemaParked(&started, stop)
+	})
+	started.Wait()
+
+	for _, tc := range []struct {
+		golden, target string
+	}{
+		{"index.json", "?format=json"},
+		{"stats.json", "stats.json"},
+		{"endpoints.json", "endpoints.json"},
+		{"goroutines.json", "goroutines?group=1&format=json&match=schemaParked"},
+		{"history.json", "history?format=json"},
+	} {
+		t.Run(tc.golden, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			d.ServeHTTP(w, httptest.NewRequest("GET", "/"+tc.target, nil))
+			if w.Code != 200 {
+				t.Fatalf("GET /%s: %d %s", tc.target, w.Code, w.Body)
+			}
+			var v struct {
+				SchemaVersion *int `json:"schema_version"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+				t.Fatal(err)
+			}
+			if v.SchemaVersion == nil || *v.SchemaVersion != schemaVersion {
+				t.Errorf("schema_version missing or not %d", schemaVersion)
+			}
+			checkGolden(t, tc.golden, w.Body.Bytes())
+		})
+	}
+}
+
+// TestSchemaOf checks that array elements' fields are merged.
+func TestSchemaOf(t *testing.T) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(`{"a": [{"b": 1}, {"c": "x", "b": 2}], "d": [], "e": null, "f": true}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := json.Marshal(schemaOf(v))
+	want := `{"a":[{"b":"number","c":"string"}],"d":[],"e":"null","f":"bool"}`
+	if string(got) != want {
+		t.Errorf("schemaOf = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,62 @@
+package netbug
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// serveToken serves a request for target, relative to d's root, made with
+// the bearer token tok, if any.
+func serveToken(d http.Handler, method, target, tok string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/"+target, nil)
+	if tok != "" {
+		r.Header.Set("Authorization", "Bearer "+tok)
+	}
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, r)
+	return w
+}
+
+// TestScopedTokens checks that scoped tokens reach the endpoints matching
+// their patterns and no others, and that the main token reaches them all.
+func TestScopedTokens(t *testing.T) {
+	d := New(
+		WithToken("main"),
+		WithScopedToken("heap-only", "heap"),
+		WithScopedToken("controls", "control/*"),
+		WithScopedToken("nothing"),
+	)
+	for _, tc := range []struct {
+		tok, method, target string
+		want                int
+	}{
+		{"main", "GET", "cmdline", http.StatusOK},
+		{"main", "GET", "heap", http.StatusOK},
+		{"main", "POST", "control/gc", http.StatusOK},
+
+		{"heap-only", "GET", "", http.StatusOK},
+		{"heap-only", "GET", "heap", http.StatusOK},
+		{"heap-only", "GET", "cmdline", http.StatusForbidden},
+		{"heap-only", "GET", "goroutine", http.StatusForbidden},
+		{"heap-only", "POST", "control/gc", http.StatusForbidden},
+		{"heap-only", "GET", "peers/p/heap", http.StatusForbidden},
+
+		{"controls", "POST", "control/gc", http.StatusOK},
+		{"controls", "GET", "heap", http.StatusForbidden},
+
+		// A scoped token without patterns can only load the index.
+		{"nothing", "GET", "", http.StatusOK},
+		{"nothing", "GET", "cmdline", http.StatusForbidden},
+		{"nothing", "GET", "heap", http.StatusForbidden},
+		{"nothing", "GET", "stats.json", http.StatusForbidden},
+		{"nothing", "POST", "control/gc", http.StatusForbidden},
+
+		{"", "GET", "", http.StatusUnauthorized},
+		{"wrong", "GET", "heap", http.StatusUnauthorized},
+	} {
+		if w := serveToken(d, tc.method, tc.target, tc.tok); w.Code != tc.want {
+			t.Errorf("%s /%s with %q: %d, want %d", tc.method, tc.target, tc.tok, w.Code, tc.want)
+		}
+	}
+}
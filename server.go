@@ -0,0 +1,365 @@
+package netbug
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// shutdownTimeout is how long a standalone server waits for in-progress
+// requests, such as CPU profiles, when shutting down.
+const shutdownTimeout = 30 * time.Second
+
+// newServer returns an http.Server serving d at the root of its own
+// ServeMux, so that the debug endpoints share nothing with the
+// application's own routes.
+//
+// The server has no write timeout, as profiles and traces stream for as
+// long as the request asks for, and net/http/pprof refuses requests longer
+// than the write timeout. It accepts h2c as well as HTTP/1.
+func (d *Debugger) newServer() *http.Server {
+	mux := http.NewServeMux()
+	d.Register("/", mux)
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+		IdleTimeout:       2 * time.Minute,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			if cc, ok := c.(credConn); ok {
+				return withPrincipal(ctx, Principal{Kind: "unix", ID: strconv.FormatUint(uint64(cc.uid), 10)})
+			}
+			return ctx
+		},
+	}
+	enableH2C(srv)
+	return srv
+}
+
+// Serve serves d on l until ctx is done, then shuts down gracefully,
+// giving in-progress requests up to 30 seconds to finish. It returns nil
+// after a graceful shutdown, and otherwise the error that stopped the
+// server. l is closed when Serve returns.
+//
+// Serve doesn't Start d.
+func (d *Debugger) Serve(ctx context.Context, l net.Listener) error {
+	srv := d.newServer()
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(l) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(sctx); err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// systemdPrefix is the prefix of addresses naming a socket passed by
+// systemd socket activation rather than a TCP address.
+const systemdPrefix = "systemd:"
+
+// listen listens on the TCP address addr, or if addr is "systemd:" or
+// "systemd:<name>", returns the socket passed by systemd.
+func listen(addr string) (net.Listener, error) {
+	if name, ok := strings.CutPrefix(addr, systemdPrefix); ok {
+		return SystemdListener(name)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// ListenAndServe listens on the TCP address addr and serves d as Serve
+// does, for serving the debug endpoints on a different port than the
+// application, such as one bound to localhost only.
+//
+// If addr is "systemd:<name>", d is instead served on the socket with
+// that FileDescriptorName passed by systemd socket activation, or with
+// "systemd:", on the first socket passed. With a socket unit, systemd can
+// then start the process only once someone connects to the debug port.
+func (d *Debugger) ListenAndServe(ctx context.Context, addr string) error {
+	l, err := listen(addr)
+	if err != nil {
+		return err
+	}
+	return d.Serve(ctx, l)
+}
+
+// WithSocketOwner makes ListenAndServeUnix give its socket to the user
+// uid and group gid, for processes that start as root but whose debug
+// endpoints should be reachable by another user, such as an operator's
+// account. Connections are then only accepted from processes running as
+// that user or group, or as the process's own user, which is checked
+// with the peer's credentials where the platform supports it, and
+// otherwise left to the socket's file permissions.
+func WithSocketOwner(uid, gid int) Option {
+	return func(d *Debugger) {
+		d.socketOwner = &socketOwner{uid, gid}
+	}
+}
+
+// socketOwner is the owner set by WithSocketOwner.
+type socketOwner struct {
+	uid, gid int
+}
+
+// WithPeerCredAuth makes ListenAndServeUnix only accept connections from
+// processes running as one of the users allowedUIDs or in one of the
+// groups allowedGIDs, as reported by the peer's credentials, so that only
+// specific local users and tools can reach the debug endpoints at all.
+// Requests over such connections are attributed to a Principal of kind
+// "unix" whose ID is the peer's user ID. If the platform can't report
+// peer credentials, ListenAndServeUnix fails rather than serving
+// unchecked connections.
+//
+// WithPeerCredAuth takes precedence over the users allowed by
+// WithSocketOwner.
+func WithPeerCredAuth(allowedUIDs, allowedGIDs []int) Option {
+	return func(d *Debugger) {
+		d.peerCredAuth = &peerCredAuth{allowedUIDs, allowedGIDs}
+	}
+}
+
+// peerCredAuth is the users and groups allowed by WithPeerCredAuth.
+type peerCredAuth struct {
+	uids, gids []int
+}
+
+// allows reports whether a peer running as uid and gid is allowed.
+func (a *peerCredAuth) allows(uid, gid uint32) bool {
+	for _, u := range a.uids {
+		if uint32(u) == uid {
+			return true
+		}
+	}
+	for _, g := range a.gids {
+		if uint32(g) == gid {
+			return true
+		}
+	}
+	return false
+}
+
+// peerCredListener is a net.Listener for a Unix domain socket that closes
+// connections from processes whose credentials aren't allowed.
+type peerCredListener struct {
+	net.Listener
+	allow func(uid, gid uint32) bool
+	logf  func(level slog.Level, format string, args ...interface{})
+}
+
+// credConn is a connection accepted by a peerCredListener, along with the
+// peer's credentials.
+type credConn struct {
+	net.Conn
+	uid, gid uint32
+}
+
+func (l peerCredListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		uid, gid, err := peerCredentials(c)
+		if err != nil {
+			l.logf(slog.LevelWarn, "netbug: refused connection: %v", err)
+		} else if l.allow(uid, gid) {
+			return credConn{c, uid, gid}, nil
+		} else {
+			l.logf(slog.LevelWarn, "netbug: refused connection from uid %d gid %d", uid, gid)
+		}
+		c.Close()
+	}
+}
+
+// ListenAndServeUnix listens on a Unix domain socket at path, with the
+// file permissions perm, and serves d as Serve does. This leaves access
+// control to the file system, with no network exposure at all; reach the
+// socket locally or over SSH with, for example:
+//
+//	curl --unix-socket /run/app/debug.sock http://localhost/goroutine?debug=1
+//
+// A stale socket left at path by a previous process is removed. The
+// socket is removed when Serve returns.
+//
+// The socket is created in a directory only the process can reach, next
+// to path, and only moved to path once its permissions and owner are
+// set, so that it can't be connected to before they are.
+func (d *Debugger) ListenAndServeUnix(ctx context.Context, path string, perm os.FileMode) error {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".netbug")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "s")
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return err
+	}
+	// The socket is removed from path below, rather than from tmp, where
+	// the listener would remove it.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, perm); err != nil {
+		l.Close()
+		return err
+	}
+	if o := d.socketOwner; o != nil {
+		if err := os.Chown(tmp, o.uid, o.gid); err != nil {
+			l.Close()
+			return err
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return err
+	}
+	os.Remove(dir)
+	defer os.Remove(path)
+	switch {
+	case d.peerCredAuth != nil:
+		if !peerCredentialsSupported {
+			l.Close()
+			return errors.New("netbug: peer credential authentication is not available on this platform")
+		}
+		l = peerCredListener{l, d.peerCredAuth.allows, d.logf}
+	case d.socketOwner != nil && peerCredentialsSupported:
+		self, o := uint32(os.Getuid()), d.socketOwner
+		l = peerCredListener{l, func(uid, gid uint32) bool {
+			return uid == self || uid == uint32(o.uid) || gid == uint32(o.gid)
+		}, d.logf}
+	}
+	return d.Serve(ctx, l)
+}
+
+// loopbackListener is a net.Listener that closes connections from
+// anywhere but the loopback interface.
+type loopbackListener struct {
+	net.Listener
+	logf func(level slog.Level, format string, args ...interface{})
+}
+
+func (l loopbackListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if a, ok := c.RemoteAddr().(*net.TCPAddr); ok && a.IP.IsLoopback() {
+			return c, nil
+		}
+		l.logf(slog.LevelWarn, "netbug: refused connection from %v, which isn't loopback", c.RemoteAddr())
+		c.Close()
+	}
+}
+
+// ListenAndServeLoopback listens on an ephemeral port on 127.0.0.1 and
+// serves d as Serve does, refusing connections from anywhere but the
+// loopback interface. It is meant for reaching the debug endpoints through
+// an SSH tunnel rather than exposing them on the network, and logs the
+// address chosen along with the ssh command to reach it.
+func (d *Debugger) ListenAndServeLoopback(ctx context.Context) error {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	addr := l.Addr().String()
+	d.logf(slog.LevelInfo, "netbug: serving on %s; to reach it, run ssh -L 6060:%s %s and open http://localhost:6060/", addr, addr, hostname())
+	return d.Serve(ctx, loopbackListener{l, d.logf})
+}
+
+// ListenAndServe starts a standalone debug server listening on the TCP
+// address addr, or on a socket passed by systemd as with
+// Debugger.ListenAndServe, configured with the provided options as with
+// New. It starts the Debugger's background work, and blocks until the
+// server fails:
+//
+//	go func() {
+//		log.Fatal(netbug.ListenAndServe("localhost:6060", netbug.WithToken("open sesame")))
+//	}()
+//
+// The debug endpoints are served at the root of the server, such as
+// http://localhost:6060/profile. To shut the server down gracefully,
+// create a Debugger with New and use its ListenAndServe method.
+func ListenAndServe(addr string, opts ...Option) error {
+	d := New(opts...)
+	if err := d.Start(); err != nil {
+		return err
+	}
+	defer d.Stop()
+	return d.ListenAndServe(context.Background(), addr)
+}
+
+// ListenAndServeUnix starts a standalone debug server listening on a Unix
+// domain socket at path, with the file permissions perm, configured with
+// the provided options as with New. Otherwise it is the same as
+// ListenAndServe:
+//
+//	go func() {
+//		log.Fatal(netbug.ListenAndServeUnix("/run/app/debug.sock", 0600))
+//	}()
+func ListenAndServeUnix(path string, perm os.FileMode, opts ...Option) error {
+	d := New(opts...)
+	if err := d.Start(); err != nil {
+		return err
+	}
+	defer d.Stop()
+	return d.ListenAndServeUnix(context.Background(), path, perm)
+}
+
+// Serve starts a standalone debug server on l, configured with the
+// provided options as with New, such as on a listener returned by
+// SystemdListener. Otherwise it is the same as ListenAndServe.
+func Serve(l net.Listener, opts ...Option) error {
+	d := New(opts...)
+	if err := d.Start(); err != nil {
+		return err
+	}
+	defer d.Stop()
+	return d.Serve(context.Background(), l)
+}
+
+// ListenAndServeLoopback starts a standalone debug server on an ephemeral
+// port on 127.0.0.1, configured with the provided options as with New,
+// for reaching through an SSH tunnel. The address chosen is logged.
+// Otherwise it is the same as ListenAndServe.
+func ListenAndServeLoopback(opts ...Option) error {
+	d := New(opts...)
+	if err := d.Start(); err != nil {
+		return err
+	}
+	defer d.Stop()
+	return d.ListenAndServeLoopback(context.Background())
+}
+
+// ListenAndServePipe starts a standalone debug server listening on the
+// Windows named pipe name, with the security descriptor sddl, configured
+// with the provided options as with New. See Debugger.ListenAndServePipe.
+// Otherwise it is the same as ListenAndServe.
+func ListenAndServePipe(name, sddl string, opts ...Option) error {
+	d := New(opts...)
+	if err := d.Start(); err != nil {
+		return err
+	}
+	defer d.Stop()
+	return d.ListenAndServePipe(context.Background(), name, sddl)
+}
@@ -0,0 +1,75 @@
+package netbug
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// TestListenAndServeUnix checks that the socket only appears at its path
+// with the permissions asked for, that nothing else is left next to it,
+// and that it is removed once the server stops.
+func TestListenAndServeUnix(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix domain socket permissions aren't enforced on Windows")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "debug.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- New().ListenAndServeUnix(ctx, path, 0o640) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("ListenAndServeUnix: %v", err)
+		}
+		if _, err := os.Lstat(path); !os.IsNotExist(err) {
+			t.Errorf("socket left behind: %v", err)
+		}
+	}()
+
+	var fi os.FileInfo
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		var err error
+		if fi, err = os.Lstat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no socket at %s: %v", path, err)
+		}
+	}
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0o640 {
+		t.Errorf("socket mode %v, want a socket with permissions 0640", fi.Mode())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("%s has %q, want only the socket", dir, names)
+	}
+
+	c := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := c.Get("http://netbug/cmdline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /cmdline: %s", resp.Status)
+	}
+}
@@ -0,0 +1,271 @@
+package netbug
+
+import (
+	"bufio"
+	"debug/elf"
+	"debug/gosym"
+	"debug/macho"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// sourceContext is the number of lines shown either side of the line
+// asked for, unless the context parameter says otherwise.
+const sourceContext = 10
+
+// WithSource serves the source of the running binary at debug/source, so
+// that the functions and lines in a profile can be read alongside it, as
+// with go tool pprof's weblist, without a checkout of the revision the
+// process was built from. Files of the main module are read from src,
+// which holds the module's source with its go.mod at the root, such as an
+// embed.FS declared in the module's root package. Other files, such as
+// the standard library's, and any of the main module's that src doesn't
+// have, are read from the path the binary records on the host, which
+// exists for the standard library if Go is installed where it was built.
+// src may be nil.
+//
+// Only Go files compiled into the binary are served.
+func WithSource(src fs.FS) Option {
+	return func(d *Debugger) {
+		d.source = &sourceView{src: src}
+	}
+}
+
+// sourceView serves source files, using the running binary's symbol table
+// to resolve functions and to restrict the files served to those compiled
+// into it.
+type sourceView struct {
+	src fs.FS
+
+	once  sync.Once
+	tab   *gosym.Table
+	roots []string // where the main module's files are in the binary
+	err   error
+}
+
+// table returns the running binary's symbol table, reading it the first
+// time it is needed.
+func (v *sourceView) table() (*gosym.Table, error) {
+	v.once.Do(func() {
+		if v.tab, v.err = readSymbolTable(); v.err == nil {
+			v.roots = moduleRoots(v.tab)
+		}
+	})
+	return v.tab, v.err
+}
+
+// readSymbolTable reads the symbol table of the running executable, which
+// must be in the ELF or Mach-O format.
+func readSymbolTable() (*gosym.Table, error) {
+	f, _, err := openExecutable()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var pclntab, text interface {
+		Data() ([]byte, error)
+	}
+	var textAddr uint64
+	if ef, err := elf.NewFile(f); err == nil {
+		if s, t := ef.Section(".gopclntab"), ef.Section(".text"); s != nil && t != nil {
+			pclntab, text, textAddr = s, t, t.Addr
+		}
+	} else if mf, err := macho.NewFile(f); err == nil {
+		if s, t := mf.Section("__gopclntab"), mf.Section("__text"); s != nil && t != nil {
+			pclntab, text, textAddr = s, t, t.Addr
+		}
+	} else {
+		return nil, errors.New("netbug: reading the symbol table is only supported for ELF and Mach-O executables")
+	}
+	if pclntab == nil || text == nil {
+		return nil, errors.New("netbug: executable has no Go symbol table")
+	}
+	data, err := pclntab.Data()
+	if err != nil {
+		return nil, err
+	}
+	return gosym.NewTable(nil, gosym.NewLineTable(data, textAddr))
+}
+
+// moduleRoots returns the directories the main module's files are in, as
+// recorded in tab: its module path, if built with -trimpath, and the
+// directory it was built in, found from the file main.main is in and the
+// main package's path within the module.
+func moduleRoots(tab *gosym.Table) []string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok || bi.Main.Path == "" {
+		return nil
+	}
+	roots := []string{bi.Main.Path}
+	pkg, ok := strings.CutPrefix(bi.Path, bi.Main.Path)
+	if f := tab.LookupFunc("main.main"); ok && f != nil {
+		file, _, _ := tab.PCToLine(f.Entry)
+		if root, ok := strings.CutSuffix(path.Dir(file), pkg); ok && root != bi.Main.Path {
+			roots = append(roots, root)
+		}
+	}
+	return roots
+}
+
+// open opens the source file recorded in the binary as name: from v's
+// src, at its path within the main module, if it is one of the module's,
+// or else from the host.
+func (v *sourceView) open(name string) (io.ReadCloser, error) {
+	if v.src != nil {
+		for _, root := range v.roots {
+			if rel, ok := strings.CutPrefix(name, root+"/"); ok {
+				if f, err := v.src.Open(rel); err == nil {
+					return f, nil
+				}
+			}
+		}
+	}
+	return os.Open(name)
+}
+
+// serveSource serves source lines from the running binary, either of the
+// file named by the file parameter, around the line parameter, if given,
+// or of the function named by the func parameter, such as
+// "net/http.(*conn).serve" as it appears in a profile. The context
+// parameter is how many lines either side are shown. The lines are
+// served as HTML, with an anchor for each line, or as text with
+// format=text.
+func (d *Debugger) serveSource(w http.ResponseWriter, r *http.Request) {
+	v := d.source
+	if v == nil {
+		http.NotFound(w, r)
+		return
+	}
+	tab, err := v.table()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	file, fn := r.FormValue("file"), r.FormValue("func")
+	line, _ := strconv.Atoi(r.FormValue("line"))
+	context := sourceContext
+	if c, err := strconv.Atoi(r.FormValue("context")); err == nil && c >= 0 {
+		context = c
+	}
+	var first, last int // the lines to show; 0 for the whole file
+	switch {
+	case fn != "":
+		f := tab.LookupFunc(fn)
+		if f == nil {
+			http.Error(w, fmt.Sprintf("function %s not found; it may have been inlined everywhere", fn), http.StatusNotFound)
+			return
+		}
+		file, first, last = funcLines(tab, f)
+		first, last = first-context, last+context
+	case file == "":
+		http.Error(w, "file or func is required", http.StatusBadRequest)
+		return
+	case line > 0:
+		first, last = line-context, line+context
+	}
+	if _, ok := tab.Files[file]; !ok {
+		http.Error(w, fmt.Sprintf("%s isn't compiled into the binary", file), http.StatusNotFound)
+		return
+	}
+	if first < 1 && last > 0 {
+		first = 1
+	}
+
+	rc, err := v.open(file)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("source not available: %v", err), http.StatusNotFound)
+		return
+	}
+	defer rc.Close()
+	var lines []sourceLine
+	sc := bufio.NewScanner(rc)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		if last > 0 && n > last {
+			break
+		}
+		if n >= first {
+			lines = append(lines, sourceLine{N: n, Text: sc.Text(), Mark: n == line})
+		}
+	}
+	if err := sc.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if responseFormat(w, r) == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, l := range lines {
+			fmt.Fprintf(w, "%6d\t%s\n", l.N, l.Text)
+		}
+		return
+	}
+	info := struct {
+		File, Func string
+		Lines      []sourceLine
+	}{file, fn, lines}
+	if err := sourceTmpl.Execute(w, info); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
+
+// sourceLine is a line of a source file.
+type sourceLine struct {
+	N    int
+	Text string
+	Mark bool // the line asked for
+}
+
+// funcLines returns the file f is declared in and the first and last lines
+// of its body. Lines its instructions come from that belong to other
+// functions, from inlining, are ignored: its body is taken to end before
+// the next function declared in the same file, other than its closures.
+func funcLines(tab *gosym.Table, f *gosym.Func) (file string, first, last int) {
+	file, first, _ = tab.PCToLine(f.Entry)
+	next := math.MaxInt
+	for i := range tab.Funcs {
+		g := &tab.Funcs[i]
+		if strings.HasPrefix(g.Name, f.Name+".") {
+			continue
+		}
+		if fl, l, _ := tab.PCToLine(g.Entry); fl == file && l > first && l < next {
+			next = l
+		}
+	}
+	last = first
+	for pc := f.Entry; pc < f.End; pc++ {
+		if fl, l, _ := tab.PCToLine(pc); fl == file && l > last && l < next {
+			last = l
+		}
+	}
+	return file, first, last
+}
+
+var sourceTmpl = template.Must(template.New("source").Funcs(pageFuncs).Parse(`<html>
+  <head>
+    <title>{{with .Func}}{{.}}{{else}}{{.File}}{{end}}</title>
+    {{pageHead}}
+  </head>
+  <body>
+    {{with .Func}}{{.}} in {{end}}{{.File}}:<br>
+    <table>
+    {{range .Lines}}
+      <tr id="L{{.N}}"{{if .Mark}} style="background: #ff0"{{end}}><td align=right><a href="#L{{.N}}">{{.N}}</a><td><pre style="margin: 0">{{.Text}}</pre>
+    {{end}}
+    </table>
+  </body>
+</html>`))
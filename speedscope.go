@@ -0,0 +1,99 @@
+package netbug
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+)
+
+// speedscopeSchema identifies speedscope's file format, which is
+// described at https://www.speedscope.app/file-format-schema.json.
+const speedscopeSchema = "https://www.speedscope.app/file-format-schema.json"
+
+// speedscopeFile is a file in speedscope's format.
+type speedscopeFile struct {
+	Schema             string              `json:"$schema"`
+	Shared             speedscopeShared    `json:"shared"`
+	Profiles           []speedscopeProfile `json:"profiles"`
+	Name               string              `json:"name"`
+	ActiveProfileIndex int                 `json:"activeProfileIndex"`
+	Exporter           string              `json:"exporter"`
+}
+
+// speedscopeShared is what the profiles in a speedscope file share.
+type speedscopeShared struct {
+	Frames []speedscopeFrame `json:"frames"`
+}
+
+// speedscopeFrame is a function in a speedscope file.
+type speedscopeFrame struct {
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+}
+
+// speedscopeProfile is a sampled profile in a speedscope file.
+type speedscopeProfile struct {
+	Type       string  `json:"type"`
+	Name       string  `json:"name"`
+	Unit       string  `json:"unit"`
+	StartValue int64   `json:"startValue"`
+	EndValue   int64   `json:"endValue"`
+	Samples    [][]int `json:"samples"` // indexes of frames, outermost first
+	Weights    []int64 `json:"weights"`
+}
+
+// speedscopeUnit returns the speedscope unit for unit, a pprof one.
+func speedscopeUnit(unit string) string {
+	switch unit {
+	case "nanoseconds", "bytes":
+		return unit
+	}
+	return "none"
+}
+
+// writeSpeedscope writes p, the profile called name, in speedscope's
+// format, with a profile for each of its sample types, the one named by
+// the sample_index parameter shown first, so that it can be opened at
+// https://www.speedscope.app.
+func writeSpeedscope(w io.Writer, p *profileData, name string, params url.Values) error {
+	si, err := p.sampleIndex(params.Get("sample_index"))
+	if err != nil {
+		return err
+	}
+	f := speedscopeFile{
+		Schema:             speedscopeSchema,
+		Shared:             speedscopeShared{Frames: []speedscopeFrame{}},
+		Name:               name,
+		ActiveProfileIndex: si,
+		Exporter:           "netbug " + netbugVersion(),
+	}
+	frames := make(map[speedscopeFrame]int)
+	stacks := make([][]int, len(p.Samples))
+	for i, s := range p.Samples {
+		stack := make([]int, len(s.Stack))
+		for j, pf := range s.Stack {
+			sf := speedscopeFrame{Name: pf.Func, File: pf.File}
+			k, ok := frames[sf]
+			if !ok {
+				k = len(f.Shared.Frames)
+				frames[sf] = k
+				f.Shared.Frames = append(f.Shared.Frames, sf)
+			}
+			stack[len(stack)-1-j] = k
+		}
+		stacks[i] = stack
+	}
+	for t, st := range p.SampleTypes {
+		sp := speedscopeProfile{Type: "sampled", Name: name + " " + st.Type, Unit: speedscopeUnit(st.Unit), Samples: [][]int{}, Weights: []int64{}}
+		for i, s := range p.Samples {
+			if t >= len(s.Values) || s.Values[t] == 0 {
+				continue
+			}
+			sp.Samples = append(sp.Samples, stacks[i])
+			sp.Weights = append(sp.Weights, s.Values[t])
+			sp.EndValue += abs(s.Values[t])
+		}
+		f.Profiles = append(f.Profiles, sp)
+	}
+	return json.NewEncoder(w).Encode(f)
+}
@@ -0,0 +1,27 @@
+// Package starlarkreport defines netbug reports with Starlark scripts, so
+// that operators can add analyses specific to their organisation without
+// forking netbug.
+//
+// A script defines a function called report, which is given the
+// netbug.ReportInput as a Starlark value, decoded from JSON, and returns
+// either a string, served as text, or a value that is served as JSON:
+//
+//	def report(data):
+//	    blocked = [g for g in data["goroutines"] if g["wait_seconds"] > 600]
+//	    return {"blocked": len(blocked), "goroutines": len(data["goroutines"])}
+//
+// Scripts can use the json module. They run in Starlark's sandbox, with no
+// access to the file system or network, and are limited in how long they
+// run for. Load a script and serve it at <prefix>reports/blocked with:
+//
+//	r, err := starlarkreport.LoadFile("blocked.star")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	netbug.RegisterHandler("/myroute/", mux, netbug.WithReport("blocked", r))
+//
+// It uses go.starlark.net, which netbug doesn't otherwise depend on, so
+// it is a module of its own:
+//
+//	$ go get github.com/e-dard/netbug/starlarkreport
+package starlarkreport
@@ -0,0 +1,73 @@
+package starlarkreport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/e-dard/netbug"
+	starjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+)
+
+// maxSteps limits how long a report runs for, counted in Starlark
+// computation steps.
+const maxSteps = 100_000_000
+
+// LoadFile loads the script in the file filename. See Load.
+func LoadFile(filename string) (netbug.Report, error) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Load(filename, src)
+}
+
+// Load loads the script src, whose file name, for error messages, is
+// filename, and returns a report that calls its report function.
+func Load(filename string, src []byte) (netbug.Report, error) {
+	predeclared := starlark.StringDict{"json": starjson.Module}
+	thread := &starlark.Thread{Name: "load " + filename}
+	thread.SetMaxExecutionSteps(maxSteps)
+	globals, err := starlark.ExecFile(thread, filename, src, predeclared)
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := globals["report"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("starlarkreport: %s doesn't define a report function", filename)
+	}
+	globals.Freeze()
+
+	decode := starjson.Module.Members["decode"]
+	encode := starjson.Module.Members["encode"]
+	return func(w http.ResponseWriter, in *netbug.ReportInput) error {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		thread := &starlark.Thread{Name: filename}
+		thread.SetMaxExecutionSteps(maxSteps)
+		data, err := starlark.Call(thread, decode, starlark.Tuple{starlark.String(b)}, nil)
+		if err != nil {
+			return err
+		}
+		v, err := starlark.Call(thread, fn, starlark.Tuple{data}, nil)
+		if err != nil {
+			return err
+		}
+		if s, ok := v.(starlark.String); ok {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, err := fmt.Fprintln(w, string(s))
+			return err
+		}
+		out, err := starlark.Call(thread, encode, starlark.Tuple{v}, nil)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, err = fmt.Fprintln(w, string(out.(starlark.String)))
+		return err
+	}, nil
+}
@@ -0,0 +1,138 @@
+package netbug
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// started approximates when the process started.
+var started = time.Now()
+
+// instanceStats is a summary of an instance's runtime state, served as
+// stats.json so that instances can be compared with each other.
+type instanceStats struct {
+	SchemaVersion int `json:"schema_version"`
+
+	Instance    string     `json:"instance"`
+	Version     string     `json:"version,omitempty"`
+	Revision    string     `json:"revision,omitempty"`
+	Modified    bool       `json:"modified,omitempty"`
+	GoVersion   string     `json:"go_version"`
+	Started     time.Time  `json:"started"`
+	Uptime      float64    `json:"uptime_seconds"`
+	Goroutines  int        `json:"goroutines"`
+	HeapInUse   uint64     `json:"heap_inuse_bytes"`
+	RSS         uint64     `json:"rss_bytes,omitempty"`
+	GOMAXPROCS  int        `json:"gomaxprocs"`
+	LastCapture *time.Time `json:"last_capture,omitempty"`
+}
+
+// currentStats returns the runtime state of the local instance.
+func (d *Debugger) currentStats() instanceStats {
+	s := instanceStats{
+		SchemaVersion: schemaVersion,
+		Instance:      hostname(),
+		GoVersion:     runtime.Version(),
+		Started:       started,
+		Uptime:        time.Since(started).Seconds(),
+		Goroutines:    runtime.NumGoroutine(),
+		HeapInUse:     heapInUse(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		s.Version = bi.Main.Version
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				s.Revision = setting.Value
+			case "vcs.modified":
+				s.Modified = setting.Value == "true"
+			}
+		}
+	}
+	if rss, err := residentSetSize(); err == nil {
+		s.RSS = rss
+	}
+	if as, err := d.store.List(); err == nil && len(as) > 0 {
+		s.LastCapture = &as[0].Created
+	}
+	return s
+}
+
+// hostname returns the name of the host, or "localhost" if it can't be
+// determined.
+func hostname() string {
+	h, err := os.Hostname()
+	if err != nil || h == "" {
+		return "localhost"
+	}
+	return h
+}
+
+// stats serves the runtime state of the local instance as JSON.
+func (d *Debugger) stats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(d.currentStats()); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
+
+// formatBytes formats n using binary units, e.g. "12.3 MiB".
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// runtimeSummary is the runtime state shown at the top of the index page,
+// formatted for reading.
+type runtimeSummary struct {
+	GoVersion   string
+	Platform    string
+	Uptime      time.Duration
+	Goroutines  int
+	NumCPU      int
+	GOMAXPROCS  int
+	GOGC        string
+	GOMEMLIMIT  string
+	HeapInUse   string
+	LastGC      time.Time // zero if there hasn't been one
+	LastGCPause time.Duration
+}
+
+// currentRuntimeSummary returns the runtime state of the local instance,
+// for the index page.
+func currentRuntimeSummary() runtimeSummary {
+	rs := currentSettings()
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+	s := runtimeSummary{
+		GoVersion:  runtime.Version(),
+		Platform:   runtime.GOOS + "/" + runtime.GOARCH,
+		Uptime:     time.Since(started).Round(time.Second),
+		Goroutines: runtime.NumGoroutine(),
+		NumCPU:     runtime.NumCPU(),
+		GOMAXPROCS: rs.GOMAXPROCS,
+		GOGC:       formatGCPercent(rs.GCPercent),
+		GOMEMLIMIT: formatMemoryLimit(rs.MemoryLimit),
+		HeapInUse:  formatBytes(heapInUse()),
+		LastGC:     gc.LastGC,
+	}
+	if len(gc.Pause) > 0 {
+		s.LastGCPause = gc.Pause[0]
+	}
+	return s
+}
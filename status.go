@@ -0,0 +1,100 @@
+package netbug
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// statusHistory is the number of recent captures listed by the status
+// endpoint.
+const statusHistory = 10
+
+// runningCapture is a CPU profile, trace or delta profile being captured.
+type runningCapture struct {
+	Profile string    `json:"profile"`
+	Via     string    `json:"via"` // "request", "async" or "job"
+	By      string    `json:"by,omitempty"`
+	Started time.Time `json:"started"`
+	Ends    time.Time `json:"ends"`
+
+	// Remaining is how many seconds are left, set when served.
+	Remaining float64 `json:"remaining_seconds"`
+}
+
+// runningCaptures are the captures in progress.
+type runningCaptures struct {
+	mu   sync.Mutex
+	next int
+	byID map[int]runningCapture
+}
+
+// start records that c has started, returning a function to call when it
+// has finished.
+func (rc *runningCaptures) start(c runningCapture) (done func()) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.byID == nil {
+		rc.byID = make(map[int]runningCapture)
+	}
+	id := rc.next
+	rc.next++
+	rc.byID[id] = c
+	return func() {
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		delete(rc.byID, id)
+	}
+}
+
+// list returns the captures in progress, oldest first.
+func (rc *runningCaptures) list() []runningCapture {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	list := make([]runningCapture, 0, len(rc.byID))
+	for _, c := range rc.byID {
+		c.Remaining = max(time.Until(c.Ends).Seconds(), 0)
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Started.Before(list[j].Started) })
+	return list
+}
+
+// startCapture records the start of the capture of name, lasting dur, made
+// via the request r, returning a function to call when it has finished.
+func (d *Debugger) startCapture(r *http.Request, name, via string, dur time.Duration) (done func()) {
+	c := runningCapture{Profile: name, Via: via, Started: time.Now()}
+	c.Ends = c.Started.Add(dur)
+	if p, ok := PrincipalFrom(r.Context()); ok {
+		c.By = p.String()
+	}
+	return d.running.start(c)
+}
+
+// status serves the captures in progress, so that operators on different
+// terminals don't start a CPU profile while another is running, and the
+// most recent captures kept in the history, as JSON.
+func (d *Debugger) status(w http.ResponseWriter, r *http.Request) {
+	recent, err := d.store.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(recent) > statusHistory {
+		recent = recent[:statusHistory]
+	}
+	if recent == nil {
+		recent = []Artifact{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		SchemaVersion int              `json:"schema_version"`
+		Running       []runningCapture `json:"running"`
+		Recent        []Artifact       `json:"recent"`
+	}{schemaVersion, d.running.list(), recent}); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
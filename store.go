@@ -0,0 +1,159 @@
+package netbug
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by a Store when there is no artifact with the
+// requested ID.
+var ErrNotFound = errors.New("netbug: artifact not found")
+
+// An Artifact describes a profile captured by a Debugger, rather than
+// served directly in response to a request.
+type Artifact struct {
+	// ID uniquely identifies the artifact within its Store.
+	ID string `json:"id"`
+
+	// Profile is the name of the captured profile, such as "heap" or
+	// "profile" for a CPU profile.
+	Profile string `json:"profile"`
+
+	// Debug is the debug level the profile was written with. Zero means
+	// the gzipped protocol buffer format understood by go tool pprof.
+	Debug int `json:"debug,omitempty"`
+
+	// Duration is the length of CPU profiles and traces.
+	Duration time.Duration `json:"duration,omitempty"`
+
+	// Trigger describes what caused the capture, such as "schedule".
+	Trigger string `json:"trigger"`
+
+	// Host and Service are the host and program the artifact was
+	// captured on, and Version the VCS revision or module version the
+	// program was built from, if known.
+	Host    string `json:"host,omitempty"`
+	Service string `json:"service,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	// Labels are those the Debugger was configured WithLabels.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Created is when the capture completed.
+	Created time.Time `json:"created"`
+
+	// Size is the size of the artifact in bytes.
+	Size int64 `json:"size"`
+
+	// SHA256 is the hex-encoded SHA-256 checksum of the artifact.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// A Store holds the artifacts captured by a Debugger. Implementations must
+// be safe for concurrent use.
+type Store interface {
+	// Put stores data as the artifact described by a. a.ID is already
+	// set.
+	Put(a Artifact, data []byte) error
+
+	// List returns the stored artifacts, newest first.
+	List() ([]Artifact, error)
+
+	// Open returns the artifact with the given ID and its contents. It
+	// returns ErrNotFound if there is no such artifact.
+	Open(id string) (Artifact, io.ReadCloser, error)
+
+	// Delete removes the artifact with the given ID.
+	Delete(id string) error
+}
+
+// WithStore sets the Store that captured profiles are kept in. By
+// default they are kept in memory, in a store returned by NewMemoryStore.
+func WithStore(s Store) Option {
+	return func(d *Debugger) {
+		d.store = s
+	}
+}
+
+// newArtifactID returns a random ID for an artifact.
+func newArtifactID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// Fall back to something unique enough for a single process.
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
+
+// defaultMemoryArtifacts is the number of artifacts the default store
+// holds before discarding the oldest.
+const defaultMemoryArtifacts = 32
+
+// MemoryStore is a Store that holds artifacts in memory.
+type MemoryStore struct {
+	max int
+
+	mu        sync.Mutex
+	artifacts []Artifact // oldest first
+	data      map[string][]byte
+}
+
+// NewMemoryStore returns a Store that holds up to max artifacts in memory,
+// discarding the oldest when full.
+func NewMemoryStore(max int) *MemoryStore {
+	return &MemoryStore{max: max, data: make(map[string][]byte)}
+}
+
+// Put implements Store.
+func (s *MemoryStore) Put(a Artifact, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.artifacts = append(s.artifacts, a)
+	s.data[a.ID] = data
+	for len(s.artifacts) > s.max {
+		delete(s.data, s.artifacts[0].ID)
+		s.artifacts = s.artifacts[1:]
+	}
+	return nil
+}
+
+// List implements Store.
+func (s *MemoryStore) List() ([]Artifact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	as := append([]Artifact(nil), s.artifacts...)
+	sort.SliceStable(as, func(i, j int) bool { return as[i].Created.After(as[j].Created) })
+	return as, nil
+}
+
+// Open implements Store.
+func (s *MemoryStore) Open(id string) (Artifact, io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range s.artifacts {
+		if a.ID == id {
+			return a, io.NopCloser(bytes.NewReader(s.data[id])), nil
+		}
+	}
+	return Artifact{}, nil, ErrNotFound
+}
+
+// Delete implements Store.
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, a := range s.artifacts {
+		if a.ID == id {
+			s.artifacts = append(s.artifacts[:i], s.artifacts[i+1:]...)
+			delete(s.data, id)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
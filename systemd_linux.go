@@ -0,0 +1,57 @@
+package netbug
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation.
+const listenFDsStart = 3
+
+// SystemdListener returns a listener passed to the process by systemd
+// socket activation, so that the standalone debug server can be started
+// on demand, and its port configured in a systemd socket unit rather than
+// by the application:
+//
+//	l, err := netbug.SystemdListener("debug")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	go func() {
+//		log.Fatal(netbug.Serve(l))
+//	}()
+//
+// name is the socket's FileDescriptorName in the unit. If name is empty,
+// the first socket passed is returned.
+func SystemdListener(name string) (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, errors.New("netbug: no sockets were passed by systemd")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, errors.New("netbug: no sockets were passed by systemd")
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < n; i++ {
+		if name != "" && (i >= len(names) || names[i] != name) {
+			continue
+		}
+		fd := listenFDsStart + i
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), "systemd:"+name)
+		// FileListener dups the descriptor.
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("netbug: systemd socket %d: %v", fd, err)
+		}
+		return l, nil
+	}
+	return nil, fmt.Errorf("netbug: systemd passed no socket named %q", name)
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package netbug
+
+import (
+	"errors"
+	"net"
+)
+
+// SystemdListener returns a listener passed to the process by systemd
+// socket activation. name is the socket's FileDescriptorName in the unit.
+// If name is empty, the first socket passed is returned.
+func SystemdListener(name string) (net.Listener, error) {
+	return nil, errors.New("netbug: systemd socket activation is not available on this platform")
+}
@@ -0,0 +1,159 @@
+package netbug
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// WithRequireTLS rejects requests that weren't made over TLS, so that
+// tokens and profiles are never sent in the clear. Behind a proxy that
+// terminates TLS, use WithTrustedProxyHeaders too.
+func WithRequireTLS() Option {
+	return func(d *Debugger) {
+		d.requireTLS = true
+	}
+}
+
+// WithTrustedProxyHeaders trusts the X-Forwarded-Proto header to say
+// whether a request was made over TLS to a proxy in front of the
+// Debugger, for WithRequireTLS and for marking WithOIDC's cookies secure.
+// Only use it behind a proxy that overwrites the header, as clients can
+// otherwise set it themselves.
+func WithTrustedProxyHeaders() Option {
+	return func(d *Debugger) {
+		d.trustProxyHeaders = true
+	}
+}
+
+// isTLS reports whether r was made over TLS to this process or, if d
+// trusts proxy headers, to a proxy in front of it.
+func (d *Debugger) isTLS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return d.trustProxyHeaders && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// SelfSignedCertificate generates a self-signed certificate for the
+// provided hosts, which may be names or IP addresses, valid for a year.
+// It is meant for the standalone debug server, where clients trust the
+// certificate itself, by its fingerprint or by passing it to go tool
+// pprof with -tls_ca, rather than a certificate authority.
+func SelfSignedCertificate(hosts ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"netbug"}, CommonName: hostname()},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		// Being its own CA lets the certificate be passed to -tls_ca.
+		IsCA: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// CertificateFingerprint returns the SHA-256 fingerprint of cert's leaf
+// certificate, formatted as by openssl x509 -fingerprint -sha256, for
+// pinning.
+func CertificateFingerprint(cert tls.Certificate) string {
+	if len(cert.Certificate) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(cert.Certificate[0])
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hex, ":")
+}
+
+// ListenAndServeTLS listens on the TCP address addr, or a socket passed
+// by systemd as with ListenAndServe, and serves d over TLS with cert, as
+// Serve does.
+func (d *Debugger) ListenAndServeTLS(ctx context.Context, addr string, cert tls.Certificate) error {
+	l, err := listen(addr)
+	if err != nil {
+		return err
+	}
+	return d.Serve(ctx, tls.NewListener(l, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}))
+}
+
+// ListenAndServeSelfSigned starts a standalone debug server on the TCP
+// address addr, serving HTTPS with a newly generated self-signed
+// certificate, configured with the provided options as with New. The
+// certificate's fingerprint is logged, for pinning, and if caFile isn't
+// empty the certificate is written to it, so that go tool pprof can
+// verify the server without -insecure_skip_verify:
+//
+//	$ go tool pprof -tls_ca=netbug.pem https://host:6060/profile
+//
+// The certificate is valid for the host in addr, the machine's hostname
+// and the loopback addresses. Otherwise ListenAndServeSelfSigned is the
+// same as ListenAndServe.
+func ListenAndServeSelfSigned(addr, caFile string, opts ...Option) error {
+	hosts := []string{hostname(), "localhost", "127.0.0.1", "::1"}
+	if h, _, err := net.SplitHostPort(addr); err == nil && h != "" && !strings.HasPrefix(addr, systemdPrefix) {
+		hosts = append(hosts, h)
+	}
+	cert, err := SelfSignedCertificate(hosts...)
+	if err != nil {
+		return err
+	}
+	if caFile != "" {
+		b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+		if err := os.WriteFile(caFile, b, 0644); err != nil {
+			return err
+		}
+	}
+	d := New(opts...)
+	d.logf(slog.LevelInfo, "netbug: serving HTTPS on %s with a self-signed certificate, SHA-256 fingerprint %s", addr, CertificateFingerprint(cert))
+	if err := d.Start(); err != nil {
+		return err
+	}
+	defer d.Stop()
+	return d.ListenAndServeTLS(context.Background(), addr, cert)
+}
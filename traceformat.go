@@ -0,0 +1,38 @@
+package netbug
+
+import (
+	"io"
+	"sort"
+)
+
+// A TraceConverter converts an execution trace, as served at trace, to
+// another format.
+type TraceConverter func(w io.Writer, trace io.Reader) error
+
+// WithTraceFormat serves execution traces converted by convert, as
+// contentType, when asked for with format=name, as at
+// trace?seconds=5&format=name and history/<id>?format=name. If ext isn't
+// empty, they are served for download, with that extension.
+//
+// netbug has no execution trace parser of its own, as the runtime's is
+// internal; the tracenetbug package converts traces to speedscope's format
+// using golang.org/x/exp/trace.
+func WithTraceFormat(name, contentType, ext string, convert TraceConverter) Option {
+	return func(d *Debugger) {
+		if d.traceFormats == nil {
+			d.traceFormats = make(map[string]profileFormat)
+		}
+		d.traceFormats[name] = profileFormat{contentType: contentType, ext: ext, convert: convert}
+	}
+}
+
+// traceFormatNames returns the names of the formats d serves execution
+// traces in, besides their own, sorted.
+func (d *Debugger) traceFormatNames() []string {
+	var names []string
+	for name := range d.traceFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,15 @@
+// Package tracenetbug converts execution traces to speedscope's format,
+// so that they can be dragged into https://www.speedscope.app rather than
+// opened with go tool trace, which needs Go installed:
+//
+//	d := netbug.New(netbug.WithToken("open sesame"), tracenetbug.WithSpeedscope())
+//
+// and download trace?seconds=5&format=speedscope. Each goroutine is a
+// profile of what it was doing over time: running, runnable, in a system
+// call or waiting, and where.
+//
+// It uses golang.org/x/exp/trace, which netbug doesn't otherwise depend
+// on, so it is a module of its own:
+//
+//	$ go get github.com/e-dard/netbug/tracenetbug
+package tracenetbug
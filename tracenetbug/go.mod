@@ -0,0 +1,9 @@
+module github.com/e-dard/netbug/tracenetbug
+
+go 1.26.0
+
+require github.com/e-dard/netbug v0.0.0
+
+require golang.org/x/exp v0.0.0-20260908205506-85c1c2202aba
+
+replace github.com/e-dard/netbug => ../
@@ -0,0 +1,211 @@
+package tracenetbug
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/e-dard/netbug"
+	"golang.org/x/exp/trace"
+)
+
+// WithSpeedscope serves execution traces in speedscope's format, converted
+// by Speedscope, with format=speedscope.
+func WithSpeedscope() netbug.Option {
+	return netbug.WithTraceFormat("speedscope", "application/json", ".speedscope.json", Speedscope)
+}
+
+// Speedscope converts the execution trace read from r to speedscope's
+// format, written to w, with an evented profile for each goroutine. Each
+// span of time a goroutine spends in one state is drawn under a frame
+// naming the state, such as "waiting: chan receive", with the stack it
+// was in: where it stopped, for time spent running, or where it blocked,
+// for time spent waiting.
+func Speedscope(w io.Writer, r io.Reader) error {
+	tr, err := trace.NewReader(r)
+	if err != nil {
+		return err
+	}
+	c := &converter{frames: make(map[frame]int), goroutines: make(map[trace.GoID]*goroutine)}
+	for {
+		ev, err := tr.ReadEvent()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+		if !c.started {
+			c.start, c.started = ev.Time(), true
+		}
+		c.end = ev.Time()
+		if ev.Kind() != trace.EventStateTransition {
+			continue
+		}
+		st := ev.StateTransition()
+		if st.Resource.Kind != trace.ResourceGoroutine {
+			continue
+		}
+		id := st.Resource.Goroutine()
+		from, to := st.Goroutine()
+		if from == to {
+			continue
+		}
+		stack := st.Stack
+		if stack == trace.NoStack && ev.Goroutine() == id {
+			stack = ev.Stack()
+		}
+		c.transition(id, ev.Time(), to, st.Reason, stack)
+	}
+	return json.NewEncoder(w).Encode(c.file())
+}
+
+// file is a file in speedscope's format, described by
+// https://www.speedscope.app/file-format-schema.json.
+type file struct {
+	Schema   string    `json:"$schema"`
+	Shared   shared    `json:"shared"`
+	Profiles []profile `json:"profiles"`
+	Name     string    `json:"name"`
+	Exporter string    `json:"exporter"`
+}
+
+type shared struct {
+	Frames []frame `json:"frames"`
+}
+
+type frame struct {
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+}
+
+type profile struct {
+	Type       string  `json:"type"`
+	Name       string  `json:"name"`
+	Unit       string  `json:"unit"`
+	StartValue int64   `json:"startValue"`
+	EndValue   int64   `json:"endValue"`
+	Events     []event `json:"events"`
+}
+
+type event struct {
+	Type  string `json:"type"` // "O" to open a frame, "C" to close it
+	Frame int    `json:"frame"`
+	At    int64  `json:"at"`
+}
+
+// converter gathers the goroutines and frames of a trace.
+type converter struct {
+	start, end trace.Time
+	started    bool
+	frames     map[frame]int
+	shared     shared
+	goroutines map[trace.GoID]*goroutine
+}
+
+// goroutine is a goroutine's profile, and the span of time it is
+// currently in.
+type goroutine struct {
+	events []event
+	state  trace.GoState
+	reason string
+	stack  trace.Stack
+	since  trace.Time
+}
+
+// transition records that goroutine id entered state to at t, for reason,
+// with stack.
+func (c *converter) transition(id trace.GoID, t trace.Time, to trace.GoState, reason string, stack trace.Stack) {
+	g := c.goroutines[id]
+	if g == nil {
+		g = &goroutine{}
+		c.goroutines[id] = g
+	}
+	c.finish(g, t, stack)
+	g.state, g.reason, g.stack, g.since = to, reason, stack, t
+}
+
+// finish ends g's current span at t, when it was in stack.
+func (c *converter) finish(g *goroutine, t trace.Time, stack trace.Stack) {
+	var name string
+	switch g.state {
+	case trace.GoRunning:
+		name = "running"
+	case trace.GoRunnable:
+		name = "runnable"
+	case trace.GoSyscall:
+		name = "syscall"
+	case trace.GoWaiting:
+		name = "waiting"
+		if g.reason != "" {
+			name += ": " + g.reason
+		}
+	default:
+		return
+	}
+	if g.state != trace.GoRunning {
+		// Where it blocked, rather than where it woke up.
+		stack = g.stack
+	}
+	frames := []int{c.frame(frame{Name: name})}
+	var inner []int
+	for f := range stack.Frames() {
+		inner = append(inner, c.frame(frame{Name: f.Func, File: f.File}))
+	}
+	for i := len(inner) - 1; i >= 0; i-- {
+		frames = append(frames, inner[i])
+	}
+	from, to := int64(g.since.Sub(c.start)), int64(t.Sub(c.start))
+	for _, f := range frames {
+		g.events = append(g.events, event{Type: "O", Frame: f, At: from})
+	}
+	for i := len(frames) - 1; i >= 0; i-- {
+		g.events = append(g.events, event{Type: "C", Frame: frames[i], At: to})
+	}
+}
+
+// frame returns the index of f in c's frames.
+func (c *converter) frame(f frame) int {
+	i, ok := c.frames[f]
+	if !ok {
+		i = len(c.shared.Frames)
+		c.frames[f] = i
+		c.shared.Frames = append(c.shared.Frames, f)
+	}
+	return i
+}
+
+// file returns c's goroutines as a speedscope file, finishing the spans
+// they are in at the end of the trace.
+func (c *converter) file() file {
+	ids := make([]trace.GoID, 0, len(c.goroutines))
+	for id, g := range c.goroutines {
+		c.finish(g, c.end, g.stack)
+		if len(g.events) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	f := file{
+		Schema:   "https://www.speedscope.app/file-format-schema.json",
+		Shared:   c.shared,
+		Profiles: []profile{},
+		Name:     "trace",
+		Exporter: "netbug",
+	}
+	if f.Shared.Frames == nil {
+		f.Shared.Frames = []frame{}
+	}
+	end := int64(c.end.Sub(c.start))
+	for _, id := range ids {
+		f.Profiles = append(f.Profiles, profile{
+			Type:     "evented",
+			Name:     fmt.Sprintf("goroutine %d", id),
+			Unit:     "nanoseconds",
+			EndValue: end,
+			Events:   c.goroutines[id].events,
+		})
+	}
+	return f
+}
@@ -0,0 +1,185 @@
+package netbug
+
+import (
+	"fmt"
+	"html/template"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+)
+
+// runtimeSettings are the runtime's tuning knobs.
+type runtimeSettings struct {
+	GCPercent   int   // GOGC; negative means off
+	MemoryLimit int64 // GOMEMLIMIT in bytes; math.MaxInt64 means none
+	GOMAXPROCS  int
+}
+
+// startupSettings are the settings when the process started, which the
+// tuning panel can reset to.
+var startupSettings = currentSettings()
+
+// currentSettings returns the runtime's current settings.
+func currentSettings() runtimeSettings {
+	rs := runtimeSettings{
+		GCPercent:   -1,
+		MemoryLimit: debug.SetMemoryLimit(-1), // a negative limit only reads it
+		GOMAXPROCS:  runtime.GOMAXPROCS(0),
+	}
+	// Unlike the memory limit, the GC percent can't be read without
+	// changing it, but runtime/metrics reports it. GOGC=off is reported
+	// as 0.
+	s := []metrics.Sample{{Name: "/gc/gogc:percent"}}
+	metrics.Read(s)
+	if s[0].Value.Kind() == metrics.KindUint64 && s[0].Value.Uint64() > 0 {
+		rs.GCPercent = int(s[0].Value.Uint64())
+	}
+	return rs
+}
+
+// apply changes the runtime's settings to s, returning a description of
+// each change made.
+func (s runtimeSettings) apply() []string {
+	cur := currentSettings()
+	var changes []string
+	if s.GCPercent != cur.GCPercent {
+		debug.SetGCPercent(s.GCPercent)
+		changes = append(changes, fmt.Sprintf("GOGC %s -> %s", formatGCPercent(cur.GCPercent), formatGCPercent(s.GCPercent)))
+	}
+	if s.MemoryLimit != cur.MemoryLimit {
+		debug.SetMemoryLimit(s.MemoryLimit)
+		changes = append(changes, fmt.Sprintf("GOMEMLIMIT %s -> %s", formatMemoryLimit(cur.MemoryLimit), formatMemoryLimit(s.MemoryLimit)))
+	}
+	if s.GOMAXPROCS != cur.GOMAXPROCS {
+		runtime.GOMAXPROCS(s.GOMAXPROCS)
+		changes = append(changes, fmt.Sprintf("GOMAXPROCS %d -> %d", cur.GOMAXPROCS, s.GOMAXPROCS))
+	}
+	return changes
+}
+
+// formatGCPercent formats a GOGC value as the environment variable would
+// be set.
+func formatGCPercent(p int) string {
+	if p < 0 {
+		return "off"
+	}
+	return strconv.Itoa(p)
+}
+
+// formatMemoryLimit formats a GOMEMLIMIT value.
+func formatMemoryLimit(n int64) string {
+	if n == math.MaxInt64 {
+		return "off"
+	}
+	return formatBytes(uint64(n))
+}
+
+// parseSettings returns cur updated with the gcpercent, memlimit and
+// gomaxprocs parameters in r, each of which is optional. gcpercent and
+// memlimit may be "off".
+func parseSettings(r *http.Request, cur runtimeSettings) (runtimeSettings, error) {
+	if v := strings.TrimSpace(r.FormValue("gcpercent")); v == "off" {
+		cur.GCPercent = -1
+	} else if v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			return cur, fmt.Errorf("invalid gcpercent: %q", v)
+		}
+		cur.GCPercent = p
+	}
+	if v := strings.TrimSpace(r.FormValue("memlimit")); v == "off" {
+		cur.MemoryLimit = math.MaxInt64
+	} else if v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return cur, fmt.Errorf("invalid memlimit: %q", v)
+		}
+		cur.MemoryLimit = n
+	}
+	if v := strings.TrimSpace(r.FormValue("gomaxprocs")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return cur, fmt.Errorf("invalid gomaxprocs: %q", v)
+		}
+		cur.GOMAXPROCS = n
+	}
+	return cur, nil
+}
+
+// tuning serves a panel for viewing and changing the runtime's GC percent,
+// memory limit and GOMAXPROCS, for experimenting with them on a live
+// instance. A POST with the gcpercent, memlimit or gomaxprocs parameters
+// changes them, and one with reset=1 restores the settings the process
+// started with. Every change is logged, and recorded in the audit log,
+// along with who made it.
+func (d *Debugger) tuning(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		want := startupSettings
+		if r.FormValue("reset") != "1" {
+			var err error
+			if want, err = parseSettings(r, currentSettings()); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		who := r.RemoteAddr
+		if p, ok := PrincipalFrom(r.Context()); ok {
+			who = p.String() + " from " + who
+		}
+		for _, c := range want.apply() {
+			d.logf(slog.LevelInfo, "netbug: runtime tuning: %s by %s", c, who)
+			d.auditChange(r, "runtime tuning: "+c)
+		}
+
+		loc := "runtime"
+		if tok := d.linkToken(r); tok != "" {
+			loc += "?token=" + url.QueryEscape(tok)
+		}
+		redirect(w, loc, http.StatusSeeOther)
+		return
+	}
+
+	cur := currentSettings()
+	info := struct {
+		Current, Startup runtimeSettings
+		NumCPU           int
+		Token            string
+	}{cur, startupSettings, runtime.NumCPU(), d.linkToken(r)}
+	if err := tuningTmpl.Execute(w, info); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
+
+var tuningTmpl = template.Must(template.New("tuning").Funcs(pageFuncs).Funcs(template.FuncMap{
+	"gcpercent": formatGCPercent,
+	"memlimit":  formatMemoryLimit,
+}).Parse(`<html>
+  <head>
+    <title>Runtime Tuning</title>
+    {{pageHead}}
+  </head>
+  <body>
+    <form method="post" action="runtime{{if .Token}}?token={{.Token}}{{end}}">
+    <table>
+      <tr><th align=left>setting<th align=left>current<th align=left>at startup<th align=left>new value
+      <tr><td>GOGC<td>{{gcpercent .Current.GCPercent}}<td>{{gcpercent .Startup.GCPercent}}
+        <td><input type="text" name="gcpercent" size=10> (percent, or off)
+      <tr><td>GOMEMLIMIT<td>{{memlimit .Current.MemoryLimit}}<td>{{memlimit .Startup.MemoryLimit}}
+        <td><input type="text" name="memlimit" size=10> (bytes, or off)
+      <tr><td>GOMAXPROCS<td>{{.Current.GOMAXPROCS}}<td>{{.Startup.GOMAXPROCS}}
+        <td><input type="text" name="gomaxprocs" size=10> ({{.NumCPU}} CPUs)
+    </table>
+    <input type="submit" value="apply">
+    </form>
+    <form method="post" action="runtime{{if .Token}}?token={{.Token}}{{end}}">
+      <input type="hidden" name="reset" value="1">
+      <input type="submit" value="reset to startup settings">
+    </form>
+  </body>
+</html>`))
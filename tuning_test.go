@@ -0,0 +1,33 @@
+package netbug
+
+import (
+	"bytes"
+	"log/slog"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+// TestTuningLogged checks that changes to the runtime's settings are
+// logged to the Debugger's logger and recorded in its audit log, with the
+// principal that made them.
+func TestTuningLogged(t *testing.T) {
+	defer debug.SetGCPercent(debug.SetGCPercent(100))
+
+	var logged, audited bytes.Buffer
+	d := New(
+		WithToken("secret"),
+		WithLogger(slog.New(slog.NewTextHandler(&logged, nil))),
+		WithAuditLog(slog.New(slog.NewTextHandler(&audited, nil))),
+	)
+	if w := serveToken(d, "POST", "control/runtime?gcpercent=150", "secret"); w.Code != 303 {
+		t.Fatalf("POST /control/runtime: %d %s", w.Code, w.Body)
+	}
+	by := tokenPrincipal("secret").String()
+	if s := logged.String(); !strings.Contains(s, "GOGC 100 -> 150 by "+by) {
+		t.Errorf("change not logged with %s:\n%s", by, s)
+	}
+	if s := audited.String(); !strings.Contains(s, "by="+by) || !strings.Contains(s, `change="runtime tuning: GOGC 100 -> 150"`) {
+		t.Errorf("change not audited with %s:\n%s", by, s)
+	}
+}
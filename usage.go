@@ -0,0 +1,193 @@
+package netbug
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// usageBuckets are the upper bounds, in seconds, of the buckets of the
+// request duration histogram, from quick snapshots to long traces.
+var usageBuckets = []float64{0.01, 0.1, 1, 5, 10, 30, 60, 120, 300}
+
+// WithUsageMetrics serves metrics about the use of the Debugger itself at
+// <prefix>metrics, in the Prometheus text format, so that unexpected use
+// of the debug endpoints can be alerted on:
+//
+//   - netbug_requests_total, the requests made to each endpoint, by
+//     status code;
+//   - netbug_request_duration_seconds, a histogram of how long requests
+//     to each endpoint took, such as how long CPU profiles ran for;
+//   - netbug_response_bytes_total, the bytes served by each endpoint;
+//   - netbug_requests_in_flight, the requests to each endpoint in
+//     progress, such as concurrent profile captures;
+//   - netbug_auth_failures_total, the requests that failed to
+//     authenticate.
+//
+// The metrics endpoint is authenticated like the others; use
+// WithPublicEndpoints("metrics") to let a scraper without a token read it.
+func WithUsageMetrics() Option {
+	return func(d *Debugger) {
+		d.usage = &usageMetrics{endpoints: make(map[string]*endpointUsage)}
+	}
+}
+
+// usageMetrics is the metrics served by WithUsageMetrics. A nil
+// usageMetrics records nothing.
+type usageMetrics struct {
+	mu           sync.Mutex
+	endpoints    map[string]*endpointUsage
+	authFailures int64
+}
+
+// endpointUsage is the metrics for one endpoint.
+type endpointUsage struct {
+	requests map[int]int64 // by status code
+	bytes    int64
+	inFlight int64
+	buckets  []int64 // cumulative counts, as with usageBuckets
+	count    int64
+	sum      float64 // seconds
+}
+
+// usageEndpoint returns the endpoint to record a request for name under,
+// as named in the endpoint catalog, so that paths containing IDs, such as
+// goroutines/123, don't each get their own metrics.
+func (d *Debugger) usageEndpoint(name string) string {
+	first, _, _ := strings.Cut(name, "/")
+	switch first {
+	case "history":
+		if name != "history" && name != "history/export" {
+			return "history/{id}"
+		}
+	case "goroutines":
+		if _, err := strconv.ParseInt(strings.TrimPrefix(name, "goroutines/"), 10, 64); err == nil {
+			return "goroutines/{id}"
+		}
+	case "reports":
+		return "reports/{name}"
+	case "pages":
+		return "pages/{name}"
+	case "jobs":
+		if name != "jobs" {
+			return "jobs/{id}"
+		}
+	case "peers":
+		return "peers/{name}/"
+	}
+	for _, m := range d.modules {
+		if first == m.Name() {
+			return m.Name() + "/"
+		}
+	}
+	for _, c := range catalog {
+		if c.Path == name {
+			return name
+		}
+	}
+	if name == "fleet" || pprof.Lookup(name) != nil {
+		return name
+	}
+	return "other"
+}
+
+// endpoint returns the metrics for endpoint, creating them if need be. u.mu
+// must be held.
+func (u *usageMetrics) endpoint(endpoint string) *endpointUsage {
+	e, ok := u.endpoints[endpoint]
+	if !ok {
+		e = &endpointUsage{requests: make(map[int]int64), buckets: make([]int64, len(usageBuckets))}
+		u.endpoints[endpoint] = e
+	}
+	return e
+}
+
+// begin records the start of a request to endpoint.
+func (u *usageMetrics) begin(endpoint string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.endpoint(endpoint).inFlight++
+}
+
+// end records the end of a request to endpoint, which was responded to
+// with status and n bytes after dur.
+func (u *usageMetrics) end(endpoint string, status int, n int64, dur time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	e := u.endpoint(endpoint)
+	e.inFlight--
+	e.requests[status]++
+	e.bytes += n
+	e.count++
+	e.sum += dur.Seconds()
+	for i, le := range usageBuckets {
+		if dur.Seconds() <= le {
+			e.buckets[i]++
+		}
+	}
+}
+
+// authFailure records a request that failed to authenticate.
+func (u *usageMetrics) authFailure() {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.authFailures++
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (u *usageMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var names []string
+	for name := range u.endpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprintln(w, "# HELP netbug_requests_total Requests made to netbug, by endpoint and status code.")
+	fmt.Fprintln(w, "# TYPE netbug_requests_total counter")
+	for _, name := range names {
+		e := u.endpoints[name]
+		var codes []int
+		for code := range e.requests {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Fprintf(w, "netbug_requests_total{endpoint=%q,code=\"%d\"} %d\n", name, code, e.requests[code])
+		}
+	}
+	fmt.Fprintln(w, "# HELP netbug_request_duration_seconds How long requests to netbug took, by endpoint.")
+	fmt.Fprintln(w, "# TYPE netbug_request_duration_seconds histogram")
+	for _, name := range names {
+		e := u.endpoints[name]
+		for i, le := range usageBuckets {
+			fmt.Fprintf(w, "netbug_request_duration_seconds_bucket{endpoint=%q,le=\"%g\"} %d\n", name, le, e.buckets[i])
+		}
+		fmt.Fprintf(w, "netbug_request_duration_seconds_bucket{endpoint=%q,le=\"+Inf\"} %d\n", name, e.count)
+		fmt.Fprintf(w, "netbug_request_duration_seconds_sum{endpoint=%q} %g\n", name, e.sum)
+		fmt.Fprintf(w, "netbug_request_duration_seconds_count{endpoint=%q} %d\n", name, e.count)
+	}
+	fmt.Fprintln(w, "# HELP netbug_response_bytes_total Bytes served by netbug, by endpoint.")
+	fmt.Fprintln(w, "# TYPE netbug_response_bytes_total counter")
+	for _, name := range names {
+		fmt.Fprintf(w, "netbug_response_bytes_total{endpoint=%q} %d\n", name, u.endpoints[name].bytes)
+	}
+	fmt.Fprintln(w, "# HELP netbug_requests_in_flight Requests to netbug in progress, by endpoint.")
+	fmt.Fprintln(w, "# TYPE netbug_requests_in_flight gauge")
+	for _, name := range names {
+		fmt.Fprintf(w, "netbug_requests_in_flight{endpoint=%q} %d\n", name, u.endpoints[name].inFlight)
+	}
+	fmt.Fprintln(w, "# HELP netbug_auth_failures_total Requests to netbug that failed to authenticate.")
+	fmt.Fprintln(w, "# TYPE netbug_auth_failures_total counter")
+	fmt.Fprintf(w, "netbug_auth_failures_total %d\n", u.authFailures)
+}
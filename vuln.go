@@ -0,0 +1,633 @@
+package netbug
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// vulnCacheFor is how long the result of a vulnerability check is
+	// reused before the modules are checked again.
+	vulnCacheFor = time.Hour
+
+	// vulnRetryAfter is how long a check that failed is reused before
+	// the modules are checked again.
+	vulnRetryAfter = time.Minute
+
+	// vulnRefreshEvery is how often the refresh parameter can start a
+	// check.
+	vulnRefreshEvery = time.Minute
+
+	// vulnCheckTimeout bounds a single check.
+	vulnCheckTimeout = time.Minute
+)
+
+// WithOSVSnapshot enables the debug/vulns page, which reports the modules
+// compiled into the binary that are affected by known vulnerabilities.
+//
+// Vulnerabilities are looked up in a local snapshot of an OSV database,
+// so no network access is needed. path is either a directory containing
+// OSV JSON entries (optionally gzipped), such as an unpacked copy of
+// https://vuln.go.dev, or a zip archive of them, such as the Go ecosystem
+// export from https://osv-vulnerabilities.storage.googleapis.com/Go/all.zip.
+func WithOSVSnapshot(path string) Option {
+	return func(d *Debugger) {
+		d.vulns = &vulnChecker{source: &osvSnapshot{path: path}, logf: d.logf}
+	}
+}
+
+// WithOSVAPI enables the debug/vulns page, looking vulnerabilities up
+// using the OSV API at url, e.g. "https://api.osv.dev". An empty url uses
+// https://api.osv.dev.
+//
+// Unlike WithOSVSnapshot this requires network access, and sends the
+// paths and versions of the binary's modules to the API.
+func WithOSVAPI(url string) Option {
+	if url == "" {
+		url = "https://api.osv.dev"
+	}
+	return func(d *Debugger) {
+		d.vulns = &vulnChecker{source: &osvAPI{url: strings.TrimSuffix(url, "/")}, logf: d.logf}
+	}
+}
+
+// osvEntry is the subset of the OSV schema that netbug uses.
+type osvEntry struct {
+	ID        string   `json:"id"`
+	Summary   string   `json:"summary"`
+	Details   string   `json:"details"`
+	Aliases   []string `json:"aliases"`
+	Withdrawn string   `json:"withdrawn"`
+	Affected  []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		Ranges []struct {
+			Type   string `json:"type"`
+			Events []struct {
+				Introduced   string `json:"introduced"`
+				Fixed        string `json:"fixed"`
+				LastAffected string `json:"last_affected"`
+			} `json:"events"`
+		} `json:"ranges"`
+	} `json:"affected"`
+}
+
+// affects reports whether the entry affects version v of the Go module
+// mod, and if so the version the vulnerability was fixed in, if any.
+func (e *osvEntry) affects(mod, v string) (bool, string) {
+	for _, a := range e.Affected {
+		if a.Package.Ecosystem != "Go" || a.Package.Name != mod {
+			continue
+		}
+		for _, r := range a.Ranges {
+			if r.Type != "SEMVER" {
+				continue
+			}
+			affected, fixed := false, ""
+			for _, ev := range r.Events {
+				switch {
+				case ev.Introduced != "":
+					if ev.Introduced == "0" || compareSemver(v, ev.Introduced) >= 0 {
+						affected = true
+					}
+				case ev.Fixed != "":
+					if compareSemver(v, ev.Fixed) >= 0 {
+						affected = false
+					} else if affected && fixed == "" {
+						fixed = ev.Fixed
+					}
+				case ev.LastAffected != "":
+					if compareSemver(v, ev.LastAffected) > 0 {
+						affected = false
+					}
+				}
+			}
+			if affected {
+				return true, fixed
+			}
+		}
+	}
+	return false, ""
+}
+
+// vulnModule is a module to be checked for vulnerabilities.
+type vulnModule struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+}
+
+// vulnFinding is a vulnerability affecting a module in the binary.
+type vulnFinding struct {
+	Module  string   `json:"module"`
+	Version string   `json:"version"`
+	ID      string   `json:"id"`
+	Aliases []string `json:"aliases,omitempty"`
+	Summary string   `json:"summary,omitempty"`
+	Fixed   string   `json:"fixed,omitempty"`
+}
+
+// vulnSource looks up the vulnerabilities affecting a set of modules.
+type vulnSource interface {
+	check(ctx context.Context, mods []vulnModule) ([]vulnFinding, error)
+	String() string
+}
+
+// vulnChecker serves the debug/vulns page, caching the result of checking
+// the binary's modules against its source. Checks run in the background,
+// so that a slow source doesn't hold up requests, which are served the
+// last result while a check is running, or wait for the first.
+type vulnChecker struct {
+	source vulnSource
+	logf   func(level slog.Level, format string, args ...interface{})
+
+	mu        sync.Mutex
+	checked   time.Time
+	modules   []vulnModule
+	findings  []vulnFinding
+	err       error
+	checking  chan struct{} // closed when the running check is done; nil if none is
+	refreshed time.Time     // when the refresh parameter last started a check
+}
+
+// check starts checking the binary's modules, unless a check is already
+// running, and returns a channel that is closed when it is done. c.mu
+// must be held.
+func (c *vulnChecker) check() <-chan struct{} {
+	if c.checking != nil {
+		return c.checking
+	}
+	done := make(chan struct{})
+	c.checking = done
+	go func() {
+		defer close(done)
+		ctx, cancel := context.WithTimeout(context.Background(), vulnCheckTimeout)
+		defer cancel()
+		mods := buildModules()
+		findings, err := c.source.check(ctx, mods)
+		if err != nil {
+			c.logf(slog.LevelError, "netbug: checking for vulnerabilities: %v", err)
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.modules, c.findings, c.err = mods, findings, err
+		c.checked = time.Now()
+		c.checking = nil
+	}()
+	return done
+}
+
+// stale reports whether the last check's result should be replaced. c.mu
+// must be held.
+func (c *vulnChecker) stale() bool {
+	if c.checked.IsZero() {
+		return true
+	}
+	if c.err != nil {
+		return time.Since(c.checked) > vulnRetryAfter
+	}
+	return time.Since(c.checked) > vulnCacheFor
+}
+
+// ServeHTTP serves the result of the last check, starting another if it
+// is stale or, at most every vulnRefreshEvery, if asked to with the
+// refresh parameter.
+func (c *vulnChecker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	var done <-chan struct{}
+	if r.FormValue("refresh") != "" && time.Since(c.refreshed) > vulnRefreshEvery {
+		c.refreshed = time.Now()
+		done = c.check()
+	} else if c.stale() {
+		done = c.check()
+	}
+	if done != nil && c.checked.IsZero() {
+		c.mu.Unlock()
+		select {
+		case <-done:
+		case <-r.Context().Done():
+			return
+		}
+		c.mu.Lock()
+	}
+	report := struct {
+		Source   string        `json:"source"`
+		Checked  time.Time     `json:"checked"`
+		Checking bool          `json:"checking,omitempty"`
+		Modules  int           `json:"modules"`
+		Findings []vulnFinding `json:"findings"`
+		Error    string        `json:"error,omitempty"`
+	}{
+		Source:   c.source.String(),
+		Checked:  c.checked,
+		Checking: c.checking != nil,
+		Modules:  len(c.modules),
+		Findings: c.findings,
+	}
+	if c.err != nil {
+		report.Error = c.err.Error()
+	}
+	c.mu.Unlock()
+
+	if report.Findings == nil {
+		report.Findings = []vulnFinding{}
+	}
+	if responseFormat(w, r) == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			c.logf(slog.LevelWarn, "netbug: %v", err)
+		}
+		return
+	}
+	if err := vulnTmpl.Execute(w, report); err != nil {
+		c.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
+
+// buildModules returns the modules compiled into the binary, including
+// the standard library, with versions in the form used by OSV.
+func buildModules() []vulnModule {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return nil
+	}
+	var mods []vulnModule
+	if v := goSemver(bi.GoVersion); v != "" {
+		mods = append(mods, vulnModule{Path: "stdlib", Version: v})
+	}
+	if bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		mods = append(mods, vulnModule{Path: bi.Main.Path, Version: strings.TrimPrefix(bi.Main.Version, "v")})
+	}
+	for _, dep := range bi.Deps {
+		m := resolve(dep)
+		if m.Version == "" {
+			// Replaced by a local directory.
+			continue
+		}
+		mods = append(mods, vulnModule{Path: m.Path, Version: strings.TrimPrefix(m.Version, "v")})
+	}
+	return mods
+}
+
+// goSemver converts a Go release version such as "go1.21.3" or
+// "go1.22rc1" into the semantic version OSV uses for the standard
+// library.
+func goSemver(v string) string {
+	v = strings.TrimPrefix(v, "go")
+	if i := strings.IndexAny(v, " -"); i >= 0 {
+		// Drop experiment and development suffixes.
+		v = v[:i]
+	}
+	pre := ""
+	for _, tag := range []string{"rc", "beta", "alpha"} {
+		if i := strings.Index(v, tag); i >= 0 {
+			pre = "-" + tag + "." + v[i+len(tag):]
+			v = v[:i]
+			break
+		}
+	}
+	if v == "" {
+		return ""
+	}
+	if strings.Count(v, ".") == 1 {
+		v += ".0"
+	}
+	return v + pre
+}
+
+// compareSemver compares two semantic versions, with or without a leading
+// "v", returning -1, 0 or +1.
+func compareSemver(a, b string) int {
+	ac, ap := splitSemver(a)
+	bc, bp := splitSemver(b)
+	for i := 0; i < 3; i++ {
+		if ac[i] != bc[i] {
+			if ac[i] < bc[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	// A version without a pre-release has higher precedence.
+	switch {
+	case ap == "" && bp == "":
+		return 0
+	case ap == "":
+		return 1
+	case bp == "":
+		return -1
+	}
+
+	as, bs := strings.Split(ap, "."), strings.Split(bp, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+		switch {
+		case aerr == nil && berr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case aerr == nil:
+			return -1
+		case berr == nil:
+			return 1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// splitSemver splits v into its numeric core and pre-release, discarding
+// any build metadata.
+func splitSemver(v string) ([3]int, string) {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.Index(v, "+"); i >= 0 {
+		v = v[:i]
+	}
+	pre := ""
+	if i := strings.Index(v, "-"); i >= 0 {
+		v, pre = v[:i], v[i+1:]
+	}
+	var core [3]int
+	for i, p := range strings.SplitN(v, ".", 3) {
+		core[i], _ = strconv.Atoi(p)
+	}
+	return core, pre
+}
+
+// osvSnapshot is a vulnSource backed by a local copy of an OSV database.
+type osvSnapshot struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string][]*osvEntry // keyed by module path; nil until loaded
+}
+
+func (s *osvSnapshot) String() string { return "OSV snapshot " + s.path }
+
+func (s *osvSnapshot) check(ctx context.Context, mods []vulnModule) ([]vulnFinding, error) {
+	// The snapshot is loaded by the first check that finds it, so that
+	// one that is missing or being replaced when the process starts is
+	// tried again.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries == nil {
+		entries, err := loadOSVSnapshot(s.path)
+		if err != nil {
+			return nil, err
+		}
+		s.entries = entries
+	}
+	var findings []vulnFinding
+	for _, m := range mods {
+		for _, e := range s.entries[m.Path] {
+			if ok, fixed := e.affects(m.Path, m.Version); ok {
+				findings = append(findings, newFinding(m, e, fixed))
+			}
+		}
+	}
+	return findings, nil
+}
+
+// loadOSVSnapshot reads every OSV entry in the snapshot at path into
+// memory, keyed by module path.
+func loadOSVSnapshot(path string) (map[string][]*osvEntry, error) {
+	entries := make(map[string][]*osvEntry)
+	add := func(name string, r io.Reader) {
+		if strings.HasSuffix(name, ".gz") {
+			zr, err := gzip.NewReader(r)
+			if err != nil {
+				return
+			}
+			defer zr.Close()
+			r = zr
+			name = strings.TrimSuffix(name, ".gz")
+		}
+		if !strings.HasSuffix(name, ".json") {
+			return
+		}
+		var e osvEntry
+		// Index files and other JSON documents in the snapshot aren't
+		// entries, so failures to decode are ignored.
+		if json.NewDecoder(r).Decode(&e) != nil || e.ID == "" || e.Withdrawn != "" {
+			return
+		}
+		seen := make(map[string]bool)
+		for _, a := range e.Affected {
+			if a.Package.Ecosystem == "Go" && !seen[a.Package.Name] {
+				seen[a.Package.Name] = true
+				entries[a.Package.Name] = append(entries[a.Package.Name], &e)
+			}
+		}
+	}
+
+	if strings.HasSuffix(path, ".zip") {
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			add(f.Name, rc)
+			rc.Close()
+		}
+		return entries, nil
+	}
+
+	err := filepath.WalkDir(path, func(path string, de fs.DirEntry, err error) error {
+		if err != nil || de.IsDir() {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		add(path, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// osvAPI is a vulnSource backed by the OSV API.
+type osvAPI struct {
+	url string
+}
+
+func (a *osvAPI) String() string { return "OSV API " + a.url }
+
+func (a *osvAPI) check(ctx context.Context, mods []vulnModule) ([]vulnFinding, error) {
+	type query struct {
+		Package struct {
+			Name      string `json:"name"`
+			Ecosystem string `json:"ecosystem"`
+		} `json:"package"`
+		Version   string `json:"version"`
+		PageToken string `json:"page_token,omitempty"`
+	}
+	var req struct {
+		Queries []query `json:"queries"`
+	}
+	for _, m := range mods {
+		var q query
+		q.Package.Name, q.Package.Ecosystem, q.Version = m.Path, "Go", m.Version
+		req.Queries = append(req.Queries, q)
+	}
+
+	// The API returns a page of vulnerabilities for each query, with a
+	// token for the next if there are more, so the queries with more are
+	// made again until every page has been read.
+	ids := make([][]string, len(mods))
+	pending := make([]int, len(mods)) // the module each query is for
+	for i := range pending {
+		pending[i] = i
+	}
+	for len(req.Queries) > 0 {
+		var resp struct {
+			Results []struct {
+				Vulns []struct {
+					ID string `json:"id"`
+				} `json:"vulns"`
+				NextPageToken string `json:"next_page_token"`
+			} `json:"results"`
+		}
+		if err := a.do(ctx, "POST", "/v1/querybatch", req, &resp); err != nil {
+			return nil, err
+		}
+		var next []query
+		var nextPending []int
+		for i, res := range resp.Results {
+			if i >= len(pending) {
+				break
+			}
+			m := pending[i]
+			for _, v := range res.Vulns {
+				ids[m] = append(ids[m], v.ID)
+			}
+			if res.NextPageToken != "" {
+				q := req.Queries[i]
+				q.PageToken = res.NextPageToken
+				next = append(next, q)
+				nextPending = append(nextPending, m)
+			}
+		}
+		req.Queries, pending = next, nextPending
+	}
+
+	var findings []vulnFinding
+	entries := make(map[string]*osvEntry)
+	for i, vulns := range ids {
+		for _, id := range vulns {
+			e, ok := entries[id]
+			if !ok {
+				e = new(osvEntry)
+				if err := a.do(ctx, "GET", "/v1/vulns/"+id, nil, e); err != nil {
+					return nil, err
+				}
+				entries[id] = e
+			}
+			_, fixed := e.affects(mods[i].Path, mods[i].Version)
+			findings = append(findings, newFinding(mods[i], e, fixed))
+		}
+	}
+	return findings, nil
+}
+
+func (a *osvAPI) do(ctx context.Context, method, path string, body, v interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, a.url+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("netbug: OSV API %s %s: %s", method, path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func newFinding(m vulnModule, e *osvEntry, fixed string) vulnFinding {
+	summary := e.Summary
+	if summary == "" {
+		summary = strings.SplitN(e.Details, "\n", 2)[0]
+	}
+	aliases := append([]string(nil), e.Aliases...)
+	sort.Strings(aliases)
+	return vulnFinding{
+		Module:  m.Path,
+		Version: m.Version,
+		ID:      e.ID,
+		Aliases: aliases,
+		Summary: summary,
+		Fixed:   fixed,
+	}
+}
+
+var vulnTmpl = template.Must(template.New("vulns").Funcs(pageFuncs).Parse(`<html>
+  <head>
+    <title>Known Vulnerabilities</title>
+    {{pageHead}}
+  </head>
+  <body>
+    {{len .Findings}} known vulnerabilities in {{.Modules}} modules, checked against {{.Source}} at {{.Checked.Format "2006-01-02 15:04:05 MST"}}.<br>
+    {{if .Checking}}<p>The modules are being checked again; reload for the result.</p>{{end}}
+    {{if .Error}}<p>Error checking modules: {{.Error}}</p>{{end}}
+    <br>
+    <table>
+      <tr><th align=left>module<th align=left>version<th align=left>vulnerability<th align=left>fixed in<th align=left>summary
+    {{range .Findings}}
+      <tr><td>{{.Module}}<td>{{.Version}}<td>{{.ID}}{{range .Aliases}} {{.}}{{end}}<td>{{.Fixed}}<td>{{.Summary}}
+    {{end}}
+    </table>
+  </body>
+</html>`))
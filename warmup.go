@@ -0,0 +1,208 @@
+package netbug
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// WarmUp configures how a Debugger decides that the process has finished
+// warming up, so that deploy baselines captured by CaptureBaseline aren't
+// dominated by cold-start work such as filling caches and establishing
+// connections.
+//
+// The process is warm once it has been running for MinUptime, Ready (if
+// set) returns true, and the request rate reported by Requests (if set)
+// has stabilised.
+type WarmUp struct {
+	// MinUptime is how long the process must have been running. It
+	// defaults to 30 seconds.
+	MinUptime time.Duration
+
+	// Ready is called to ask the application whether it considers itself
+	// warm, for example once its caches are populated.
+	Ready func() bool
+
+	// Requests returns the number of requests the application has served
+	// since it started. The request rate is stable when the rates over the
+	// last two Windows differ by no more than Tolerance.
+	Requests func() uint64
+
+	// Window is the period request rates are measured over. It defaults
+	// to ten seconds.
+	Window time.Duration
+
+	// Tolerance is the largest relative difference between request rates
+	// that counts as stable. It defaults to 0.2.
+	Tolerance float64
+
+	// Timeout is how long CaptureBaseline waits for the process to warm
+	// up before capturing anyway. It defaults to five minutes.
+	Timeout time.Duration
+}
+
+// WithWarmUp makes CaptureBaseline, and so the deploy endpoint, wait for
+// the process to warm up before capturing. The state of the warm-up
+// heuristics is served at <prefix>deploy/warmup.
+func WithWarmUp(w WarmUp) Option {
+	if w.MinUptime <= 0 {
+		w.MinUptime = 30 * time.Second
+	}
+	if w.Window <= 0 {
+		w.Window = 10 * time.Second
+	}
+	if w.Tolerance <= 0 {
+		w.Tolerance = 0.2
+	}
+	if w.Timeout <= 0 {
+		w.Timeout = 5 * time.Minute
+	}
+	return func(d *Debugger) {
+		d.warmUp = &warmUpTracker{cfg: w}
+	}
+}
+
+// warmUpSample is the number of requests served at a point in time.
+type warmUpSample struct {
+	at       time.Time
+	requests uint64
+}
+
+// warmUpTracker evaluates the warm-up heuristics, keeping the request
+// counts needed to measure the request rate.
+type warmUpTracker struct {
+	cfg WarmUp
+
+	mu      sync.Mutex
+	samples []warmUpSample // oldest first, covering at most two windows
+	warm    bool           // once warm, the process stays warm
+}
+
+// warmUpState is the state of the warm-up heuristics.
+type warmUpState struct {
+	Warm      bool     `json:"warm"`
+	Uptime    float64  `json:"uptime_seconds"`
+	MinUptime float64  `json:"min_uptime_seconds"`
+	Ready     *bool    `json:"ready,omitempty"`
+	Rate      *float64 `json:"request_rate,omitempty"`
+	PrevRate  *float64 `json:"previous_request_rate,omitempty"`
+	Waiting   []string `json:"waiting_for,omitempty"`
+}
+
+// check samples the request count and evaluates the heuristics.
+func (t *warmUpTracker) check() warmUpState {
+	now := time.Now()
+	st := warmUpState{
+		Uptime:    now.Sub(started).Seconds(),
+		MinUptime: t.cfg.MinUptime.Seconds(),
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.warm {
+		st.Warm = true
+		return st
+	}
+
+	if now.Sub(started) < t.cfg.MinUptime {
+		st.Waiting = append(st.Waiting, "uptime")
+	}
+	if t.cfg.Ready != nil {
+		ready := t.cfg.Ready()
+		st.Ready = &ready
+		if !ready {
+			st.Waiting = append(st.Waiting, "application")
+		}
+	}
+	if t.cfg.Requests != nil {
+		t.samples = append(t.samples, warmUpSample{now, t.cfg.Requests()})
+		for len(t.samples) > 1 && now.Sub(t.samples[1].at) >= 2*t.cfg.Window {
+			t.samples = t.samples[1:]
+		}
+		cur, prev, ok := t.rates(now)
+		if ok {
+			st.Rate, st.PrevRate = &cur, &prev
+		}
+		if !ok || math.Abs(cur-prev) > t.cfg.Tolerance*math.Max(cur, prev) {
+			st.Waiting = append(st.Waiting, "request rate")
+		}
+	}
+
+	st.Warm = len(st.Waiting) == 0
+	t.warm = st.Warm
+	return st
+}
+
+// rates returns the request rates over the last window and the window
+// before it. ok is false until samples cover both windows.
+func (t *warmUpTracker) rates(now time.Time) (cur, prev float64, ok bool) {
+	first := t.samples[0]
+	if now.Sub(first.at) < 2*t.cfg.Window {
+		return 0, 0, false
+	}
+	last := t.samples[len(t.samples)-1]
+	mid := first
+	for _, s := range t.samples {
+		if now.Sub(s.at) < t.cfg.Window {
+			break
+		}
+		mid = s
+	}
+	rate := func(a, b warmUpSample) float64 {
+		if d := b.at.Sub(a.at).Seconds(); d > 0 {
+			return float64(b.requests-a.requests) / d
+		}
+		return 0
+	}
+	return rate(mid, last), rate(first, mid), true
+}
+
+// waitForWarmUp blocks until the process is warm, ctx is done or the
+// warm-up timeout elapses. It returns immediately if warm-up isn't
+// configured.
+func (d *Debugger) waitForWarmUp(ctx context.Context) error {
+	t := d.warmUp
+	if t == nil {
+		return nil
+	}
+	deadline := time.Now().Add(t.cfg.Timeout)
+	for {
+		st := t.check()
+		if st.Warm {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			d.logf(slog.LevelWarn, "netbug: not warmed up after %v, still waiting for %v; capturing anyway", t.cfg.Timeout, st.Waiting)
+			return nil
+		}
+		if err := sleep(ctx, time.Second); err != nil {
+			return err
+		}
+	}
+}
+
+// runWarmUp samples the request rate until the process is warm, so that
+// the rate can be measured as soon as a baseline is requested.
+func (d *Debugger) runWarmUp(ctx context.Context) {
+	for !d.warmUp.check().Warm {
+		if sleep(ctx, time.Second) != nil {
+			return
+		}
+	}
+}
+
+// warmUpStatus serves the state of the warm-up heuristics as JSON.
+func (d *Debugger) warmUpStatus(w http.ResponseWriter, r *http.Request) {
+	if d.warmUp == nil {
+		http.Error(w, "warm-up detection isn't configured", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(d.warmUp.check()); err != nil {
+		d.logf(slog.LevelWarn, "netbug: %v", err)
+	}
+}
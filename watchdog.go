@@ -0,0 +1,365 @@
+package netbug
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"time"
+)
+
+// CPUWatchdog configures a watchdog that captures a CPU profile and a full
+// goroutine stack dump when the process's CPU usage stays above a
+// threshold. Spikes often end before anyone gets the chance to request a
+// profile by hand.
+type CPUWatchdog struct {
+	// Threshold is the CPU usage, in percent, above which the watchdog
+	// fires. As with top, 100 means one CPU core is fully used.
+	Threshold float64
+
+	// For is how long usage must stay above Threshold before the
+	// watchdog fires. Zero fires on the first sample above Threshold.
+	For time.Duration
+
+	// Interval is how often CPU usage is sampled. It defaults to one
+	// second.
+	Interval time.Duration
+
+	// ProfileDuration is how long the CPU profile runs for. It defaults
+	// to 10 seconds.
+	ProfileDuration time.Duration
+
+	// Cooldown is the minimum time between captures. It defaults to ten
+	// minutes.
+	Cooldown time.Duration
+}
+
+// WithCPUWatchdog runs the provided watchdog while the Debugger is
+// started. Captures are kept in the Debugger's Store with the trigger
+// "watchdog:cpu".
+func WithCPUWatchdog(w CPUWatchdog) Option {
+	if w.Interval <= 0 {
+		w.Interval = time.Second
+	}
+	if w.ProfileDuration <= 0 {
+		w.ProfileDuration = 10 * time.Second
+	}
+	if w.Cooldown <= 0 {
+		w.Cooldown = 10 * time.Minute
+	}
+	return func(d *Debugger) {
+		d.cpuWatchdog = &w
+	}
+}
+
+func (w *CPUWatchdog) validate() error {
+	if w.Threshold <= 0 {
+		return errors.New("netbug: CPU watchdog threshold must be positive")
+	}
+	_, err := processCPUTime()
+	return err
+}
+
+// runCPUWatchdog samples CPU usage until ctx is done, capturing profiles
+// when it stays above the watchdog's threshold.
+func (d *Debugger) runCPUWatchdog(ctx context.Context, w *CPUWatchdog) {
+	t := time.NewTicker(w.Interval)
+	defer t.Stop()
+
+	prevCPU, _ := processCPUTime()
+	prev := time.Now()
+	var above, fired time.Time
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+
+		cpu, err := processCPUTime()
+		if err != nil {
+			d.logf(slog.LevelError, "netbug: CPU watchdog: %v", err)
+			continue
+		}
+		now := time.Now()
+		usage := 100 * float64(cpu-prevCPU) / float64(now.Sub(prev))
+		prevCPU, prev = cpu, now
+
+		if usage < w.Threshold {
+			above = time.Time{}
+			continue
+		}
+		if above.IsZero() {
+			above = now
+		}
+		if now.Sub(above) < w.For || (!fired.IsZero() && now.Sub(fired) < w.Cooldown) {
+			continue
+		}
+
+		d.logf(slog.LevelWarn, "netbug: CPU watchdog: usage %.0f%% above %.0f%% for %v, capturing profiles", usage, w.Threshold, now.Sub(above))
+		fired, above = now, time.Time{}
+		d.captureAll(ctx, "watchdog:cpu",
+			captureSpec{name: "profile", dur: w.ProfileDuration},
+			captureSpec{name: "goroutine", debug: 2},
+		)
+
+		// Don't count the capture itself towards the next sample.
+		prevCPU, _ = processCPUTime()
+		prev = time.Now()
+	}
+}
+
+// MemoryWatchdog configures a watchdog that captures a heap profile, and
+// optionally a full heap dump, when the process's memory use crosses a
+// threshold. This gives you something to look at from before the process
+// is OOM killed.
+type MemoryWatchdog struct {
+	// HeapInUse is the size of the in-use heap, in bytes, above which the
+	// watchdog fires. Zero disables the check.
+	HeapInUse uint64
+
+	// RSS is the resident set size of the process, in bytes, above which
+	// the watchdog fires. Zero disables the check. It is only supported
+	// on Linux.
+	RSS uint64
+
+	// Interval is how often memory use is checked. It defaults to five
+	// seconds.
+	Interval time.Duration
+
+	// Cooldown is the minimum time between captures, to prevent a storm
+	// of dumps from a process that stays above the threshold. It defaults
+	// to ten minutes.
+	Cooldown time.Duration
+
+	// HeapDump also writes a full heap dump, with debug.WriteHeapDump,
+	// to a file in HeapDumpDir. Writing a heap dump stops the world for
+	// a long time and needs as much disk space as the heap, so use it
+	// sparingly.
+	HeapDump bool
+
+	// HeapDumpDir is where heap dumps are written. It defaults to
+	// os.TempDir().
+	HeapDumpDir string
+}
+
+// WithMemoryWatchdog runs the provided watchdog while the Debugger is
+// started. Heap profiles are kept in the Debugger's Store with the
+// trigger "watchdog:memory".
+func WithMemoryWatchdog(w MemoryWatchdog) Option {
+	if w.Interval <= 0 {
+		w.Interval = 5 * time.Second
+	}
+	if w.Cooldown <= 0 {
+		w.Cooldown = 10 * time.Minute
+	}
+	if w.HeapDumpDir == "" {
+		w.HeapDumpDir = os.TempDir()
+	}
+	return func(d *Debugger) {
+		d.memWatchdog = &w
+	}
+}
+
+func (w *MemoryWatchdog) validate() error {
+	if w.HeapInUse == 0 && w.RSS == 0 {
+		return errors.New("netbug: memory watchdog needs a HeapInUse or RSS threshold")
+	}
+	if w.RSS > 0 {
+		if _, err := residentSetSize(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// heapInUse returns the number of bytes in in-use heap spans, as with
+// runtime.MemStats.HeapInuse, without stopping the world.
+func heapInUse() uint64 {
+	s := []metrics.Sample{
+		{Name: "/memory/classes/heap/objects:bytes"},
+		{Name: "/memory/classes/heap/unused:bytes"},
+	}
+	metrics.Read(s)
+	var n uint64
+	for _, v := range s {
+		if v.Value.Kind() == metrics.KindUint64 {
+			n += v.Value.Uint64()
+		}
+	}
+	return n
+}
+
+// runMemoryWatchdog checks memory use until ctx is done, capturing
+// profiles when it's above the watchdog's thresholds.
+func (d *Debugger) runMemoryWatchdog(ctx context.Context, w *MemoryWatchdog) {
+	t := time.NewTicker(w.Interval)
+	defer t.Stop()
+
+	var fired time.Time
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		if !fired.IsZero() && time.Since(fired) < w.Cooldown {
+			continue
+		}
+
+		var reason string
+		if w.HeapInUse > 0 {
+			if n := heapInUse(); n > w.HeapInUse {
+				reason = fmt.Sprintf("heap in use %d bytes above %d", n, w.HeapInUse)
+			}
+		}
+		if w.RSS > 0 && reason == "" {
+			n, err := residentSetSize()
+			if err != nil {
+				d.logf(slog.LevelError, "netbug: memory watchdog: %v", err)
+			} else if n > w.RSS {
+				reason = fmt.Sprintf("RSS %d bytes above %d", n, w.RSS)
+			}
+		}
+		if reason == "" {
+			continue
+		}
+
+		d.logf(slog.LevelWarn, "netbug: memory watchdog: %s, capturing profiles", reason)
+		fired = time.Now()
+		d.captureAll(ctx, "watchdog:memory", captureSpec{name: "heap"})
+		if w.HeapDump {
+			if path, err := writeHeapDump(w.HeapDumpDir); err != nil {
+				d.logf(slog.LevelError, "netbug: memory watchdog: writing heap dump: %v", err)
+			} else {
+				d.logf(slog.LevelInfo, "netbug: memory watchdog: wrote heap dump to %s", path)
+			}
+		}
+	}
+}
+
+// writeHeapDump writes a heap dump to a new file in dir, returning its
+// path.
+func writeHeapDump(dir string) (string, error) {
+	f, err := os.CreateTemp(dir, "heapdump-"+time.Now().UTC().Format("20060102T150405Z")+"-*")
+	if err != nil {
+		return "", err
+	}
+	debug.WriteHeapDump(f.Fd())
+	return f.Name(), f.Close()
+}
+
+// GoroutineWatchdog configures a watchdog that captures goroutine dumps
+// when the number of goroutines keeps growing, which catches leaks that
+// only become obvious overnight.
+//
+// The watchdog fires when the goroutine count has risen (or stayed the
+// same) for Samples consecutive samples, and is either above Threshold or
+// growing faster than Slope.
+type GoroutineWatchdog struct {
+	// Threshold is the number of goroutines above which sustained growth
+	// fires the watchdog. Zero disables the check.
+	Threshold int
+
+	// Slope is the growth rate, in goroutines per minute over the last
+	// Samples samples, above which sustained growth fires the watchdog.
+	// Zero disables the check.
+	Slope float64
+
+	// Samples is the number of consecutive non-decreasing samples that
+	// count as sustained growth. It defaults to 10.
+	Samples int
+
+	// Interval is how often the goroutines are counted. It defaults to
+	// one minute.
+	Interval time.Duration
+
+	// Cooldown is the minimum time between captures. It defaults to one
+	// hour.
+	Cooldown time.Duration
+}
+
+// WithGoroutineWatchdog runs the provided watchdog while the Debugger is
+// started. A full goroutine dump (debug=2) and a grouped goroutine profile
+// (debug=1), which later dumps can be compared with, are kept in the
+// Debugger's Store with the trigger "watchdog:goroutines".
+func WithGoroutineWatchdog(w GoroutineWatchdog) Option {
+	if w.Samples < 2 {
+		w.Samples = 10
+	}
+	if w.Interval <= 0 {
+		w.Interval = time.Minute
+	}
+	if w.Cooldown <= 0 {
+		w.Cooldown = time.Hour
+	}
+	return func(d *Debugger) {
+		d.goroutineWatchdog = &w
+	}
+}
+
+func (w *GoroutineWatchdog) validate() error {
+	if w.Threshold <= 0 && w.Slope <= 0 {
+		return errors.New("netbug: goroutine watchdog needs a Threshold or Slope")
+	}
+	return nil
+}
+
+// runGoroutineWatchdog counts goroutines until ctx is done, capturing
+// dumps when the count keeps growing.
+func (d *Debugger) runGoroutineWatchdog(ctx context.Context, w *GoroutineWatchdog) {
+	t := time.NewTicker(w.Interval)
+	defer t.Stop()
+
+	type sample struct {
+		at time.Time
+		n  int
+	}
+	var (
+		samples []sample
+		fired   time.Time
+	)
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+
+		s := sample{time.Now(), runtime.NumGoroutine()}
+		if len(samples) > 0 && s.n < samples[len(samples)-1].n {
+			// Growth has to be monotonic, so start again.
+			samples = samples[:0]
+		}
+		samples = append(samples, s)
+		if len(samples) > w.Samples {
+			samples = samples[1:]
+		}
+		if len(samples) < w.Samples || (!fired.IsZero() && time.Since(fired) < w.Cooldown) {
+			continue
+		}
+
+		first, last := samples[0], samples[len(samples)-1]
+		slope := float64(last.n-first.n) / last.at.Sub(first.at).Minutes()
+		var reason string
+		switch {
+		case w.Threshold > 0 && last.n > w.Threshold:
+			reason = fmt.Sprintf("%d goroutines above %d", last.n, w.Threshold)
+		case w.Slope > 0 && slope > w.Slope:
+			reason = fmt.Sprintf("goroutines growing at %.1f/min above %.1f/min", slope, w.Slope)
+		default:
+			continue
+		}
+
+		d.logf(slog.LevelWarn, "netbug: goroutine watchdog: %s after %d samples of growth, capturing dumps", reason, len(samples))
+		fired = time.Now()
+		d.captureAll(ctx, "watchdog:goroutines",
+			captureSpec{name: "goroutine", debug: 2},
+			captureSpec{name: "goroutine", debug: 1},
+		)
+	}
+}